@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+)
+
+// acceptBatch holds the sockets accepted by the main reactor until they are handed over to their event-loops
+// in batches.
+type acceptBatch struct {
+	mu      sync.Mutex
+	staged  map[*eventloop][]*acceptedSocket
+	timers  map[*eventloop]*time.Timer
+	stopped bool
+}
+
+// stageAccepted stages the accepted socket for the event-loop, the staged sockets are handed over to the event-loop
+// once there are AcceptBatchSize of them or AcceptBatchDelay elapses since the first of them.
+func (svr *server) stageAccepted(el *eventloop, as *acceptedSocket) {
+	ab := &svr.acceptBatch
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	if ab.stopped {
+		_ = unix.Close(as.fd)
+		return
+	}
+	el.addStaged(1)
+	if ab.staged == nil {
+		ab.staged = make(map[*eventloop][]*acceptedSocket)
+		ab.timers = make(map[*eventloop]*time.Timer)
+	}
+	sockets := append(ab.staged[el], as)
+	if len(sockets) >= svr.opts.AcceptBatchSize {
+		svr.dispatchAccepted(el, sockets)
+		return
+	}
+	ab.staged[el] = sockets
+	if len(sockets) == 1 {
+		delay := svr.opts.AcceptBatchDelay
+		if delay <= 0 {
+			delay = time.Millisecond
+		}
+		ab.timers[el] = time.AfterFunc(delay, func() {
+			ab.mu.Lock()
+			defer ab.mu.Unlock()
+			if sockets := ab.staged[el]; len(sockets) > 0 && !ab.stopped {
+				svr.dispatchAccepted(el, sockets)
+			}
+		})
+	}
+}
+
+// dispatchAccepted hands the staged sockets over to the event-loop, it must be called with the lock held.
+func (svr *server) dispatchAccepted(el *eventloop, sockets []*acceptedSocket) {
+	ab := &svr.acceptBatch
+	if timer := ab.timers[el]; timer != nil {
+		timer.Stop()
+	}
+	delete(ab.staged, el)
+	delete(ab.timers, el)
+	if err := el.poller.UrgentTrigger(el.loopSetupBatch, sockets); err != nil {
+		closeStaged(el, sockets)
+	}
+}
+
+// close closes the sockets that are still staged when the server shuts down.
+func (ab *acceptBatch) close() {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	ab.stopped = true
+	for el, sockets := range ab.staged {
+		ab.timers[el].Stop()
+		closeStaged(el, sockets)
+	}
+	ab.staged, ab.timers = nil, nil
+}
+
+// closeStaged closes the sockets staged for the event-loop which are not going to be set up.
+func closeStaged(el *eventloop, sockets []*acceptedSocket) {
+	for _, as := range sockets {
+		_ = unix.Close(as.fd)
+	}
+	el.addStaged(-int32(len(sockets)))
+}
+
+// loopSetupBatch sets up the batch of sockets accepted by the main reactor, each of them is set up on its own,
+// the rest of them are only given up if the server is shutting down.
+func (el *eventloop) loopSetupBatch(itf interface{}) (err error) {
+	sockets := itf.([]*acceptedSocket)
+	for i, as := range sockets {
+		e := el.loopSetup(as)
+		if e == gerrors.ErrServerShutdown {
+			closeStaged(el, sockets[i+1:])
+			return e
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
@@ -23,7 +23,10 @@
 package gnet
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -40,20 +43,47 @@ func (svr *server) acceptNewConnection(_ netpoll.IOEvent) error {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if svr.shedLoad(err) {
+			return nil
+		}
 		svr.opts.Logger.Errorf("Accept() fails due to error: %v", err)
+		svr.reportErr(os.NewSyscallError("accept", err))
 		return errors.ErrAcceptSocket
 	}
 	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 		return err
 	}
+	svr.checkFdLimit(nfd)
 
-	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	if svr.opts.TCPKeepAlive > 0 && svr.ln.network == "tcp" {
-		err = socket.SetKeepAlive(nfd, int(svr.opts.TCPKeepAlive/time.Second))
-		logging.LogErr(err)
+	// Refuse the connection while the buffered data exceeds MaxTotalBufferMemory.
+	if svr.overBufferBudget() {
+		_ = unix.Close(nfd)
+		return nil
 	}
 
+	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
 	el := svr.lb.next(netAddr)
+	if svr.opts.AcceptBatchSize > 1 {
+		svr.stageAccepted(el, &acceptedSocket{fd: nfd, sa: sa, addr: netAddr})
+		return nil
+	}
+	if svr.opts.DedicatedAcceptLoop {
+		// Leave the setup of the connection to its event-loop, keeping the main reactor to accepting only.
+		el.addStaged(1)
+		err = el.poller.UrgentTrigger(el.loopSetup, &acceptedSocket{fd: nfd, sa: sa, addr: netAddr})
+		if err != nil {
+			el.addStaged(-1)
+			_ = unix.Close(nfd)
+		}
+		return nil
+	}
+
+	svr.watchAcceptQueue(svr.ln)
+	if err = svr.initAccepted(nfd); err != nil {
+		svr.opts.Logger.Errorf("%v", err)
+		_ = unix.Close(nfd)
+		return nil
+	}
 	c := newTCPConn(nfd, el, sa, netAddr)
 
 	err = el.poller.UrgentTrigger(el.loopRegister, c)
@@ -74,19 +104,33 @@ func (el *eventloop) loopAccept(_ netpoll.IOEvent) error {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if el.svr.shedLoad(err) {
+			return nil
+		}
 		el.getLogger().Errorf("Accept() fails due to error: %v", err)
-		return os.NewSyscallError("accept", err)
+		err = os.NewSyscallError("accept", err)
+		el.svr.reportErr(err)
+		return err
 	}
 	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 		return err
 	}
+	el.svr.checkFdLimit(nfd)
 
-	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	if el.svr.opts.TCPKeepAlive > 0 && el.svr.ln.network == "tcp" {
-		err = socket.SetKeepAlive(nfd, int(el.svr.opts.TCPKeepAlive/time.Second))
-		logging.LogErr(err)
+	el.svr.watchAcceptQueue(el.ln)
+
+	// Refuse the connection while the buffered data exceeds MaxTotalBufferMemory.
+	if el.svr.overBufferBudget() {
+		_ = unix.Close(nfd)
+		return nil
+	}
+	if err = el.svr.initAccepted(nfd); err != nil {
+		el.getLogger().Errorf("%v", err)
+		_ = unix.Close(nfd)
+		return nil
 	}
 
+	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
 	c := newTCPConn(nfd, el, sa, netAddr)
 	if err = el.poller.AddRead(c.pollAttachment); err == nil {
 		el.connections[c.fd] = c
@@ -94,3 +138,57 @@ func (el *eventloop) loopAccept(_ netpoll.IOEvent) error {
 	}
 	return err
 }
+
+// acceptedSocket is the socket accepted by the main reactor with DedicatedAcceptLoop, which is set up by
+// the event-loop of the connection.
+type acceptedSocket struct {
+	fd   int
+	sa   unix.Sockaddr
+	addr net.Addr
+}
+
+// loopSetup sets up the connection of the socket accepted by the main reactor and registers it.
+func (el *eventloop) loopSetup(itf interface{}) error {
+	as := itf.(*acceptedSocket)
+	el.addStaged(-1)
+	el.svr.watchAcceptQueue(el.svr.ln)
+	if err := el.svr.initAccepted(as.fd); err != nil {
+		el.getLogger().Errorf("%v", err)
+		_ = unix.Close(as.fd)
+		return nil
+	}
+	return el.loopRegister(newTCPConn(as.fd, el, as.sa, as.addr))
+}
+
+// initAccepted applies the options to the accepted socket, the socket should be closed if it returns an error.
+func (svr *server) initAccepted(fd int) error {
+	if control := svr.opts.AcceptedSocketControl; control != nil {
+		if err := control(uintptr(fd)); err != nil {
+			return fmt.Errorf("failed to control the accepted socket: %v", err)
+		}
+	}
+	if svr.opts.TCPKeepAlive > 0 && svr.ln.network == "tcp" {
+		logging.LogErr(socket.SetKeepAlive(fd, int(svr.opts.TCPKeepAlive/time.Second)))
+	}
+	return nil
+}
+
+// watchAcceptQueue logs a warning once the accept queue of the listener reaches AcceptQueueThreshold.
+func (svr *server) watchAcceptQueue(ln *listener) {
+	threshold := svr.opts.AcceptQueueThreshold
+	if threshold <= 0 {
+		return
+	}
+	n, err := ln.acceptQueueLen()
+	if err != nil {
+		return
+	}
+	if n < threshold {
+		atomic.StoreInt32(&svr.acceptQueued, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&svr.acceptQueued, 0, 1) {
+		svr.opts.Logger.Warnf("accept queue of listener(%s) reaches %d, the server can't keep up with new connections",
+			ln.addr, n)
+	}
+}
@@ -22,7 +22,9 @@
 package gnet
 
 import (
+	"net"
 	"runtime"
+	"syscall"
 	"time"
 )
 
@@ -34,14 +36,15 @@ func (svr *server) listenerRun(lockOSThread bool) {
 
 	var err error
 	defer func() { svr.signalShutdownWithErr(err) }()
-	var buffer [0x10000]byte
+	buffer := make([]byte, svr.opts.UDPMaxDatagramSize)
 	for {
 		if svr.ln.pconn != nil {
-			// Read data from UDP socket.
-			n, addr, e := svr.ln.pconn.ReadFrom(buffer[:])
+			// Read data from UDP socket into the reused buffer, only the payload is copied for the event-loop.
+			n, addr, e := svr.ln.pconn.ReadFrom(buffer)
 			if e != nil {
 				err = e
 				svr.opts.Logger.Errorf("failed to receive data from UDP fd due to error:%v", err)
+				svr.reportErr(err)
 				return
 			}
 
@@ -54,8 +57,14 @@ func (svr *server) listenerRun(lockOSThread bool) {
 			if e != nil {
 				err = e
 				svr.opts.Logger.Errorf("Accept() fails due to error: %v", err)
+				svr.reportErr(err)
 				return
 			}
+			if err := svr.controlAccepted(conn); err != nil {
+				svr.opts.Logger.Errorf("failed to control the accepted socket: %v", err)
+				_ = conn.Close()
+				continue
+			}
 			el := svr.lb.next(conn.RemoteAddr())
 			c := newTCPConn(conn, el)
 			el.ch <- c
@@ -68,9 +77,27 @@ func (svr *server) listenerRun(lockOSThread bool) {
 						el.ch <- &stderr{c, err}
 						return
 					}
+					c.pushReadDeadline()
 					el.ch <- packTCPConn(c, buffer[:n])
 				}
 			}()
 		}
 	}
 }
+
+// controlAccepted invokes AcceptedSocketControl on the socket of the accepted connection.
+func (svr *server) controlAccepted(conn net.Conn) (err error) {
+	control := svr.opts.AcceptedSocketControl
+	sc, ok := conn.(syscall.Conn)
+	if control == nil || !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if e := rc.Control(func(fd uintptr) { err = control(fd) }); e != nil {
+		err = e
+	}
+	return
+}
@@ -38,18 +38,59 @@ type (
 		// Encode encodes frames upon server responses into TCP stream.
 		Encode(c Conn, buf []byte) ([]byte, error)
 		// Decode decodes frames from TCP stream via specific implementation.
+		//
+		// Decode should return a nil frame with either a nil error or errors.ErrUnexpectedEOF when there is not
+		// enough data to decode a complete frame, any other error is regarded as a failure of decoding and will be
+		// reported to EventHandler.OnDecodeError.
 		Decode(c Conn) ([]byte, error)
 	}
 
+	// Resyncer is an optional interface that can be implemented by an ICodec whose frames have recognizable
+	// boundaries, it allows the stream to recover from a corrupted frame instead of closing the connection.
+	Resyncer interface {
+		// Resync is called after a failure of decoding with all the bytes buffered in the connection and returns
+		// the number of bytes to skip for reaching the next valid frame boundary, then the decoding will be retried.
+		// EventHandler.OnDecodeError will be invoked if it returns a non-nil error or a non-positive skip.
+		Resync(buffered []byte) (skip int, err error)
+	}
+
+	// DatagramCodec is an optional interface that can be implemented by an ICodec to decode/encode UDP datagrams,
+	// which are otherwise passed to React as they are and the data returned by React is sent back as it is.
+	DatagramCodec interface {
+		// DecodeDatagram decodes the frames carried by a datagram, React fires for each of them,
+		// the datagram is dropped if an error is returned after EventHandler.OnDecodeError is invoked.
+		DecodeDatagram(c Conn, packet []byte) (frames [][]byte, err error)
+
+		// EncodeDatagram encodes the data returned by React into a datagram.
+		EncodeDatagram(c Conn, buf []byte) ([]byte, error)
+	}
+
 	// BuiltInFrameCodec is the built-in codec which will be assigned to gnet server when customized codec is not set up.
 	BuiltInFrameCodec struct{}
 
 	// LineBasedFrameCodec encodes/decodes line-separated frames into/from TCP stream.
-	LineBasedFrameCodec struct{}
+	LineBasedFrameCodec struct {
+		// IncludeDelimiter indicates whether the trailing '\n' is kept in the decoded frames, it is stripped
+		// by default. Encode appends '\n' to the data unless IncludeDelimiter is set and the data already ends
+		// with it, so that echoing the decoded frames back yields the same lines in both modes.
+		IncludeDelimiter bool
+	}
+
+	// LineCodec encodes/decodes line-separated frames into/from TCP stream, lines are always split on '\n',
+	// and the trailing '\r' of each line is stripped optionally so that both "\n" and "\r\n" line endings
+	// are accepted, a lone '\r' which is not followed by '\n' is kept in the frame as it is.
+	LineCodec struct {
+		stripCR bool
+	}
 
 	// DelimiterBasedFrameCodec encodes/decodes specific-delimiter-separated frames into/from TCP stream.
 	DelimiterBasedFrameCodec struct {
 		delimiter byte
+
+		// IncludeDelimiter indicates whether the trailing delimiter is kept in the decoded frames, it is stripped
+		// by default. Encode appends the delimiter to the data unless IncludeDelimiter is set and the data already
+		// ends with it, so that echoing the decoded frames back yields the same frames in both modes.
+		IncludeDelimiter bool
 	}
 
 	// FixedLengthFrameCodec encodes/decodes fixed-length-separated frames into/from TCP stream.
@@ -66,6 +107,67 @@ type (
 	}
 )
 
+// isIncompleteFrame reports whether the error returned by ICodec.Decode merely indicates that
+// the data in buffers is not enough to decode a complete frame.
+func isIncompleteFrame(err error) bool {
+	switch err {
+	case nil, errorset.ErrUnexpectedEOF, errorset.ErrCRLFNotFound, errorset.ErrDelimiterNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// codecStateHolder is implemented by the connections that keep the state of codecs between the calls of Decode,
+// which spares the codecs shared by all connections from looking it up in a map guarded by a lock on every frame.
+type codecStateHolder interface {
+	codecState(codec ICodec) interface{}
+	setCodecState(codec ICodec, state interface{})
+}
+
+// codecStates is embedded in connections to keep the state of each codec engaged on the connection, it is only
+// accessed on the event-loop and released along with the connection.
+type codecStates []codecState
+
+type codecState struct {
+	codec ICodec
+	state interface{}
+}
+
+func (s codecStates) codecState(codec ICodec) interface{} {
+	for i := range s {
+		if s[i].codec == codec {
+			return s[i].state
+		}
+	}
+	return nil
+}
+
+func (s *codecStates) setCodecState(codec ICodec, state interface{}) {
+	for i := range *s {
+		if (*s)[i].codec == codec {
+			(*s)[i].state = state
+			return
+		}
+	}
+	*s = append(*s, codecState{codec, state})
+}
+
+// stateOf returns the state of the codec kept by the connection, which is created by newState on the first call.
+// A connection keeping no state, e.g. a custom implementation of Conn, gets a new state on every call.
+func stateOf(c Conn, codec ICodec, newState func() interface{}) interface{} {
+	h, ok := c.(codecStateHolder)
+	if !ok {
+		return newState()
+	}
+	state := h.codecState(codec)
+	if state == nil {
+		state = newState()
+		h.setCodecState(codec, state)
+	}
+	return state
+}
+
 // Encode ...
 func (cc *BuiltInFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 	return buf, nil
@@ -83,7 +185,7 @@ func (cc *BuiltInFrameCodec) Decode(c Conn) ([]byte, error) {
 
 // Encode ...
 func (cc *LineBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
-	return append(buf, CRLFByte), nil
+	return appendDelimiter(buf, CRLFByte, cc.IncludeDelimiter), nil
 }
 
 // Decode ...
@@ -94,17 +196,45 @@ func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 		return nil, errorset.ErrCRLFNotFound
 	}
 	c.ShiftN(idx + 1)
+	if cc.IncludeDelimiter {
+		return buf[:idx+1], nil
+	}
 	return buf[:idx], nil
 }
 
+// NewLineCodec instantiates and returns a line codec, the trailing '\r' of each line is stripped if stripCR is true.
+func NewLineCodec(stripCR bool) *LineCodec {
+	return &LineCodec{stripCR: stripCR}
+}
+
+// Encode ...
+func (cc *LineCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return append(buf, CRLFByte), nil
+}
+
+// Decode ...
+func (cc *LineCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	idx := bytes.IndexByte(buf, CRLFByte)
+	if idx == -1 {
+		return nil, errorset.ErrCRLFNotFound
+	}
+	c.ShiftN(idx + 1)
+	line := buf[:idx]
+	if cc.stripCR && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
 // NewDelimiterBasedFrameCodec instantiates and returns a codec with a specific delimiter.
 func NewDelimiterBasedFrameCodec(delimiter byte) *DelimiterBasedFrameCodec {
-	return &DelimiterBasedFrameCodec{delimiter}
+	return &DelimiterBasedFrameCodec{delimiter: delimiter}
 }
 
 // Encode ...
 func (cc *DelimiterBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
-	return append(buf, cc.delimiter), nil
+	return appendDelimiter(buf, cc.delimiter, cc.IncludeDelimiter), nil
 }
 
 // Decode ...
@@ -115,9 +245,21 @@ func (cc *DelimiterBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 		return nil, errorset.ErrDelimiterNotFound
 	}
 	c.ShiftN(idx + 1)
+	if cc.IncludeDelimiter {
+		return buf[:idx+1], nil
+	}
 	return buf[:idx], nil
 }
 
+// appendDelimiter terminates the data with the delimiter, the data that already ends with the delimiter is left
+// as it is if the decoded frames include the delimiter.
+func appendDelimiter(buf []byte, delimiter byte, included bool) []byte {
+	if included && len(buf) > 0 && buf[len(buf)-1] == delimiter {
+		return buf
+	}
+	return append(buf, delimiter)
+}
+
 // NewFixedLengthFrameCodec instantiates and returns a codec with fixed length.
 func NewFixedLengthFrameCodec(frameLength int) *FixedLengthFrameCodec {
 	return &FixedLengthFrameCodec{frameLength}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// The types of AMQP 0-9-1 frames.
+const (
+	AMQPFrameMethod    byte = 1
+	AMQPFrameHeader    byte = 2
+	AMQPFrameBody      byte = 3
+	AMQPFrameHeartbeat byte = 8
+)
+
+const (
+	// AMQPFrameEnd is the octet terminating every AMQP frame.
+	AMQPFrameEnd byte = 0xCE
+	// DefaultAMQPMaxFrameSize is the default limit of the size of AMQP frames, which is the default frame-max
+	// of RabbitMQ.
+	DefaultAMQPMaxFrameSize = 128 * 1024
+
+	// amqpHeaderLen is the length of the frame header: type, channel and payload size.
+	amqpHeaderLen = 7
+	// amqpOverhead is the length of the frame header and the frame-end octet.
+	amqpOverhead = amqpHeaderLen + 1
+)
+
+// AMQPProtocolHeader is the protocol header which an AMQP 0-9-1 client sends ahead of any frames.
+var AMQPProtocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+// AMQPCodec encodes/decodes AMQP 0-9-1 frames into/from TCP stream, every decoded frame is a complete frame including
+// the header and the frame-end octet, which can be parsed by ParseAMQPFrame. The protocol header opening a connection
+// is decoded as a frame of its own, so that the server is able to reply Connection.Start to it in React, whereas
+// a protocol header of another version is rejected by errors.ErrInvalidAMQPProtocolHeader, in which case the server
+// is supposed to write AMQPProtocolHeader back and close the connection. Frames exceeding the limit of size are
+// rejected by errors.ErrAMQPFrameTooLarge before being buffered up, and frames of an unknown type or without
+// the frame-end octet are rejected by errors.ErrInvalidAMQPFrame.
+type AMQPCodec struct {
+	maxFrameSize int
+}
+
+// NewAMQPCodec instantiates and returns a codec for AMQP 0-9-1 which limits the size of frames to
+// DefaultAMQPMaxFrameSize.
+func NewAMQPCodec() *AMQPCodec {
+	return NewAMQPCodecWithMaxFrameSize(DefaultAMQPMaxFrameSize)
+}
+
+// NewAMQPCodecWithMaxFrameSize instantiates and returns a codec for AMQP 0-9-1 with the given limit of the size of
+// frames, which is supposed to be the frame-max negotiated by Connection.Tune, DefaultAMQPMaxFrameSize is used if
+// maxFrameSize is not positive.
+func NewAMQPCodecWithMaxFrameSize(maxFrameSize int) *AMQPCodec {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultAMQPMaxFrameSize
+	}
+	return &AMQPCodec{maxFrameSize: maxFrameSize}
+}
+
+// Encode validates buf as a complete frame built by AppendAMQPFrame or the protocol header, and passes it through.
+func (cc *AMQPCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if bytes.Equal(buf, AMQPProtocolHeader) {
+		return buf, nil
+	}
+	if _, _, _, err := ParseAMQPFrame(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Decode ...
+func (cc *AMQPCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	// No frame type starts with 'A', which tells the protocol header apart from frames.
+	if buf[0] == 'A' {
+		n := len(AMQPProtocolHeader)
+		if len(buf) < n {
+			if !bytes.HasPrefix(AMQPProtocolHeader, buf) {
+				return nil, errorset.ErrInvalidAMQPProtocolHeader
+			}
+			return nil, errorset.ErrUnexpectedEOF
+		}
+		if !bytes.Equal(buf[:n], AMQPProtocolHeader) {
+			return nil, errorset.ErrInvalidAMQPProtocolHeader
+		}
+		c.ShiftN(n)
+		return buf[:n], nil
+	}
+	if !isAMQPFrameType(buf[0]) {
+		return nil, errorset.ErrInvalidAMQPFrame
+	}
+	if len(buf) < amqpHeaderLen {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	size := binary.BigEndian.Uint32(buf[3:amqpHeaderLen])
+	if uint64(size)+amqpOverhead > uint64(cc.maxFrameSize) {
+		return nil, errorset.ErrAMQPFrameTooLarge
+	}
+	frameLen := amqpOverhead + int(size)
+	if len(buf) < frameLen {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	if buf[frameLen-1] != AMQPFrameEnd {
+		return nil, errorset.ErrInvalidAMQPFrame
+	}
+	c.ShiftN(frameLen)
+	return buf[:frameLen], nil
+}
+
+// AppendAMQPFrame appends the AMQP frame of the given type, channel and payload to dst and returns the result.
+func AppendAMQPFrame(dst []byte, typ byte, channel uint16, payload []byte) []byte {
+	var header [amqpHeaderLen]byte
+	header[0] = typ
+	binary.BigEndian.PutUint16(header[1:], channel)
+	binary.BigEndian.PutUint32(header[3:], uint32(len(payload)))
+	dst = append(dst, header[:]...)
+	dst = append(dst, payload...)
+	return append(dst, AMQPFrameEnd)
+}
+
+// ParseAMQPFrame parses the complete AMQP frame decoded by AMQPCodec, the payload refers to the memory of frame.
+func ParseAMQPFrame(frame []byte) (typ byte, channel uint16, payload []byte, err error) {
+	if len(frame) < amqpOverhead || !isAMQPFrameType(frame[0]) || frame[len(frame)-1] != AMQPFrameEnd ||
+		uint64(binary.BigEndian.Uint32(frame[3:amqpHeaderLen]))+amqpOverhead != uint64(len(frame)) {
+		return 0, 0, nil, errorset.ErrInvalidAMQPFrame
+	}
+	return frame[0], binary.BigEndian.Uint16(frame[1:3]), frame[amqpHeaderLen : len(frame)-1], nil
+}
+
+func isAMQPFrameType(typ byte) bool {
+	switch typ {
+	case AMQPFrameMethod, AMQPFrameHeader, AMQPFrameBody, AMQPFrameHeartbeat:
+		return true
+	}
+	return false
+}
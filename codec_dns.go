@@ -0,0 +1,91 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"encoding/binary"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+const (
+	// dnsHeaderLen is the length of the fixed header of a DNS message.
+	dnsHeaderLen = 12
+	// dnsLengthFieldLen is the length of the prefix that frames DNS messages over TCP, see RFC 1035, section 4.2.2.
+	dnsLengthFieldLen = 2
+	// dnsMaxMessageSize is the maximum size of a DNS message that can be framed with the 2-byte length prefix.
+	dnsMaxMessageSize = 1<<16 - 1
+)
+
+// DNSCodec encodes/decodes DNS messages, every datagram is a message over UDP
+// while messages are framed by a 2-byte length prefix in big-endian order over TCP.
+type DNSCodec struct{}
+
+// NewDNSCodec instantiates and returns a codec for DNS messages.
+func NewDNSCodec() *DNSCodec {
+	return &DNSCodec{}
+}
+
+// Encode ...
+func (cc *DNSCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if len(buf) < dnsHeaderLen || len(buf) > dnsMaxMessageSize {
+		return nil, errorset.ErrInvalidDNSMessage
+	}
+	out := make([]byte, dnsLengthFieldLen+len(buf))
+	binary.BigEndian.PutUint16(out, uint16(len(buf)))
+	copy(out[dnsLengthFieldLen:], buf)
+	return out, nil
+}
+
+// Decode ...
+func (cc *DNSCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) < dnsLengthFieldLen {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	msgLen := int(binary.BigEndian.Uint16(buf))
+	if msgLen < dnsHeaderLen {
+		c.ShiftN(dnsLengthFieldLen)
+		return nil, errorset.ErrInvalidDNSMessage
+	}
+	frameLen := dnsLengthFieldLen + msgLen
+	if len(buf) < frameLen {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	c.ShiftN(frameLen)
+	return buf[dnsLengthFieldLen:frameLen], nil
+}
+
+// DecodeDatagram ...
+func (cc *DNSCodec) DecodeDatagram(c Conn, packet []byte) ([][]byte, error) {
+	if len(packet) < dnsHeaderLen {
+		return nil, errorset.ErrInvalidDNSMessage
+	}
+	return [][]byte{packet}, nil
+}
+
+// EncodeDatagram ...
+func (cc *DNSCodec) EncodeDatagram(c Conn, buf []byte) ([]byte, error) {
+	if len(buf) < dnsHeaderLen || len(buf) > dnsMaxMessageSize {
+		return nil, errorset.ErrInvalidDNSMessage
+	}
+	return buf, nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// encryptedHeaderLen is the length of the header that precedes the sealed frame: 4 bytes of length in big-endian order.
+const encryptedHeaderLen = 4
+
+// DefaultEncryptedMaxFrameSize is the default limit of the size of sealed frames.
+const DefaultEncryptedMaxFrameSize = 4 * 1024 * 1024
+
+// EncryptedCodec encrypts and authenticates every frame encoded by the inner codec with ChaCha20-Poly1305,
+// and verifies and decrypts every sealed frame before it is decoded by the inner codec.
+//
+// Each sealed frame is laid out as: 4 bytes of length in big-endian order, 12 bytes of random nonce
+// and the ciphertext along with its 16 bytes of authentication tag. The decrypted data is a stream to the inner
+// codec: the plaintext left over by the inner codec is held for the next sealed frame, thus a frame of the inner
+// codec may span several sealed frames and a sealed frame may carry several of them. Sealed frames exceeding
+// the limit of size are rejected by errors.ErrEncryptedFrameTooLarge before being buffered up, and so is
+// the plaintext held for the inner codec.
+// Note that EncryptedCodec doesn't prevent sealed frames from being replayed, it is meant for trusted networks,
+// use TLS in front of gnet for a full-fledged secure channel.
+type EncryptedCodec struct {
+	inner        ICodec
+	aead         cipher.AEAD
+	maxFrameSize int
+}
+
+// NewEncryptedCodec instantiates and returns a codec that encrypts the frames of the inner codec with a 32-byte key,
+// with DefaultEncryptedMaxFrameSize as the limit of size.
+func NewEncryptedCodec(inner ICodec, key []byte) (*EncryptedCodec, error) {
+	return NewEncryptedCodecWithMaxFrameSize(inner, key, DefaultEncryptedMaxFrameSize)
+}
+
+// NewEncryptedCodecWithMaxFrameSize instantiates and returns a codec that encrypts the frames of the inner codec with
+// a 32-byte key, with the given limit of the size of sealed frames, DefaultEncryptedMaxFrameSize is used if
+// maxFrameSize is not positive.
+func NewEncryptedCodecWithMaxFrameSize(inner ICodec, key []byte, maxFrameSize int) (*EncryptedCodec, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultEncryptedMaxFrameSize
+	}
+	return &EncryptedCodec{inner: inner, aead: aead, maxFrameSize: maxFrameSize}, nil
+}
+
+// Encode ...
+func (cc *EncryptedCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	plaintext, err := cc.inner.Encode(c, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := cc.aead.NonceSize()
+	out := make([]byte, encryptedHeaderLen+nonceSize, encryptedHeaderLen+nonceSize+len(plaintext)+cc.aead.Overhead())
+	nonce := out[encryptedHeaderLen:]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = cc.aead.Seal(out, nonce, plaintext, nil)
+	binary.BigEndian.PutUint32(out, uint32(len(out)-encryptedHeaderLen))
+	return out, nil
+}
+
+// Decode ...
+func (cc *EncryptedCodec) Decode(c Conn) ([]byte, error) {
+	held := cc.heldPlaintext(c)
+	for {
+		if len(*held) > 0 {
+			fc := &frameConn{Conn: c, buf: *held}
+			frame, err := cc.inner.Decode(fc)
+			if !isIncompleteFrame(err) {
+				*held = nil
+				return nil, err
+			}
+			if frame != nil {
+				*held = fc.buf
+				return frame, nil
+			}
+		}
+
+		buf := c.Read()
+		if len(buf) < encryptedHeaderLen {
+			return nil, errorset.ErrUnexpectedEOF
+		}
+		sealedLen := int(binary.BigEndian.Uint32(buf))
+		if sealedLen < cc.aead.NonceSize()+cc.aead.Overhead() {
+			c.ShiftN(encryptedHeaderLen)
+			return nil, errorset.ErrFrameAuthentication
+		}
+		if sealedLen > cc.maxFrameSize || len(*held)+sealedLen > cc.maxFrameSize {
+			return nil, errorset.ErrEncryptedFrameTooLarge
+		}
+		frameLen := encryptedHeaderLen + sealedLen
+		if len(buf) < frameLen {
+			return nil, errorset.ErrUnexpectedEOF
+		}
+
+		sealed := buf[encryptedHeaderLen:frameLen]
+		nonce, ciphertext := sealed[:cc.aead.NonceSize()], sealed[cc.aead.NonceSize():]
+		// The plaintext is appended to the held one, which leaves the frames decoded from it before intact.
+		plaintext, err := cc.aead.Open(*held, nonce, ciphertext, nil)
+		c.ShiftN(frameLen)
+		if err != nil {
+			*held = nil
+			return nil, errorset.ErrFrameAuthentication
+		}
+		*held = plaintext
+	}
+}
+
+// heldPlaintext returns the plaintext left over by the inner codec, which is kept by the connection.
+func (cc *EncryptedCodec) heldPlaintext(c Conn) *[]byte {
+	return stateOf(c, cc, func() interface{} { return new([]byte) }).(*[]byte)
+}
+
+// frameConn exposes a standalone frame as the inbound data of a connection,
+// which allows the inner codec to decode frames that have been unwrapped by the outer codec.
+type frameConn struct {
+	Conn
+	buf []byte
+}
+
+func (c *frameConn) Read() []byte {
+	return c.buf
+}
+
+func (c *frameConn) ResetBuffer() {
+	c.buf = nil
+}
+
+func (c *frameConn) ReadN(n int) (size int, buf []byte) {
+	if n <= 0 || n > len(c.buf) {
+		n = len(c.buf)
+	}
+	return n, c.buf[:n]
+}
+
+func (c *frameConn) ShiftN(n int) (size int) {
+	if n <= 0 || n > len(c.buf) {
+		n = len(c.buf)
+	}
+	c.buf = c.buf[n:]
+	return n
+}
+
+func (c *frameConn) BufferLength() (size int) {
+	return len(c.buf)
+}
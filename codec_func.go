@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import errorset "github.com/panjf2000/gnet/errors"
+
+// FuncCodec adapts a pair of functions to ICodec, which saves the trouble of implementing a full codec for
+// simple protocols.
+//
+// The decode function is given all the inbound data buffered so far and returns the frame along with the number of
+// bytes it consumes, which are discarded from the inbound buffer even if an error is returned. Returning a nil frame
+// without consuming anything means more data is needed, while returning a nil frame with some bytes consumed skips
+// them and decodes the rest right away. The frame may refer to the input data.
+type FuncCodec struct {
+	decode func(buf []byte) (frame []byte, consumed int, err error)
+	encode func(in []byte) ([]byte, error)
+}
+
+// NewFuncCodec instantiates and returns a codec with the given functions, the data is sent back as it is if
+// encode is nil.
+func NewFuncCodec(decode func(buf []byte) (frame []byte, consumed int, err error),
+	encode func(in []byte) ([]byte, error)) *FuncCodec {
+	return &FuncCodec{decode: decode, encode: encode}
+}
+
+// Encode ...
+func (cc *FuncCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if cc.encode == nil {
+		return buf, nil
+	}
+	return cc.encode(buf)
+}
+
+// Decode ...
+func (cc *FuncCodec) Decode(c Conn) ([]byte, error) {
+	for {
+		buf := c.Read()
+		if len(buf) == 0 {
+			return nil, nil
+		}
+		frame, consumed, err := cc.decode(buf)
+		if consumed < 0 || consumed > len(buf) {
+			return nil, errorset.ErrInvalidConsumed
+		}
+		if consumed > 0 {
+			c.ShiftN(consumed)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil || consumed == 0 {
+			return frame, nil
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import errorset "github.com/panjf2000/gnet/errors"
+
+const (
+	// quicDatagramFrame is the type of the QUIC DATAGRAM frame without the Length field, see RFC 9221,
+	// whose payload extends to the end of the packet.
+	quicDatagramFrame = 0x30
+	// quicDatagramFrameWithLength is the type of the QUIC DATAGRAM frame with the Length field.
+	quicDatagramFrameWithLength = 0x31
+	// quicMaxVarint is the maximum value of the QUIC variable-length integer.
+	quicMaxVarint = 1<<62 - 1
+)
+
+// HTTP3DatagramCodec encodes/decodes HTTP/3 datagrams (RFC 9297) of a session carried by QUIC DATAGRAM frames.
+//
+// EXPERIMENTAL: this codec is meant for experimenting with the datagram framing and its API may change or be removed
+// in future releases. It is not a QUIC implementation, the QUIC packet headers, packet protection, handshakes and
+// streams are all out of scope, which means that it only works with peers exchanging unprotected frames, e.g. behind
+// a proxy terminating QUIC.
+//
+// On UDP servers, every datagram is expected to be the payload of a QUIC packet which carries one or more DATAGRAM
+// frames, React fires for each HTTP/3 datagram of the session with its payload and the data returned by React is sent
+// back in a DATAGRAM frame. On stream-oriented servers, the DATAGRAM frames must carry the Length field.
+type HTTP3DatagramCodec struct {
+	quarterStreamID uint64
+}
+
+// NewHTTP3DatagramCodec instantiates and returns a codec for the HTTP/3 datagrams associated with the request stream
+// of a session, which must be a client-initiated bidirectional stream.
+func NewHTTP3DatagramCodec(streamID uint64) (*HTTP3DatagramCodec, error) {
+	if streamID%4 != 0 || streamID > quicMaxVarint {
+		return nil, errorset.ErrHTTP3DatagramStream
+	}
+	return &HTTP3DatagramCodec{quarterStreamID: streamID / 4}, nil
+}
+
+// Encode ...
+func (cc *HTTP3DatagramCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	length := uint64(quicVarintLen(cc.quarterStreamID) + len(buf))
+	out := make([]byte, 0, 1+quicVarintLen(length)+int(length))
+	out = append(out, quicDatagramFrameWithLength)
+	out = appendQUICVarint(out, length)
+	out = appendQUICVarint(out, cc.quarterStreamID)
+	return append(out, buf...), nil
+}
+
+// Decode ...
+func (cc *HTTP3DatagramCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	typ, n, ok := readQUICVarint(buf)
+	if !ok {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	if typ != quicDatagramFrameWithLength {
+		// There is no way to find the next frame in the stream.
+		c.ResetBuffer()
+		return nil, errorset.ErrInvalidHTTP3Datagram
+	}
+	length, m, ok := readQUICVarint(buf[n:])
+	if !ok {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	if n += m; length > uint64(len(buf)-n) {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	end := n + int(length)
+	c.ShiftN(end)
+	return cc.unwrap(buf[n:end])
+}
+
+// DecodeDatagram ...
+func (cc *HTTP3DatagramCodec) DecodeDatagram(c Conn, packet []byte) (frames [][]byte, err error) {
+	for len(packet) > 0 {
+		typ, n, ok := readQUICVarint(packet)
+		if !ok {
+			return nil, errorset.ErrInvalidHTTP3Datagram
+		}
+		packet = packet[n:]
+
+		var datagram []byte
+		switch typ {
+		case quicDatagramFrame:
+			datagram, packet = packet, nil
+		case quicDatagramFrameWithLength:
+			length, m, ok := readQUICVarint(packet)
+			if !ok || length > uint64(len(packet)-m) {
+				return nil, errorset.ErrInvalidHTTP3Datagram
+			}
+			datagram, packet = packet[m:m+int(length)], packet[m+int(length):]
+		default:
+			return nil, errorset.ErrInvalidHTTP3Datagram
+		}
+
+		payload, err := cc.unwrap(datagram)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, payload)
+	}
+	return
+}
+
+// EncodeDatagram ...
+func (cc *HTTP3DatagramCodec) EncodeDatagram(c Conn, buf []byte) ([]byte, error) {
+	out := make([]byte, 0, 1+quicVarintLen(cc.quarterStreamID)+len(buf))
+	out = append(out, quicDatagramFrame)
+	out = appendQUICVarint(out, cc.quarterStreamID)
+	return append(out, buf...), nil
+}
+
+// unwrap returns the payload of the HTTP/3 datagram after checking its Quarter Stream ID.
+func (cc *HTTP3DatagramCodec) unwrap(datagram []byte) ([]byte, error) {
+	quarterStreamID, n, ok := readQUICVarint(datagram)
+	if !ok {
+		return nil, errorset.ErrInvalidHTTP3Datagram
+	}
+	if quarterStreamID != cc.quarterStreamID {
+		return nil, errorset.ErrHTTP3DatagramStream
+	}
+	return datagram[n:], nil
+}
+
+// quicVarintLen returns the number of bytes that the QUIC variable-length integer takes, see RFC 9000, section 16.
+func quicVarintLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func appendQUICVarint(b []byte, v uint64) []byte {
+	switch quicVarintLen(v) {
+	case 1:
+		return append(b, byte(v))
+	case 2:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case 4:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+func readQUICVarint(b []byte) (v uint64, n int, ok bool) {
+	if len(b) == 0 {
+		return
+	}
+	if n = 1 << (b[0] >> 6); len(b) < n {
+		return 0, 0, false
+	}
+	v = uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, true
+}
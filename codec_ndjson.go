@@ -0,0 +1,177 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/json"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// DefaultNDJSONMaxValueSize is the default limit of the size of JSON values.
+const DefaultNDJSONMaxValueSize = 1024 * 1024
+
+// NDJSONCodec encodes/decodes newline-delimited JSON (NDJSON) into/from TCP stream, every decoded frame is
+// a complete and valid JSON value with the surrounding whitespaces and the newline stripped, blank lines are skipped.
+//
+// A JSON value must be on a single line by default, a line which is not a valid JSON value is discarded and
+// errors.ErrInvalidJSON is returned, while the lenient mode buffers the data until a complete value is present
+// even if it spans multiple lines, e.g. pretty-printed objects or arrays, the data buffered is scanned only once.
+// Values exceeding the limit of size are rejected by errors.ErrJSONValueTooLarge before being buffered up.
+type NDJSONCodec struct {
+	lenient      bool
+	maxValueSize int
+}
+
+// ndjsonScan is the progress of scanning the data buffered in the lenient mode for the end of a JSON value,
+// which is kept by the connection.
+type ndjsonScan struct {
+	offset   int // bytes scanned
+	depth    int // nesting level of objects and arrays
+	inString bool
+	escaped  bool
+}
+
+// NewNDJSONCodec instantiates and returns a codec for NDJSON with one JSON value per line.
+func NewNDJSONCodec() *NDJSONCodec {
+	return NewNDJSONCodecWithMaxValueSize(false, DefaultNDJSONMaxValueSize)
+}
+
+// NewLenientNDJSONCodec instantiates and returns a codec for NDJSON which allows JSON values to span multiple lines.
+func NewLenientNDJSONCodec() *NDJSONCodec {
+	return NewNDJSONCodecWithMaxValueSize(true, DefaultNDJSONMaxValueSize)
+}
+
+// NewNDJSONCodecWithMaxValueSize instantiates and returns a codec for NDJSON in the lenient mode or not, with
+// the given limit of the size of JSON values, DefaultNDJSONMaxValueSize is used if maxValueSize is not positive.
+func NewNDJSONCodecWithMaxValueSize(lenient bool, maxValueSize int) *NDJSONCodec {
+	if maxValueSize <= 0 {
+		maxValueSize = DefaultNDJSONMaxValueSize
+	}
+	return &NDJSONCodec{lenient: lenient, maxValueSize: maxValueSize}
+}
+
+// Encode ...
+func (cc *NDJSONCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return append(buf, CRLFByte), nil
+}
+
+// Decode ...
+func (cc *NDJSONCodec) Decode(c Conn) ([]byte, error) {
+	if cc.lenient {
+		return cc.decodeLenient(c)
+	}
+
+	for {
+		buf := c.Read()
+		idx := bytes.IndexByte(buf, CRLFByte)
+		if idx == -1 {
+			if len(buf) > cc.maxValueSize {
+				return nil, errorset.ErrJSONValueTooLarge
+			}
+			return nil, errorset.ErrCRLFNotFound
+		}
+		c.ShiftN(idx + 1)
+		line := bytes.TrimSpace(buf[:idx])
+		if len(line) == 0 {
+			continue
+		}
+		if len(line) > cc.maxValueSize {
+			return nil, errorset.ErrJSONValueTooLarge
+		}
+		if !json.Valid(line) {
+			return nil, errorset.ErrInvalidJSON
+		}
+		return line, nil
+	}
+}
+
+// decodeLenient scans the data buffered for the newline that follows a complete JSON value, resuming from where
+// the last call left off, and validates the value found.
+func (cc *NDJSONCodec) decodeLenient(c Conn) ([]byte, error) {
+	scan := cc.scanOf(c)
+	for {
+		buf := c.Read()
+		if scan.offset > len(buf) {
+			*scan = ndjsonScan{} // the buffer has been discarded by the event handler.
+		}
+		idx := scan.advance(buf)
+		if idx == -1 {
+			if len(buf) > cc.maxValueSize {
+				*scan = ndjsonScan{}
+				return nil, errorset.ErrJSONValueTooLarge
+			}
+			return nil, errorset.ErrCRLFNotFound
+		}
+		c.ShiftN(idx + 1)
+		value := bytes.TrimSpace(buf[:idx])
+		if len(value) == 0 {
+			continue
+		}
+		if len(value) > cc.maxValueSize {
+			return nil, errorset.ErrJSONValueTooLarge
+		}
+		if !json.Valid(value) {
+			return nil, errorset.ErrInvalidJSON
+		}
+		return value, nil
+	}
+}
+
+// advance scans buf from where it left off and returns the index of the newline outside of any object, array
+// or string, after which the scanning starts over, or -1 if there is no such newline yet.
+func (s *ndjsonScan) advance(buf []byte) int {
+	for i := s.offset; i < len(buf); i++ {
+		b := buf[i]
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case b == '\\':
+				s.escaped = true
+			case b == '"':
+				s.inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			s.inString = true
+		case '{', '[':
+			s.depth++
+		case '}', ']':
+			s.depth--
+		case CRLFByte:
+			if s.depth <= 0 {
+				*s = ndjsonScan{}
+				return i
+			}
+		}
+	}
+	s.offset = len(buf)
+	return -1
+}
+
+// scanOf returns the progress of scanning the data buffered of the connection.
+func (cc *NDJSONCodec) scanOf(c Conn) *ndjsonScan {
+	return stateOf(c, cc, func() interface{} { return new(ndjsonScan) }).(*ndjsonScan)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"encoding/binary"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// DefaultProtobufMaxMessageSize is the default limit of the size of Protobuf messages, which is the default
+// limit of the messages received by gRPC.
+const DefaultProtobufMaxMessageSize = 4 * 1024 * 1024
+
+// ProtobufCodec encodes/decodes the length-delimited Protobuf messages into/from TCP stream, each of which is
+// prefixed with its length in a base 128 varint, as writeDelimitedTo of the Protobuf runtimes does. Every decoded
+// frame is a serialized message with the prefix stripped, which is to be unmarshaled by the Protobuf runtime, a
+// varint split across reads is buffered up along with the message. Messages exceeding the limit of size are
+// rejected by errors.ErrProtobufMessageTooLarge before being buffered up, and a prefix longer than a 64-bit
+// varint is rejected by errors.ErrInvalidProtobufVarint.
+type ProtobufCodec struct {
+	maxMessageSize int
+}
+
+// NewProtobufCodec instantiates and returns a codec for length-delimited Protobuf messages with
+// DefaultProtobufMaxMessageSize as the limit of size.
+func NewProtobufCodec() *ProtobufCodec {
+	return NewProtobufCodecWithMaxMessageSize(DefaultProtobufMaxMessageSize)
+}
+
+// NewProtobufCodecWithMaxMessageSize instantiates and returns a codec for length-delimited Protobuf messages
+// with the given limit of size, a non-positive maxMessageSize falls back to DefaultProtobufMaxMessageSize.
+func NewProtobufCodecWithMaxMessageSize(maxMessageSize int) *ProtobufCodec {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultProtobufMaxMessageSize
+	}
+	return &ProtobufCodec{maxMessageSize: maxMessageSize}
+}
+
+// Encode prefixes the serialized message with its length in varint.
+func (cc *ProtobufCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if len(buf) > cc.maxMessageSize {
+		return nil, errorset.ErrProtobufMessageTooLarge
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(buf)))
+	out := make([]byte, 0, n+len(buf))
+	return append(append(out, prefix[:n]...), buf...), nil
+}
+
+// Decode ...
+func (cc *ProtobufCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	size, n := binary.Uvarint(buf)
+	if n == 0 {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return nil, errorset.ErrInvalidProtobufVarint
+	}
+	if size > uint64(cc.maxMessageSize) {
+		return nil, errorset.ErrProtobufMessageTooLarge
+	}
+	frameLen := n + int(size)
+	if len(buf) < frameLen {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	c.ShiftN(frameLen)
+	return buf[n:frameLen], nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"bytes"
+	"strconv"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// DefaultSyslogMaxMessageSize is the default limit of the size of syslog messages.
+const DefaultSyslogMaxMessageSize = 64 * 1024
+
+// SyslogFraming is the framing of syslog messages transported over TCP.
+type SyslogFraming int
+
+const (
+	// SyslogAutoFraming detects the framing of every message by its first byte, a message starting with a non-zero
+	// digit is octet-counted and a message starting with '<' is non-transparent.
+	SyslogAutoFraming SyslogFraming = iota
+
+	// SyslogOctetCounting prefixes every message with its length in octets and a space, i.e. "<len> <msg>",
+	// as specified by RFC 5425 and RFC 6587.
+	SyslogOctetCounting
+
+	// SyslogNonTransparent terminates every message by a newline, which is the legacy framing in RFC 6587.
+	SyslogNonTransparent
+)
+
+// SyslogCodec encodes/decodes syslog messages into/from TCP stream, every decoded frame is a complete syslog message
+// without the framing, messages that are received across multiple reads are buffered until they are complete.
+// Messages exceeding the limit of size are rejected by errors.ErrSyslogMessageTooLarge before being buffered up,
+// and a malformed octet-counting header is rejected by errors.ErrInvalidSyslogFrame.
+type SyslogCodec struct {
+	framing SyslogFraming
+	maxSize int
+}
+
+// NewSyslogCodec instantiates and returns a codec for syslog which detects the framing of every message and limits
+// the size of messages to DefaultSyslogMaxMessageSize, messages are encoded with the octet-counting framing.
+func NewSyslogCodec() *SyslogCodec {
+	return NewSyslogCodecWithFraming(SyslogAutoFraming, DefaultSyslogMaxMessageSize)
+}
+
+// NewSyslogCodecWithFraming instantiates and returns a codec for syslog with the given framing and limit of
+// the size of messages, DefaultSyslogMaxMessageSize is used if maxMessageSize is not positive.
+func NewSyslogCodecWithFraming(framing SyslogFraming, maxMessageSize int) *SyslogCodec {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultSyslogMaxMessageSize
+	}
+	return &SyslogCodec{framing: framing, maxSize: maxMessageSize}
+}
+
+// Encode ...
+func (cc *SyslogCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if cc.framing == SyslogNonTransparent {
+		return append(buf, CRLFByte), nil
+	}
+	out := strconv.AppendInt(make([]byte, 0, len(buf)+8), int64(len(buf)), 10)
+	out = append(out, ' ')
+	return append(out, buf...), nil
+}
+
+// Decode ...
+func (cc *SyslogCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return nil, errorset.ErrUnexpectedEOF
+	}
+	switch cc.framing {
+	case SyslogOctetCounting:
+		return cc.decodeOctetCounting(c, buf)
+	case SyslogNonTransparent:
+		return cc.decodeNonTransparent(c, buf)
+	}
+	if buf[0] >= '1' && buf[0] <= '9' {
+		return cc.decodeOctetCounting(c, buf)
+	}
+	return cc.decodeNonTransparent(c, buf)
+}
+
+func (cc *SyslogCodec) decodeOctetCounting(c Conn, buf []byte) ([]byte, error) {
+	if buf[0] < '1' || buf[0] > '9' {
+		return nil, errorset.ErrInvalidSyslogFrame
+	}
+	var size int
+	for i, b := range buf {
+		switch {
+		case b >= '0' && b <= '9':
+			if size = size*10 + int(b-'0'); size > cc.maxSize {
+				return nil, errorset.ErrSyslogMessageTooLarge
+			}
+		case b == ' ':
+			if len(buf) < i+1+size {
+				return nil, errorset.ErrUnexpectedEOF
+			}
+			c.ShiftN(i + 1 + size)
+			return buf[i+1 : i+1+size], nil
+		default:
+			return nil, errorset.ErrInvalidSyslogFrame
+		}
+	}
+	return nil, errorset.ErrUnexpectedEOF
+}
+
+func (cc *SyslogCodec) decodeNonTransparent(c Conn, buf []byte) ([]byte, error) {
+	for {
+		idx := bytes.IndexByte(buf, CRLFByte)
+		if idx == -1 {
+			if len(buf) > cc.maxSize {
+				return nil, errorset.ErrSyslogMessageTooLarge
+			}
+			return nil, errorset.ErrCRLFNotFound
+		}
+		if idx > cc.maxSize {
+			return nil, errorset.ErrSyslogMessageTooLarge
+		}
+		c.ShiftN(idx + 1)
+		msg := bytes.TrimSuffix(buf[:idx], []byte{'\r'})
+		if len(msg) > 0 {
+			return msg, nil
+		}
+		// Skip the empty lines.
+		if buf = buf[idx+1:]; len(buf) == 0 {
+			return nil, errorset.ErrCRLFNotFound
+		}
+		if cc.framing == SyslogAutoFraming && buf[0] >= '1' && buf[0] <= '9' {
+			return cc.decodeOctetCounting(c, buf)
+		}
+	}
+}
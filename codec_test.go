@@ -24,7 +24,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"math/rand"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/panjf2000/gnet/errors"
 )
@@ -40,6 +42,19 @@ func (c *mockConn) Read() []byte {
 
 func (c *mockConn) ShiftN(_ int) int { return 0 }
 
+func (c *mockConn) OnCleanup(_ func()) {}
+
+// mockStreamConn consumes the data shifted by the codec and keeps the state of codecs.
+type mockStreamConn struct {
+	mockConn
+	codecStates
+}
+
+func (c *mockStreamConn) ShiftN(n int) int {
+	c.buf = c.buf[n:]
+	return n
+}
+
 func TestLengthFieldBasedFrameCodecWith1(t *testing.T) {
 	encoderConfig := EncoderConfig{
 		ByteOrder:                       binary.BigEndian,
@@ -341,3 +356,554 @@ func TestInnerBufferReadN(t *testing.T) {
 		t.Fatal("wrong length of leftover bytes")
 	}
 }
+
+func TestEncryptedCodec(t *testing.T) {
+	if _, err := NewEncryptedCodec(new(BuiltInFrameCodec), make([]byte, 16)); err == nil {
+		t.Fatal("error missing for invalid key size")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	codec, err := NewEncryptedCodec(new(LineBasedFrameCodec), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Hello World!")
+	out, err := codec.Encode(nil, data)
+	if err != nil {
+		t.Fatalf("encode data with error: %v\n", err)
+	}
+	if bytes.Contains(out, data) {
+		t.Fatal("encoded data should not contain the plaintext")
+	}
+	c := &mockConn{buf: out[:len(out)-1]}
+	if _, err = codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = out
+	if res, err := codec.Decode(c); err != nil {
+		t.Fatalf("decode data with error: %v\n", err)
+	} else if !bytes.Equal(res, data) {
+		t.Fatalf("decoded data(%s) should be equal to original data(%s)\n", string(res), string(data))
+	}
+	out[len(out)-1] ^= 0xff
+	if _, err = codec.Decode(c); err != errors.ErrFrameAuthentication {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrFrameAuthentication, err)
+	}
+}
+
+func TestEncryptedCodecStream(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	codec, err := NewEncryptedCodecWithMaxFrameSize(new(LineBasedFrameCodec), key, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sealer passes the chunks through, which lets the frames of the inner codec be split up arbitrarily.
+	sealer, _ := NewEncryptedCodec(new(BuiltInFrameCodec), key)
+	c := new(mockStreamConn)
+	for _, chunk := range []string{"He", "llo\nWor", "ld\nfoo\nbar\n"} {
+		out, _ := sealer.Encode(nil, []byte(chunk))
+		c.buf = append(c.buf, out...)
+	}
+	for _, want := range []string{"Hello", "World", "foo", "bar"} {
+		res, err := codec.Decode(c)
+		if err != nil {
+			t.Fatalf("decode data with error: %v\n", err)
+		}
+		if string(res) != want {
+			t.Fatalf("decoded data(%s) should be equal to original data(%s)\n", string(res), want)
+		}
+	}
+	if _, err = codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	if _, ok := c.codecState(codec).(*[]byte); !ok {
+		t.Fatal("the connection ought to keep the plaintext held for the inner codec")
+	}
+
+	out, _ := sealer.Encode(nil, make([]byte, 64))
+	c.buf = out
+	if _, err = codec.Decode(c); err != errors.ErrEncryptedFrameTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrEncryptedFrameTooLarge, err)
+	}
+}
+
+type mockAddrConn struct {
+	mockConn
+	addr net.Addr
+}
+
+func (c *mockAddrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *mockAddrConn) ResetBuffer() {}
+
+func TestDNSCodec(t *testing.T) {
+	codec := NewDNSCodec()
+	msg := make([]byte, 32)
+	if _, err := rand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &mockAddrConn{addr: &net.TCPAddr{}}
+	out, err := codec.Encode(c, msg)
+	if err != nil {
+		t.Fatalf("encode data with error: %v\n", err)
+	}
+	if binary.BigEndian.Uint16(out) != uint16(len(msg)) {
+		t.Fatalf("length prefix should be %d, but got: %d\n", len(msg), binary.BigEndian.Uint16(out))
+	}
+	c.buf = out[:len(out)-1]
+	if _, err = codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = out
+	if res, err := codec.Decode(c); err != nil {
+		t.Fatalf("decode data with error: %v\n", err)
+	} else if !bytes.Equal(res, msg) {
+		t.Fatalf("decoded data(%v) should be equal to original data(%v)\n", res, msg)
+	}
+	c.buf = []byte{0, 1, 0}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidDNSMessage {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidDNSMessage, err)
+	}
+	if _, err = codec.Encode(c, make([]byte, 1<<16)); err != errors.ErrInvalidDNSMessage {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidDNSMessage, err)
+	}
+
+	if out, err = codec.EncodeDatagram(c, msg); err != nil || !bytes.Equal(out, msg) {
+		t.Fatalf("datagram should be sent as it is, but got: %v, error: %v\n", out, err)
+	}
+	if frames, err := codec.DecodeDatagram(c, msg); err != nil || len(frames) != 1 || !bytes.Equal(frames[0], msg) {
+		t.Fatalf("datagram should be decoded as it is, but got: %v, error: %v\n", frames, err)
+	}
+	if _, err = codec.DecodeDatagram(c, msg[:dnsHeaderLen-1]); err != errors.ErrInvalidDNSMessage {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidDNSMessage, err)
+	}
+}
+
+func TestNDJSONCodec(t *testing.T) {
+	codec := NewNDJSONCodec()
+	out, err := codec.Encode(nil, []byte(`{"a":1}`))
+	if err != nil || string(out) != "{\"a\":1}\n" {
+		t.Fatalf("encoded data should be terminated by a newline, but got: %q, error: %v\n", out, err)
+	}
+
+	c := &frameConn{buf: []byte("\n {\"a\":1} \r\n[1,\n2]\n")}
+	if res, err := codec.Decode(c); err != nil || string(res) != `{"a":1}` {
+		t.Fatalf("expect frame: %s, but got: %s, error: %v\n", `{"a":1}`, res, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidJSON {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidJSON, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidJSON {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidJSON, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrCRLFNotFound {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrCRLFNotFound, err)
+	}
+
+	codec = NewLenientNDJSONCodec()
+	lc := &mockStreamConn{mockConn: mockConn{buf: []byte("{\"a\":\n  [1,\n2]\n")}}
+	if _, err = codec.Decode(lc); err != errors.ErrCRLFNotFound {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrCRLFNotFound, err)
+	}
+	if scan, ok := lc.codecState(codec).(*ndjsonScan); !ok || scan.offset != len(lc.buf) {
+		t.Fatalf("the connection ought to keep the progress of scanning, got: %+v\n", lc.codecState(codec))
+	}
+	lc.buf = append(lc.buf, "}\n\n12"...)
+	if res, err := codec.Decode(lc); err != nil || string(res) != "{\"a\":\n  [1,\n2]\n}" {
+		t.Fatalf("expect the multi-line object, but got: %q, error: %v\n", res, err)
+	}
+	if _, err = codec.Decode(lc); err != errors.ErrCRLFNotFound {
+		t.Fatalf("number without a newline should be incomplete, but got: %v\n", err)
+	}
+	lc.buf = append(lc.buf, "3\n{\"a\" 1}\ntrue x\n\"ok\"\n"...)
+	if res, err := codec.Decode(lc); err != nil || string(res) != "123" {
+		t.Fatalf("expect frame: 123, but got: %s, error: %v\n", res, err)
+	}
+	if _, err = codec.Decode(lc); err != errors.ErrInvalidJSON {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidJSON, err)
+	}
+	if _, err = codec.Decode(lc); err != errors.ErrInvalidJSON {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidJSON, err)
+	}
+	if res, err := codec.Decode(lc); err != nil || string(res) != `"ok"` {
+		t.Fatalf("expect frame: %s, but got: %s, error: %v\n", `"ok"`, res, err)
+	}
+	// The brackets and the escaped quotes within strings don't count.
+	lc.buf = append(lc.buf, "[\"]\\\"\\n\",\n1]\n"...)
+	if res, err := codec.Decode(lc); err != nil || string(res) != "[\"]\\\"\\n\",\n1]" {
+		t.Fatalf("expect the multi-line array, but got: %q, error: %v\n", res, err)
+	}
+
+	codec = NewNDJSONCodecWithMaxValueSize(true, 8)
+	lc.buf = []byte("[1,\n2,")
+	if _, err = codec.Decode(lc); err != errors.ErrCRLFNotFound {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrCRLFNotFound, err)
+	}
+	lc.buf = append(lc.buf, "3,4"...)
+	if _, err = codec.Decode(lc); err != errors.ErrJSONValueTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrJSONValueTooLarge, err)
+	}
+	codec = NewNDJSONCodecWithMaxValueSize(false, 8)
+	c = &frameConn{buf: []byte("[1,2,3,4,5]\n")}
+	if _, err = codec.Decode(c); err != errors.ErrJSONValueTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrJSONValueTooLarge, err)
+	}
+}
+
+func TestHTTP3DatagramCodec(t *testing.T) {
+	if _, err := NewHTTP3DatagramCodec(1); err != errors.ErrHTTP3DatagramStream {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrHTTP3DatagramStream, err)
+	}
+	codec, err := NewHTTP3DatagramCodec(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 100)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := codec.Encode(nil, payload)
+	if !bytes.Equal(out[:4], []byte{0x31, 0x40, 102, 0x40}) || out[4] != 100 {
+		t.Fatalf("unexpected frame header: %x\n", out[:5])
+	}
+	c := &frameConn{buf: out[:len(out)-1]}
+	if _, err = codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = append(out, out...)
+	for i := 0; i < 2; i++ {
+		if res, err := codec.Decode(c); err != nil || !bytes.Equal(res, payload) {
+			t.Fatalf("decoded data should be equal to original data, error: %v\n", err)
+		}
+	}
+
+	packet, _ := codec.EncodeDatagram(nil, payload)
+	packet = append(out, packet...)
+	frames, err := codec.DecodeDatagram(nil, packet)
+	if err != nil || len(frames) != 2 || !bytes.Equal(frames[0], payload) || !bytes.Equal(frames[1], payload) {
+		t.Fatalf("expect two datagrams in the packet, but got: %d, error: %v\n", len(frames), err)
+	}
+	other, _ := NewHTTP3DatagramCodec(0)
+	if _, err = other.DecodeDatagram(nil, packet); err != errors.ErrHTTP3DatagramStream {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrHTTP3DatagramStream, err)
+	}
+	if _, err = codec.DecodeDatagram(nil, []byte{0x31, 0x10, 0x40}); err != errors.ErrInvalidHTTP3Datagram {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidHTTP3Datagram, err)
+	}
+}
+
+func TestSyslogCodec(t *testing.T) {
+	codec := NewSyslogCodec()
+	out, err := codec.Encode(nil, []byte("<34>1 - msg"))
+	if err != nil || string(out) != "11 <34>1 - msg" {
+		t.Fatalf("encoded data should be octet-counted, but got: %q, error: %v\n", out, err)
+	}
+
+	// Both framings are detected for every message, a message larger than a single read is buffered up.
+	c := &frameConn{buf: []byte("11 <34>1 - msg<13>legacy\r\n\n23 <34>1 - ")}
+	for _, want := range []string{"<34>1 - msg", "<13>legacy"} {
+		if res, err := codec.Decode(c); err != nil || string(res) != want {
+			t.Fatalf("expect frame: %q, but got: %q, error: %v\n", want, res, err)
+		}
+	}
+	if _, err = codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = append(c.buf, "multi\nline\ndata2x"...)
+	if res, err := codec.Decode(c); err != nil || string(res) != "<34>1 - multi\nline\ndata" {
+		t.Fatalf("expect the octet-counted frame, but got: %q, error: %v\n", res, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidSyslogFrame {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidSyslogFrame, err)
+	}
+
+	c = &frameConn{buf: []byte("99999999999 <34>")}
+	if _, err = codec.Decode(c); err != errors.ErrSyslogMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrSyslogMessageTooLarge, err)
+	}
+
+	codec = NewSyslogCodecWithFraming(SyslogNonTransparent, 8)
+	out, _ = codec.Encode(nil, []byte("<13>msg"))
+	if string(out) != "<13>msg\n" {
+		t.Fatalf("encoded data should be terminated by a newline, but got: %q\n", out)
+	}
+	c = &frameConn{buf: []byte("5 <13>\n<13>too long")}
+	if res, err := codec.Decode(c); err != nil || string(res) != "5 <13>" {
+		t.Fatalf("expect frame: %q, but got: %q, error: %v\n", "5 <13>", res, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrSyslogMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrSyslogMessageTooLarge, err)
+	}
+}
+
+func TestLineCodec(t *testing.T) {
+	for _, stripCR := range []bool{true, false} {
+		codec := NewLineCodec(stripCR)
+		c := &frameConn{buf: []byte("unix\nwindows\r\nlone\rcr\n\r\npartial\r")}
+		want := []string{"unix", "windows", "lone\rcr", ""}
+		if !stripCR {
+			want = []string{"unix", "windows\r", "lone\rcr", "\r"}
+		}
+		for _, w := range want {
+			if res, err := codec.Decode(c); err != nil || string(res) != w {
+				t.Fatalf("expect frame: %q, but got: %q, error: %v\n", w, res, err)
+			}
+		}
+		// A trailing '\r' doesn't make a line.
+		if _, err := codec.Decode(c); err != errors.ErrCRLFNotFound {
+			t.Fatalf("expect error: %v, but got: %v\n", errors.ErrCRLFNotFound, err)
+		}
+	}
+}
+
+func TestIncludeDelimiter(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		line := &LineBasedFrameCodec{IncludeDelimiter: include}
+		delimiter := NewDelimiterBasedFrameCodec('|')
+		delimiter.IncludeDelimiter = include
+		for _, tc := range []struct {
+			codec     ICodec
+			input     string
+			delimiter string
+		}{
+			{line, "foo\nbar\n", "\n"},
+			{delimiter, "foo|bar|", "|"},
+		} {
+			c := &frameConn{buf: []byte(tc.input)}
+			for _, name := range []string{"foo", "bar"} {
+				w := name
+				if include {
+					w += tc.delimiter
+				}
+				res, err := tc.codec.Decode(c)
+				if err != nil || string(res) != w {
+					t.Fatalf("expect frame: %q, but got: %q, error: %v\n", w, res, err)
+				}
+				// Echoing the frame back yields the same frame in both modes.
+				out, err := tc.codec.Encode(c, append([]byte(nil), res...))
+				if err != nil || string(out) != name+tc.delimiter {
+					t.Fatalf("expect encoded frame: %q, but got: %q, error: %v\n", name+tc.delimiter, out, err)
+				}
+			}
+		}
+	}
+}
+
+func TestFuncCodec(t *testing.T) {
+	// A toy protocol: a frame is prefixed by its length in a single byte, zero bytes in between are padding.
+	codec := NewFuncCodec(func(buf []byte) ([]byte, int, error) {
+		if buf[0] == 0 {
+			return nil, 1, nil
+		}
+		if buf[0] == 0xff {
+			return nil, 1, errors.ErrInvalidFixedLength
+		}
+		if n := int(buf[0]); len(buf) > n {
+			return buf[1 : n+1], n + 1, nil
+		}
+		return nil, 0, nil
+	}, func(in []byte) ([]byte, error) {
+		return append([]byte{byte(len(in))}, in...), nil
+	})
+
+	out, err := codec.Encode(nil, []byte("abc"))
+	if err != nil || string(out) != "\x03abc" {
+		t.Fatalf("expect encoded data: %q, but got: %q, error: %v\n", "\x03abc", out, err)
+	}
+
+	c := &frameConn{buf: []byte("\x03abc\x00\x00\x02de\x03f")}
+	for _, want := range []string{"abc", "de"} {
+		if res, err := codec.Decode(c); err != nil || string(res) != want {
+			t.Fatalf("expect frame: %q, but got: %q, error: %v\n", want, res, err)
+		}
+	}
+	// The incomplete frame stays in the buffer until the rest of it arrives.
+	if res, err := codec.Decode(c); err != nil || res != nil || string(c.buf) != "\x03f" {
+		t.Fatalf("expect an incomplete frame, but got: %q, error: %v, buffered: %q\n", res, err, c.buf)
+	}
+	c.buf = append(c.buf, "gh\xff"...)
+	if res, err := codec.Decode(c); err != nil || string(res) != "fgh" {
+		t.Fatalf("expect frame: %q, but got: %q, error: %v\n", "fgh", res, err)
+	}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidFixedLength || len(c.buf) != 0 {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidFixedLength, err)
+	}
+
+	codec = NewFuncCodec(func(buf []byte) ([]byte, int, error) { return buf, len(buf) + 1, nil }, nil)
+	if _, err = codec.Decode(&frameConn{buf: []byte("x")}); err != errors.ErrInvalidConsumed {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidConsumed, err)
+	}
+}
+
+func TestAMQPCodec(t *testing.T) {
+	codec := NewAMQPCodec()
+	heartbeat := AppendAMQPFrame(nil, AMQPFrameHeartbeat, 0, nil)
+	if out, err := codec.Encode(nil, heartbeat); err != nil || !bytes.Equal(out, heartbeat) {
+		t.Fatalf("complete frame should be passed through, but got: %v, error: %v\n", out, err)
+	}
+	if _, err := codec.Encode(nil, []byte("payload")); err != errors.ErrInvalidAMQPFrame {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidAMQPFrame, err)
+	}
+
+	// The protocol header is followed by frames, a frame larger than a single read is buffered up.
+	method := AppendAMQPFrame(nil, AMQPFrameMethod, 1, []byte("method"))
+	data := append(append(append([]byte(nil), AMQPProtocolHeader...), method...), heartbeat[:3]...)
+	c := &frameConn{buf: data}
+	for _, want := range [][]byte{AMQPProtocolHeader, method} {
+		if res, err := codec.Decode(c); err != nil || !bytes.Equal(res, want) {
+			t.Fatalf("expect frame: %v, but got: %v, error: %v\n", want, res, err)
+		}
+	}
+	if _, err := codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = append(c.buf, heartbeat[3:]...)
+	if res, err := codec.Decode(c); err != nil || !bytes.Equal(res, heartbeat) {
+		t.Fatalf("expect frame: %v, but got: %v, error: %v\n", heartbeat, res, err)
+	}
+	typ, channel, payload, err := ParseAMQPFrame(method)
+	if err != nil || typ != AMQPFrameMethod || channel != 1 || string(payload) != "method" {
+		t.Fatalf("unexpected frame: type %d, channel %d, payload %q, error: %v\n", typ, channel, payload, err)
+	}
+
+	method[len(method)-1] = 0
+	c = &frameConn{buf: method}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidAMQPFrame {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidAMQPFrame, err)
+	}
+	c = &frameConn{buf: []byte("AMQP\x00\x00\x08\x00")}
+	if _, err = codec.Decode(c); err != errors.ErrInvalidAMQPProtocolHeader {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidAMQPProtocolHeader, err)
+	}
+	c = &frameConn{buf: AppendAMQPFrame(nil, AMQPFrameBody, 1, make([]byte, 64))}
+	if _, err = NewAMQPCodecWithMaxFrameSize(64).Decode(c); err != errors.ErrAMQPFrameTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrAMQPFrameTooLarge, err)
+	}
+}
+
+func TestUDPReassemblyCodec(t *testing.T) {
+	parser := func(datagram []byte) (header FragmentHeader, payload []byte, err error) {
+		if len(datagram) < 10 {
+			return header, nil, errors.ErrInvalidUDPFragment
+		}
+		header.MessageID = binary.BigEndian.Uint64(datagram)
+		header.Index, header.Count = int(datagram[8]), int(datagram[9])
+		return header, datagram[10:], nil
+	}
+	fragment := func(id uint64, index, count byte, payload string) []byte {
+		datagram := make([]byte, 10, 10+len(payload))
+		binary.BigEndian.PutUint64(datagram, id)
+		datagram[8], datagram[9] = index, count
+		return append(datagram, payload...)
+	}
+	codec := NewUDPReassemblyCodecWithLimits(parser, 50*time.Millisecond, 2, 16)
+	peer1 := &mockAddrConn{addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}
+	peer2 := &mockAddrConn{addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}}
+
+	// The fragments of the same message id from different peers are collected apart, in any order.
+	for _, step := range []struct {
+		c        Conn
+		datagram []byte
+		want     string
+	}{
+		{peer1, fragment(1, 2, 3, "c"), ""},
+		{peer2, fragment(1, 1, 2, "y"), ""},
+		{peer1, fragment(1, 0, 3, "a"), ""},
+		{peer1, fragment(1, 0, 3, "a"), ""},
+		{peer1, fragment(1, 1, 3, "b"), "abc"},
+		{peer2, fragment(1, 0, 2, "x"), "xy"},
+		{peer1, fragment(2, 0, 1, "single"), "single"},
+	} {
+		frames, err := codec.DecodeDatagram(step.c, step.datagram)
+		if err != nil {
+			t.Fatalf("decode datagram with error: %v\n", err)
+		}
+		if step.want == "" && len(frames) != 0 || step.want != "" && (len(frames) != 1 || string(frames[0]) != step.want) {
+			t.Fatalf("expect frame: %q, but got: %q\n", step.want, frames)
+		}
+	}
+
+	if _, err := codec.DecodeDatagram(peer1, fragment(3, 3, 3, "d")); err != errors.ErrInvalidUDPFragment {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidUDPFragment, err)
+	}
+	if _, err := codec.DecodeDatagram(peer1, fragment(3, 0, 2, "0123456789abcdefg")); err != errors.ErrUDPMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUDPMessageTooLarge, err)
+	}
+	_, _ = codec.DecodeDatagram(peer1, fragment(4, 0, 2, "a"))
+	_, _ = codec.DecodeDatagram(peer1, fragment(5, 0, 2, "a"))
+	if _, err := codec.DecodeDatagram(peer1, fragment(6, 0, 2, "a")); err != errors.ErrUDPReassemblyFull {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUDPReassemblyFull, err)
+	}
+
+	// The messages of too many fragments are rejected before the slots of fragments are allocated.
+	huge := NewUDPReassemblyCodec(func(datagram []byte) (FragmentHeader, []byte, error) {
+		return FragmentHeader{MessageID: 1, Count: UDPReassemblyMaxFragments + 1}, datagram, nil
+	})
+	if _, err := huge.DecodeDatagram(peer1, []byte("a")); err != errors.ErrUDPMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUDPMessageTooLarge, err)
+	}
+
+	// The incomplete messages are dropped after the timeout.
+	time.Sleep(60 * time.Millisecond)
+	if frames, err := codec.DecodeDatagram(peer1, fragment(4, 1, 2, "b")); err != nil || len(frames) != 0 {
+		t.Fatalf("expect no frame, but got: %q, error: %v\n", frames, err)
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := NewProtobufCodec()
+	small, large := []byte("message"), bytes.Repeat([]byte{'x'}, 300)
+	var data []byte
+	for _, msg := range [][]byte{small, {}, large} {
+		out, err := codec.Encode(nil, msg)
+		if err != nil {
+			t.Fatalf("failed to encode message: %v\n", err)
+		}
+		data = append(data, out...)
+	}
+	if !bytes.Equal(data[:8], append([]byte{7}, small...)) {
+		t.Fatalf("unexpected encoded message: %v\n", data[:8])
+	}
+
+	// The varint of 300 takes two bytes, which are split across reads along with the message.
+	c := &frameConn{buf: data[:10]}
+	for _, want := range [][]byte{small, {}} {
+		if res, err := codec.Decode(c); err != nil || !bytes.Equal(res, want) {
+			t.Fatalf("expect message: %q, but got: %q, error: %v\n", want, res, err)
+		}
+	}
+	if _, err := codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = append(c.buf, data[10:20]...)
+	if _, err := codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrUnexpectedEOF, err)
+	}
+	c.buf = append(c.buf, data[20:]...)
+	if res, err := codec.Decode(c); err != nil || !bytes.Equal(res, large) {
+		t.Fatalf("expect message: %q, but got: %q, error: %v\n", large, res, err)
+	}
+
+	codec = NewProtobufCodecWithMaxMessageSize(100)
+	if _, err := codec.Encode(nil, large); err != errors.ErrProtobufMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrProtobufMessageTooLarge, err)
+	}
+	// The length is rejected before the message is buffered up.
+	c = &frameConn{buf: []byte{0xac, 0x02}}
+	if _, err := codec.Decode(c); err != errors.ErrProtobufMessageTooLarge {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrProtobufMessageTooLarge, err)
+	}
+	c = &frameConn{buf: bytes.Repeat([]byte{0xff}, 11)}
+	if _, err := codec.Decode(c); err != errors.ErrInvalidProtobufVarint {
+		t.Fatalf("expect error: %v, but got: %v\n", errors.ErrInvalidProtobufVarint, err)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sync"
+	"time"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+const (
+	// DefaultUDPReassemblyTimeout is the default duration that the fragments of an incomplete message are kept.
+	DefaultUDPReassemblyTimeout = 5 * time.Second
+	// DefaultUDPReassemblyMaxPending is the default limit of the incomplete messages kept across all peers.
+	DefaultUDPReassemblyMaxPending = 1024
+	// DefaultUDPReassemblyMaxMessageSize is the default limit of the size of reassembled messages.
+	DefaultUDPReassemblyMaxMessageSize = 1024 * 1024
+	// UDPReassemblyMaxFragments is the limit of the number of fragments of a message.
+	UDPReassemblyMaxFragments = 1024
+)
+
+// FragmentHeader describes the fragment of a message carried by a datagram.
+type FragmentHeader struct {
+	// MessageID identifies the message among those sent by the same peer.
+	MessageID uint64
+	// Index is the zero-based position of the fragment in the message.
+	Index int
+	// Count is the number of fragments of the message, a message of a single fragment is delivered as it is.
+	Count int
+}
+
+// FragmentHeaderParser parses the application-level header of a datagram and returns the header along with
+// the payload of the fragment, which may refer to the memory of datagram.
+type FragmentHeaderParser func(datagram []byte) (header FragmentHeader, payload []byte, err error)
+
+type fragmentKey struct {
+	peer string
+	id   uint64
+}
+
+// fragmentedMessage is a message whose fragments are being collected.
+type fragmentedMessage struct {
+	fragments [][]byte
+	received  int
+	size      int
+	deadline  time.Time
+}
+
+// UDPReassemblyCodec reassembles the messages that are split across datagrams by the application, the fragments
+// are collected by the message id and the address of the peer, React fires with every complete message once its
+// last fragment arrives, no matter in which order the fragments arrive. The fragments of incomplete messages are
+// dropped after the timeout. A datagram whose header is inconsistent, whose message exceeds the limit of size or
+// UDPReassemblyMaxFragments fragments, or which starts a message beyond the limit of the incomplete messages is
+// rejected by errors.ErrInvalidUDPFragment, errors.ErrUDPMessageTooLarge or errors.ErrUDPReassemblyFull, and dropped
+// after EventHandler.OnDecodeError.
+// The data returned by React is sent back as it is, and the data of TCP connections passes through as it is.
+// It is safe to be shared by the event-loops of a server.
+type UDPReassemblyCodec struct {
+	parse          FragmentHeaderParser
+	timeout        time.Duration
+	maxPending     int
+	maxMessageSize int
+
+	mu        sync.Mutex
+	pending   map[fragmentKey]*fragmentedMessage
+	lastSweep time.Time
+}
+
+// NewUDPReassemblyCodec instantiates and returns a codec reassembling the messages from the fragments whose headers
+// are parsed by headerParser, with DefaultUDPReassemblyTimeout, DefaultUDPReassemblyMaxPending and
+// DefaultUDPReassemblyMaxMessageSize.
+func NewUDPReassemblyCodec(headerParser FragmentHeaderParser) *UDPReassemblyCodec {
+	return NewUDPReassemblyCodecWithLimits(headerParser, DefaultUDPReassemblyTimeout, DefaultUDPReassemblyMaxPending,
+		DefaultUDPReassemblyMaxMessageSize)
+}
+
+// NewUDPReassemblyCodecWithLimits instantiates and returns a codec reassembling the messages with the given timeout
+// of incomplete messages, limit of the incomplete messages kept and limit of the size of messages, the defaults
+// are used for the non-positive ones.
+func NewUDPReassemblyCodecWithLimits(headerParser FragmentHeaderParser, timeout time.Duration, maxPending,
+	maxMessageSize int) *UDPReassemblyCodec {
+	if timeout <= 0 {
+		timeout = DefaultUDPReassemblyTimeout
+	}
+	if maxPending <= 0 {
+		maxPending = DefaultUDPReassemblyMaxPending
+	}
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultUDPReassemblyMaxMessageSize
+	}
+	return &UDPReassemblyCodec{
+		parse:          headerParser,
+		timeout:        timeout,
+		maxPending:     maxPending,
+		maxMessageSize: maxMessageSize,
+		pending:        make(map[fragmentKey]*fragmentedMessage),
+	}
+}
+
+// Encode ...
+func (cc *UDPReassemblyCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+// Decode ...
+func (cc *UDPReassemblyCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	c.ResetBuffer()
+	return buf, nil
+}
+
+// EncodeDatagram ...
+func (cc *UDPReassemblyCodec) EncodeDatagram(c Conn, buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+// DecodeDatagram ...
+func (cc *UDPReassemblyCodec) DecodeDatagram(c Conn, packet []byte) ([][]byte, error) {
+	header, payload, err := cc.parse(packet)
+	if err != nil {
+		return nil, err
+	}
+	if header.Count == 1 && header.Index == 0 {
+		if len(payload) > cc.maxMessageSize {
+			return nil, errorset.ErrUDPMessageTooLarge
+		}
+		return [][]byte{payload}, nil
+	}
+	if header.Count < 1 || header.Index < 0 || header.Index >= header.Count {
+		return nil, errorset.ErrInvalidUDPFragment
+	}
+
+	key := fragmentKey{c.RemoteAddr().String(), header.MessageID}
+	now := time.Now()
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.sweep(now)
+	msg := cc.pending[key]
+	if msg == nil {
+		// Bound the slots of fragments allocated upfront, a single spoofed datagram mustn't allocate a huge slice.
+		if header.Count > UDPReassemblyMaxFragments || header.Count > cc.maxMessageSize {
+			return nil, errorset.ErrUDPMessageTooLarge
+		}
+		if len(cc.pending) >= cc.maxPending {
+			return nil, errorset.ErrUDPReassemblyFull
+		}
+		msg = &fragmentedMessage{fragments: make([][]byte, header.Count), deadline: now.Add(cc.timeout)}
+		cc.pending[key] = msg
+	}
+	if header.Count != len(msg.fragments) {
+		delete(cc.pending, key)
+		return nil, errorset.ErrInvalidUDPFragment
+	}
+	if msg.fragments[header.Index] != nil {
+		return nil, nil // duplicate
+	}
+	if msg.size += len(payload); msg.size > cc.maxMessageSize {
+		delete(cc.pending, key)
+		return nil, errorset.ErrUDPMessageTooLarge
+	}
+	// The datagram is only valid until DecodeDatagram returns.
+	msg.fragments[header.Index] = append(make([]byte, 0, len(payload)), payload...)
+	if msg.received++; msg.received < len(msg.fragments) {
+		return nil, nil
+	}
+
+	delete(cc.pending, key)
+	frame := make([]byte, 0, msg.size)
+	for _, fragment := range msg.fragments {
+		frame = append(frame, fragment...)
+	}
+	return [][]byte{frame}, nil
+}
+
+// sweep drops the incomplete messages that have timed out, it runs at most every quarter of the timeout.
+func (cc *UDPReassemblyCodec) sweep(now time.Time) {
+	if now.Sub(cc.lastSweep) < cc.timeout/4 {
+		return
+	}
+	cc.lastSweep = now
+	for key, msg := range cc.pending {
+		if now.After(msg.deadline) {
+			delete(cc.pending, key)
+		}
+	}
+}
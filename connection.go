@@ -0,0 +1,199 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// connIDGen generates the identifiers of connections.
+var connIDGen uint64
+
+// nextConnID returns an identifier which is unique among the TCP connections in the current process.
+func nextConnID() uint64 {
+	return atomic.AddUint64(&connIDGen, 1)
+}
+
+// addConnTotal counts the opened and closed connections across all event-loops when the event handler implements
+// IdleHandler, it fires OnAllConnectionsClosed once the last connection is closed.
+func (svr *server) addConnTotal(delta int32) (action Action) {
+	ih, ok := svr.eventHandler.(IdleHandler)
+	if !ok {
+		return
+	}
+	if atomic.AddInt32(&svr.connTotal, delta) == 0 && delta < 0 {
+		action = ih.OnAllConnectionsClosed()
+	}
+	return
+}
+
+// connState describes the state of a connection in ConnDump.
+func connState(pendingOpen, handshaked bool) string {
+	switch {
+	case pendingOpen:
+		return "pending-open"
+	case !handshaked:
+		return "handshaking"
+	default:
+		return "open"
+	}
+}
+
+// forward writes a copy of the frame to the connection to, the event-loop of to runs the writes in order.
+func forward(to Conn, frame []byte) error {
+	return to.AsyncWrite(append([]byte(nil), frame...))
+}
+
+// maxConnAgeInterval is the upper bound of the interval of checking the ages of connections for MaxConnAge.
+const maxConnAgeInterval = time.Second
+
+// housekeepingInterval returns the interval of the periodic chores enabled by the options, or 0 if there is none:
+// the buffers of idle connections are shrunk every BufferShrinkInterval and the ages of connections are checked
+// every tenth of MaxConnAge up to every maxConnAgeInterval.
+func (svr *server) housekeepingInterval() (interval time.Duration) {
+	pick := func(d time.Duration) {
+		if d > 0 && (interval == 0 || d < interval) {
+			interval = d
+		}
+	}
+	pick(svr.opts.BufferShrinkInterval)
+	if maxAge := svr.opts.MaxConnAge; maxAge > 0 {
+		d := maxAge / 10
+		if d > maxConnAgeInterval {
+			d = maxConnAgeInterval
+		} else if d < time.Millisecond {
+			d = time.Millisecond
+		}
+		pick(d)
+	}
+	return
+}
+
+// housekeep runs the periodic chores of the server on a single ticker until the server shuts down, every event-loop
+// is handed one task per tick for the chores that are due.
+func (svr *server) housekeep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	shrunk := time.Now()
+	for now := range ticker.C {
+		if !svr.isServing() {
+			return
+		}
+		svr.relieveFdLimit()
+		var idle time.Duration
+		if d := svr.opts.BufferShrinkInterval; d > 0 && now.Sub(shrunk) >= d {
+			idle, shrunk = d, now
+		}
+		maxAge := svr.opts.MaxConnAge
+		if idle == 0 && maxAge == 0 {
+			continue
+		}
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			el.housekeep(idle, maxAge, interval)
+			return true
+		})
+	}
+}
+
+// sampleConnLog reports whether a connection lifecycle log line should be emitted, it lets through the fraction of
+// lines set by ConnLogSampling evenly by counting them, e.g. every tenth line at the rate of 0.1.
+func (svr *server) sampleConnLog() bool {
+	rate := svr.opts.ConnLogSampling
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	n := atomic.AddUint64(&svr.connLogSeq, 1)
+	return uint64(float64(n)*rate) != uint64(float64(n-1)*rate)
+}
+
+// logConnOpened logs the opening of the connection subject to ConnLogSampling.
+func (svr *server) logConnOpened(c Conn) {
+	if svr.sampleConnLog() {
+		c.Logger().Debugf("connection opened")
+	}
+}
+
+// logConnClosed logs the closing of the connection, along with the error causing it. The connections closed due to
+// errors are always logged, the others are subject to ConnLogSampling. A peer resetting connections or a flood of
+// failing connections is routine for servers facing the internet, thus they are logged at the debug level like the
+// others rather than flooding the logs with warnings.
+func (svr *server) logConnClosed(c Conn, cause CloseCause, err error) {
+	if err != nil && cause != CloseLocal && cause != ClosePeerFIN {
+		c.Logger().Debugf("connection closed (%s): %v", cause, err)
+		return
+	}
+	if svr.sampleConnLog() {
+		c.Logger().Debugf("connection closed (%s)", cause)
+	}
+}
+
+// handshake fires OnHandshake if the event handler implements Handshaker, otherwise the handshake is done
+// right away.
+func handshake(eh EventHandler, c Conn) (consumed int, done bool, action Action) {
+	if h, ok := eh.(Handshaker); ok {
+		return h.OnHandshake(c, c.Read())
+	}
+	return 0, true, None
+}
+
+// onDecodeError fires OnDecodeError if the event handler implements DecodeErrorHandler, otherwise the decoding
+// error is ignored and the connection waits for more data.
+func onDecodeError(eh EventHandler, c Conn, err error) Action {
+	if h, ok := eh.(DecodeErrorHandler); ok {
+		return h.OnDecodeError(c, err)
+	}
+	return None
+}
+
+// onPeerUnreachable fires OnPeerUnreachable if the event handler implements UnreachableHandler, otherwise
+// the connection is closed.
+func onPeerUnreachable(eh EventHandler, c Conn, err error) Action {
+	if h, ok := eh.(UnreachableHandler); ok {
+		return h.OnPeerUnreachable(c, err)
+	}
+	return Close
+}
+
+// reactDatagram decodes a datagram by the DatagramCodec and fires React for every frame in it,
+// the responses are encoded and sent back by send, it returns Shutdown if any of the event callbacks demands it.
+func reactDatagram(eh EventHandler, dc DatagramCodec, c Conn, packet []byte, send func([]byte) error) Action {
+	frames, err := dc.DecodeDatagram(c, packet)
+	if err != nil {
+		if onDecodeError(eh, c, err) == Shutdown {
+			return Shutdown
+		}
+		return None
+	}
+	for _, frame := range frames {
+		out, action := eh.React(frame, c)
+		if out != nil {
+			if out, err = dc.EncodeDatagram(c, out); err == nil {
+				eh.PreWrite()
+				_ = send(out)
+			}
+		}
+		if action == Shutdown {
+			return Shutdown
+		}
+	}
+	return None
+}
@@ -24,16 +24,33 @@ package gnet
 
 import "github.com/panjf2000/gnet/internal/netpoll"
 
+// eventFlags converts the kqueue filter to EventFlags.
+func eventFlags(filter int16) EventFlags {
+	switch filter {
+	case netpoll.EVFilterRead:
+		return EventRead
+	case netpoll.EVFilterWrite:
+		return EventWrite
+	case netpoll.EVFilterSock:
+		return EventError
+	}
+	return 0
+}
+
 func (c *conn) handleEvents(filter int16) (err error) {
+	if proceed, err := c.owner().loopEvent(c, eventFlags(filter)); !proceed {
+		return err
+	}
 	switch filter {
 	case netpoll.EVFilterSock:
-		err = c.loop.loopCloseConn(c, nil)
+		c.closeCause = ClosePeerFIN
+		err = c.owner().loopCloseConn(c, nil)
 	case netpoll.EVFilterWrite:
-		if !c.outboundBuffer.IsEmpty() {
-			err = c.loop.loopWrite(c)
+		if c.writePending() {
+			err = c.owner().loopWrite(c)
 		}
 	case netpoll.EVFilterRead:
-		err = c.loop.loopRead(c)
+		err = c.owner().loopRead(c)
 	}
 	return
 }
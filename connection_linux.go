@@ -22,9 +22,31 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/internal/netpoll"
+)
+
+// eventFlags converts the epoll events to EventFlags.
+func eventFlags(ev uint32) (events EventFlags) {
+	if ev&(unix.EPOLLIN|unix.EPOLLPRI) != 0 {
+		events |= EventRead
+	}
+	if ev&unix.EPOLLOUT != 0 {
+		events |= EventWrite
+	}
+	if ev&netpoll.ErrEvents != 0 {
+		events |= EventError
+	}
+	return
+}
 
 func (c *conn) handleEvents(ev uint32) error {
+	if proceed, err := c.owner().loopEvent(c, eventFlags(ev)); !proceed {
+		return err
+	}
+
 	// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 	// sure what you're doing!
 	// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
@@ -36,8 +58,8 @@ func (c *conn) handleEvents(ev uint32) error {
 	// In either case loopWrite() should take care of it properly:
 	// 1) writing data back,
 	// 2) closing the connection.
-	if ev&netpoll.OutEvents != 0 && !c.outboundBuffer.IsEmpty() {
-		if err := c.loop.loopWrite(c); err != nil {
+	if ev&netpoll.OutEvents != 0 && c.writePending() {
+		if err := c.owner().loopWrite(c); err != nil {
 			return err
 		}
 	}
@@ -49,7 +71,7 @@ func (c *conn) handleEvents(ev uint32) error {
 	// in which case if the server socket send buffer is full, we need to let it go and continue reading
 	// the data to prevent blocking forever.
 	if ev&netpoll.InEvents != 0 && (ev&netpoll.OutEvents == 0 || c.outboundBuffer.IsEmpty()) {
-		return c.loop.loopRead(c)
+		return c.owner().loopRead(c)
 	}
 	return nil
 }
@@ -24,26 +24,66 @@
 package gnet
 
 import (
+	"math/rand"
 	"net"
 	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 
+	gerrors "github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/netpoll"
+	"github.com/panjf2000/gnet/internal/queue"
 	"github.com/panjf2000/gnet/internal/socket"
+	"github.com/panjf2000/gnet/logging"
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
 	"github.com/panjf2000/gnet/ringbuffer"
 )
 
 type conn struct {
+	connMetrics
+	codecStates
+
+	id             uint64                  // connection identifier
 	fd             int                     // file descriptor
 	sa             unix.Sockaddr           // remote socket address
 	ctx            interface{}             // user-defined context
-	loop           *eventloop              // connected event-loop
+	loop           unsafe.Pointer          // connected event-loop, only accessed via owner and setOwner
 	codec          ICodec                  // codec for TCP
 	buffer         []byte                  // reuse memory of inbound data as a temporary buffer
 	opened         bool                    // connection opened event fired
+	pendingOpen    bool                    // connection opened event deferred until the first inbound data
+	handshaked     bool                    // handshake done, codec engaged
+	resumeChecked  bool                    // resume token looked up
+	closeBehavior  CloseBehavior           // how to treat the pending outbound data on closing
+	closeCause     CloseCause              // why the connection is closed
+	drainClose     bool                    // close the connection once the outbound buffer is drained
+	dedicated      *dedicatedReactor       // reactor running React on a dedicated goroutine
+	async          *asyncReactor           // reactor running React on the worker pool
+	readPaused     bool                    // reads paused until the worker pool takes the pending frames
+	budgetPaused   bool                    // reads paused until the total buffered data drops below the limit
+	bufferCounted  int                     // buffered data counted in the total of all connections
+	onWriteReady   func(Conn, int)         // callback fired on writable events
+	transfer       *fileTransfer           // file being sent by ServeFile
+	readDeadline   *deadline               // deadline for reading the connection
+	writeDeadline  *deadline               // deadline for the pending outbound data
+	flushTags      []writeTag              // tags of AsyncWriteTagged waiting for their data to be flushed
+	pendingEnds    []uint64                // ends of the data of AsyncWrites waiting to be flushed
+	pendingWrites  int32                   // AsyncWrites issued but not yet flushed
+	maxPending     int32                   // limit of pendingWrites set by SetMaxPendingWrites
+	released       int32                   // set once the connection is closed and about to be unregistered
+	cleanups       []func()                // callbacks registered by OnCleanup
+	goodbye        *goodbye                // close handshake started by CloseGracefully
+	rateLimit      *inboundRateLimit       // token bucket throttling the inbound frames
+	decompressor   *streamDecompressor     // decompressor of the compressed inbound stream
+	overflow       []byte                  // outbound data held back until it fits in MaxOutboundBuffer
+	batching       bool                    // writes held back until EndBatch
+	batchBegins    int32                   // calls of BeginBatch that haven't taken effect on the event-loop yet
+	batch          []byte                  // data written since BeginBatch
+	logger         logging.Logger          // logger tagged with the connection
 	localAddr      net.Addr                // local addr
 	remoteAddr     net.Addr                // remote addr
 	byteBuffer     *bytebuffer.ByteBuffer  // bytes buffer for buffering current packet and data in ring-buffer
@@ -54,17 +94,22 @@ type conn struct {
 
 func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, remoteAddr net.Addr) (c *conn) {
 	c = &conn{
+		id:             nextConnID(),
 		fd:             fd,
 		sa:             sa,
-		loop:           el,
+		loop:           unsafe.Pointer(el),
 		codec:          el.svr.codec,
 		localAddr:      el.ln.lnaddr,
 		remoteAddr:     remoteAddr,
 		inboundBuffer:  prb.Get(),
 		outboundBuffer: prb.Get(),
 	}
+	c.acceptedAt = time.Now()
 	c.pollAttachment = netpoll.GetPollAttachment()
 	c.pollAttachment.FD, c.pollAttachment.Callback = fd, c.handleEvents
+	if init := el.svr.opts.ConnInitFunc; init != nil {
+		init(c)
+	}
 	return
 }
 
@@ -75,12 +120,51 @@ func (c *conn) releaseTCP() {
 	c.buffer = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.releaseBuffers()
 	prb.Put(c.inboundBuffer)
 	prb.Put(c.outboundBuffer)
 	c.inboundBuffer = ringbuffer.EmptyRingBuffer
 	c.outboundBuffer = ringbuffer.EmptyRingBuffer
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
+	c.resetLabels()
+	c.codecStates = nil
+	c.logger = nil
+	c.onWriteReady = nil
+	c.transfer = nil
+	c.overflow = nil
+	c.batching = false
+	c.batch = nil
+	c.flushTags = nil
+	c.pendingEnds = nil
+	if c.readDeadline != nil {
+		c.readDeadline.timer.Stop()
+		c.readDeadline = nil
+	}
+	if c.writeDeadline != nil {
+		c.writeDeadline.timer.Stop()
+		c.writeDeadline = nil
+	}
+	if c.goodbye != nil {
+		c.goodbye.timer.Stop()
+		c.goodbye = nil
+	}
+	if c.rateLimit.throttled() {
+		c.rateLimit.timer.Stop()
+	}
+	c.rateLimit = nil
+	if c.decompressor != nil {
+		c.decompressor.stop()
+		c.decompressor = nil
+	}
+	if c.dedicated != nil {
+		c.dedicated.stop()
+		c.dedicated = nil
+	}
+	if c.async != nil {
+		c.async.stop()
+		c.async = nil
+	}
 	netpoll.PutPollAttachment(c.pollAttachment)
 }
 
@@ -88,15 +172,18 @@ func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
 	return &conn{
 		fd:         fd,
 		sa:         sa,
+		loop:       unsafe.Pointer(el),
 		localAddr:  el.ln.lnaddr,
 		remoteAddr: socket.SockaddrToUDPAddr(sa),
 	}
 }
 
 func (c *conn) releaseUDP() {
+	c.runCleanups()
 	c.ctx = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.resetLabels()
 }
 
 func (c *conn) open(buf []byte) {
@@ -105,51 +192,214 @@ func (c *conn) open(buf []byte) {
 		_, _ = c.outboundBuffer.Write(buf)
 		return
 	}
+	c.addWritten(n, c.owner().clock)
 
 	if n < len(buf) {
 		_, _ = c.outboundBuffer.Write(buf[n:])
 	}
 }
 
+func (c *conn) dump() ConnDump {
+	return ConnDump{
+		ID:               c.id,
+		LocalAddr:        addrString(c.localAddr),
+		RemoteAddr:       addrString(c.remoteAddr),
+		InboundBuffered:  c.BufferLength(),
+		OutboundBuffered: c.outboundBuffer.Length(),
+		LastActive:       c.lastActive,
+		State:            connState(c.pendingOpen, c.handshaked),
+		Labels:           c.Labels(),
+	}
+}
+
 func (c *conn) read() ([]byte, error) {
+	if c.owner().svr.opts.NoInboundBuffer {
+		if c.BufferLength() == 0 {
+			return nil, nil
+		}
+		buf := c.Read()
+		c.buffer = c.buffer[:0]
+		c.inboundBuffer.Reset()
+		return buf, nil
+	}
 	return c.codec.Decode(c)
 }
 
+// resync skips the corrupted bytes in buffers if the codec implements Resyncer,
+// it reports whether the decoding is able to go on.
+func (c *conn) resync() bool {
+	r, ok := c.codec.(Resyncer)
+	if !ok {
+		return false
+	}
+	skip, err := r.Resync(c.Read())
+	if err != nil || skip <= 0 {
+		return false
+	}
+	c.ShiftN(skip)
+	return true
+}
+
 func (c *conn) write(buf []byte) (err error) {
 	var outFrame []byte
 	if outFrame, err = c.codec.Encode(c, buf); err != nil {
 		return
 	}
+	return c.writeFrame(outFrame)
+}
+
+// writeFrame writes the encoded frame to the socket, the data that can't be written right now is buffered.
+func (c *conn) writeFrame(outFrame []byte) (err error) {
+	if c.rejectOverflow(outFrame) {
+		return gerrors.ErrWriteBufferOverflow
+	}
+	c.addFrameWritten()
+	// The data written in a batch is held back until the batch ends, a batch begins as soon as BeginBatch is called,
+	// otherwise the data returned by the callback calling BeginBatch would be written ahead of the batch.
+	if c.batching || atomic.LoadInt32(&c.batchBegins) > 0 {
+		c.batching = true
+		c.batch = append(c.batch, outFrame...)
+		return
+	}
+	return c.send(outFrame)
+}
+
+// send writes the data to the socket, the data that can't be written right now is buffered.
+func (c *conn) send(outFrame []byte) (err error) {
+	// The data written during a file transfer is held back until the file is sent.
+	if c.transfer != nil {
+		c.transfer.tail = append(c.transfer.tail, outFrame...)
+		return
+	}
 	// If there is pending data in outbound buffer, the current data ought to be appended to the outbound buffer
 	// for maintaining the sequence of network packets.
 	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Write(outFrame)
+		c.bufferOutbound(outFrame)
 		return
 	}
-	c.loop.eventHandler.PreWrite() // call PreWrite() only before server writes data to socket
+	c.owner().eventHandler.PreWrite() // call PreWrite() only before server writes data to socket
 	var n int
 	if n, err = unix.Write(c.fd, outFrame); err != nil {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to client in the next round.
 		if err == unix.EAGAIN {
-			_, _ = c.outboundBuffer.Write(outFrame)
-			err = c.loop.poller.ModReadWrite(c.pollAttachment)
+			c.bufferOutbound(outFrame)
+			err = c.watchWrite()
 			return
 		}
-		return c.loop.loopCloseConn(c, os.NewSyscallError("write", err))
+		return c.owner().loopCloseConn(c, os.NewSyscallError("write", err))
 	}
+	c.addWritten(n, c.owner().clock)
 	// Fail to send all data back to client, buffer the leftover data for the next round.
 	if n < len(outFrame) {
-		_, _ = c.outboundBuffer.Write(outFrame[n:])
-		err = c.loop.poller.ModReadWrite(c.pollAttachment)
+		c.bufferOutbound(outFrame[n:])
+		err = c.watchWrite()
 	}
 	return
 }
 
-func (c *conn) asyncWrite(itf interface{}) error {
+// watchWrite starts watching the writable events of the connection, its readable events stay unwatched if paused.
+func (c *conn) watchWrite() error {
+	if c.readPaused {
+		return c.owner().poller.PauseRead(c.pollAttachment, true)
+	}
+	return c.owner().poller.ModReadWrite(c.pollAttachment)
+}
+
+func (c *conn) asyncWrite(itf interface{}) (err error) {
 	if !c.opened {
+		atomic.AddInt32(&c.pendingWrites, -1)
 		return nil
 	}
-	return c.write(itf.([]byte))
+	if err = c.write(itf.([]byte)); err != nil {
+		atomic.AddInt32(&c.pendingWrites, -1)
+		c.owner().svr.reportErr(err)
+		return
+	}
+	// The write stays pending until its data leaves the outbound buffer.
+	if n := c.pendingBytes(); c.opened && n > 0 {
+		c.pendingEnds = append(c.pendingEnds, c.written+uint64(n))
+		return
+	}
+	atomic.AddInt32(&c.pendingWrites, -1)
+	return
+}
+
+// releaseFlushed uncounts the pending AsyncWrites whose data has been written to the socket.
+func (c *conn) releaseFlushed() {
+	var i int
+	for i < len(c.pendingEnds) && c.pendingEnds[i] <= c.written {
+		i++
+	}
+	if i > 0 {
+		atomic.AddInt32(&c.pendingWrites, -int32(i))
+		c.pendingEnds = c.pendingEnds[:copy(c.pendingEnds, c.pendingEnds[i:])]
+	}
+}
+
+func (c *conn) asyncWriteRaw(itf interface{}) (err error) {
+	if !c.opened {
+		return nil
+	}
+	if err = c.writeFrame(itf.([]byte)); err != nil {
+		c.owner().svr.reportErr(err)
+	}
+	return
+}
+
+// writeTag is the tag of the data written by AsyncWriteTagged, which is flushed once the number of bytes written
+// to the connection reaches end.
+type writeTag struct {
+	tag uint64
+	end uint64
+}
+
+// taggedWrite is the data passed to the event-loop by AsyncWriteTagged.
+type taggedWrite struct {
+	data []byte
+	tag  uint64
+}
+
+func (c *conn) asyncWriteTagged(itf interface{}) (err error) {
+	if !c.opened {
+		return nil
+	}
+	tw := itf.(*taggedWrite)
+	if err = c.write(tw.data); err != nil {
+		c.owner().svr.reportErr(err)
+		return
+	}
+	if !c.opened {
+		return
+	}
+	c.flushTags = append(c.flushTags, writeTag{tag: tw.tag, end: c.written + uint64(c.pendingBytes())})
+	c.fireFlushed()
+	return
+}
+
+// pendingBytes returns the number of bytes written to the connection but not yet to the socket, including the rest
+// of the file of an ongoing ServeFile.
+func (c *conn) pendingBytes() (n int) {
+	n = c.outboundLength()
+	if c.transfer != nil {
+		n += int(c.transfer.remaining)
+	}
+	return
+}
+
+// fireFlushed fires OnWriteFlushed for the tagged data that has been written to the socket.
+func (c *conn) fireFlushed() {
+	fh, ok := c.owner().eventHandler.(FlushHandler)
+	var i int
+	for ; i < len(c.flushTags) && c.flushTags[i].end <= c.written; i++ {
+		if ok {
+			fh.OnWriteFlushed(c, c.flushTags[i].tag)
+		}
+	}
+	if i == len(c.flushTags) {
+		c.flushTags = c.flushTags[:0]
+	} else if i > 0 {
+		c.flushTags = c.flushTags[:copy(c.flushTags, c.flushTags[i:])]
+	}
 }
 
 func (c *conn) sendTo(buf []byte) error {
@@ -231,22 +481,304 @@ func (c *conn) BufferLength() int {
 	return c.inboundBuffer.Length() + len(c.buffer)
 }
 
+// outboundLength returns the number of bytes written to the connection that are not yet written to the socket,
+// including the data held back by MaxOutboundBuffer, a batch or an ongoing ServeFile but not the file itself.
+func (c *conn) outboundLength() (size int) {
+	size = c.outboundBuffer.Length() + len(c.overflow) + len(c.batch)
+	if c.transfer != nil {
+		size += len(c.transfer.tail)
+	}
+	return
+}
+
+func (c *conn) OutboundLength() int {
+	return c.outboundLength()
+}
+
+func (c *conn) OutboundBuffered() int {
+	return c.outboundBuffer.Length()
+}
+
+func (c *conn) Write(data []byte) (n int, flushed bool, err error) {
+	if !c.opened {
+		return 0, false, gerrors.ErrConnClosed
+	}
+	written := c.written
+	if err = c.write(data); err == nil && !c.opened {
+		err = gerrors.ErrConnClosed
+	}
+	n = int(c.written - written)
+	flushed = err == nil && c.outboundLength() == 0
+	return
+}
+
+func (c *conn) OnWriteReady(fn func(c Conn, freeSpace int)) {
+	c.onWriteReady = fn
+	if fn != nil && c.opened && c.outboundBuffer.IsEmpty() {
+		_ = c.watchWrite()
+	}
+}
+
+// writePending reports whether the writable event of the connection should be handled, which is the case when there
+// is pending outbound data, a file transfer or OnWriteReady is awaiting.
+func (c *conn) writePending() bool {
+	return !c.outboundBuffer.IsEmpty() || c.transfer != nil || c.onWriteReady != nil
+}
+
+// writeHeadroom returns the free space of the socket send buffer, less the data pending in the outbound buffer.
+func (c *conn) writeHeadroom() int {
+	size, err := socket.GetSendBuffer(c.fd)
+	if err != nil {
+		return 0
+	}
+	queued, _ := socket.GetSendQueue(c.fd)
+	if free := size - queued - c.outboundBuffer.Length(); free > 0 {
+		return free
+	}
+	return 0
+}
+
 func (c *conn) AsyncWrite(buf []byte) error {
-	return c.loop.poller.Trigger(c.asyncWrite, buf)
+	if max := atomic.LoadInt32(&c.maxPending); atomic.AddInt32(&c.pendingWrites, 1) > max && max > 0 {
+		atomic.AddInt32(&c.pendingWrites, -1)
+		return gerrors.ErrTooManyPendingWrites
+	}
+	err := c.trigger(c.asyncWrite, buf, false)
+	if err != nil {
+		atomic.AddInt32(&c.pendingWrites, -1)
+	}
+	return err
+}
+
+func (c *conn) SetMaxPendingWrites(n int) {
+	atomic.StoreInt32(&c.maxPending, int32(n))
+}
+
+func (c *conn) AsyncWriteRaw(data []byte) error {
+	return c.trigger(c.asyncWriteRaw, data, false)
+}
+
+func (c *conn) AsyncWriteTagged(data []byte, tag uint64) error {
+	return c.trigger(c.asyncWriteTagged, &taggedWrite{data: data, tag: tag}, false)
+}
+
+func (c *conn) SetCodec(codec ICodec) {
+	if c.pollAttachment == nil {
+		return
+	}
+	if codec == nil {
+		codec = c.owner().svr.codec
+	}
+	c.codec = codec
+	// The data read along with the current frame is decoded by the new codec in the ongoing loopReact,
+	// whereas the data buffered before needs another pass in case there is no loopReact underway.
+	if !c.inboundBuffer.IsEmpty() {
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopRedecode(c) }, nil, false)
+	}
+}
+
+func (c *conn) Forward(to Conn, frame []byte) error {
+	return forward(to, frame)
 }
 
 func (c *conn) SendTo(buf []byte) error {
 	return c.sendTo(buf)
 }
 
+func (c *conn) ID() uint64 {
+	return c.id
+}
+
+func (c *conn) SetDedicatedGoroutine(dedicated bool) {
+	if c.owner() == nil {
+		return
+	}
+	if !dedicated {
+		if c.dedicated != nil {
+			c.dedicated.drain()
+		}
+		return
+	}
+	if c.dedicated != nil && c.dedicated.resume() {
+		return
+	}
+	el := c.owner()
+	c.dedicated = newDedicatedReactor(c, el.eventHandler, func() {
+		_ = el.poller.Trigger(func(_ interface{}) error { return gerrors.ErrServerShutdown }, nil)
+	})
+}
+
+func (c *conn) Logger() logging.Logger {
+	if c.logger == nil {
+		logger := logging.GetDefaultLogger()
+		if c.owner() != nil {
+			logger = c.owner().svr.opts.Logger
+		}
+		c.logger = logging.With(logger, "conn_id", c.id, "remote_addr", addrString(c.remoteAddr))
+	}
+	return c.logger
+}
+
+func (c *conn) SetCloseBehavior(behavior CloseBehavior) {
+	c.closeBehavior = behavior
+}
+
+func (c *conn) BeginBatch() error {
+	atomic.AddInt32(&c.batchBegins, 1)
+	err := c.trigger(c.beginBatch, nil, false)
+	if err != nil {
+		atomic.AddInt32(&c.batchBegins, -1)
+	}
+	return err
+}
+
+func (c *conn) EndBatch() error {
+	return c.trigger(c.endBatch, nil, false)
+}
+
+func (c *conn) beginBatch(_ interface{}) error {
+	atomic.AddInt32(&c.batchBegins, -1)
+	if c.opened {
+		c.batching = true
+	}
+	return nil
+}
+
+// endBatch sends the data written during the batch all at once.
+func (c *conn) endBatch(_ interface{}) (err error) {
+	if !c.opened || !c.batching {
+		return nil
+	}
+	data := c.batch
+	c.batching, c.batch = false, nil
+	if len(data) > 0 {
+		err = c.send(data)
+	}
+	return
+}
+
+func (c *conn) Cork() error {
+	return socket.SetCork(c.fd, 1)
+}
+
+func (c *conn) Uncork() error {
+	return socket.SetCork(c.fd, 0)
+}
+
+func (c *conn) CongestionControl() (string, error) {
+	if _, ok := c.remoteAddr.(*net.TCPAddr); !ok {
+		return "", gerrors.ErrUnsupportedOp
+	}
+	return socket.GetCongestionControl(c.fd)
+}
+
+func (c *conn) SetCongestionControl(algo string) error {
+	if _, ok := c.remoteAddr.(*net.TCPAddr); !ok {
+		return gerrors.ErrUnsupportedOp
+	}
+	return socket.SetCongestionControl(c.fd, algo)
+}
+
 func (c *conn) Wake() error {
-	return c.loop.poller.UrgentTrigger(func(_ interface{}) error { return c.loop.loopWake(c) }, nil)
+	return c.trigger(func(_ interface{}) error { return c.owner().loopWake(c) }, nil, true)
 }
 
+func (c *conn) Rand() *rand.Rand { return c.owner().getRand() }
+
 func (c *conn) Close() error {
-	return c.loop.poller.Trigger(func(_ interface{}) error { return c.loop.loopCloseConn(c, nil) }, nil)
+	return c.trigger(func(_ interface{}) error { return c.owner().loopCloseConn(c, nil) }, nil, false)
+}
+
+func (c *conn) CloseAfterFlush() error {
+	return c.trigger(func(_ interface{}) error { return c.owner().loopCloseAfterFlush(c) }, nil, false)
+}
+
+func (c *conn) MigrateToLoop(index int) error {
+	if c.owner() == nil || c.pollAttachment == nil {
+		return gerrors.ErrUnsupportedOp
+	}
+	var target *eventloop
+	c.owner().svr.lb.iterate(func(i int, el *eventloop) bool {
+		if i == index {
+			target = el
+			return false
+		}
+		return true
+	})
+	if target == nil {
+		return gerrors.ErrInvalidLoopIndex
+	}
+	if target == c.owner() {
+		return nil
+	}
+	// Migrate the connection after the current event, which might still be decoding the inbound data.
+	el := c.owner()
+	return el.poller.Trigger(func(_ interface{}) error {
+		if !c.opened || c.owner() != el {
+			return nil
+		}
+		// The inbound data of the current event has been buffered by now.
+		c.buffer = nil
+		return el.loopMigrate(c, target)
+	}, nil)
+}
+
+// owner returns the event-loop which the connection belongs to, it is safe to be called from any goroutine
+// while the connection is migrating. The event-loop is always accessed via owner and setOwner, even on
+// the event-loop goroutine, so that no access to it races with a migration.
+func (c *conn) owner() *eventloop {
+	return (*eventloop)(atomic.LoadPointer(&c.loop))
+}
+
+func (c *conn) setOwner(el *eventloop) {
+	atomic.StorePointer(&c.loop, unsafe.Pointer(el))
 }
 
+// trigger runs the task of the connection in the event-loop it belongs to, the task is handed over again
+// if the connection has migrated to another event-loop by the time the task runs.
+func (c *conn) trigger(fn queue.TaskFunc, arg interface{}, urgent bool) error {
+	el := c.owner()
+	task := func(arg interface{}) error {
+		if c.owner() != el {
+			return c.trigger(fn, arg, urgent)
+		}
+		return fn(arg)
+	}
+	if urgent {
+		return el.poller.UrgentTrigger(task, arg)
+	}
+	return el.poller.Trigger(task, arg)
+}
+
+func (c *conn) CloseCause() CloseCause { return c.closeCause }
+
+func (c *conn) SetLabels(labels map[string]string) {
+	c.setLabels(&c.owner().svr.metrics, labels)
+}
+
+func (c *conn) Labels() map[string]string { return c.labels }
+
+func (c *conn) Stats() Stats { return c.stats.snapshot() }
+
+func (c *conn) ResetStats() Stats { return c.resetStats() }
+
+func (c *conn) OpenedAt() time.Time { return c.acceptedAt }
+
+func (c *conn) OnCleanup(fn func()) { c.cleanups = append(c.cleanups, fn) }
+
+// runCleanups runs the callbacks registered by OnCleanup in reverse order, each one of them runs at most once.
+func (c *conn) runCleanups() {
+	for len(c.cleanups) > 0 {
+		fn := c.cleanups[len(c.cleanups)-1]
+		c.cleanups = c.cleanups[:len(c.cleanups)-1]
+		fn()
+	}
+	c.cleanups = nil
+}
+
+// isReleased reports whether the connection has been closed, it is safe to call from any goroutine.
+func (c *conn) isReleased() bool { return atomic.LoadInt32(&c.released) == 1 }
+
 func (c *conn) Context() interface{}       { return c.ctx }
 func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
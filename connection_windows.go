@@ -22,9 +22,14 @@
 package gnet
 
 import (
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/logging"
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
 	"github.com/panjf2000/gnet/ringbuffer"
@@ -59,7 +64,18 @@ var (
 	dataTaskPool   = sync.Pool{New: func() interface{} { return new(dataTask) }}
 )
 
+// readDeadline is the read deadline of a connection, which is pushed back by window whenever the reader goroutine
+// reads some data, making it an idle timeout like on the other platforms rather than a hard deadline of net.Conn.
+type readDeadline struct {
+	mu     sync.Mutex
+	window time.Duration
+}
+
 type stdConn struct {
+	connMetrics
+	codecStates
+
+	id            uint64                 // connection identifier
 	ctx           interface{}            // user-defined context
 	conn          net.Conn               // original connection
 	loop          *eventloop             // owner event-loop
@@ -69,6 +85,25 @@ type stdConn struct {
 	remoteAddr    net.Addr               // remote peer addr
 	byteBuffer    *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
 	inboundBuffer *ringbuffer.RingBuffer // buffer for data from client
+	pendingOpen   bool                   // connection opened event deferred until the first inbound data
+	handshaked    bool                   // handshake done, codec engaged
+	closeBehavior CloseBehavior          // how to treat the pending outbound data on closing
+	closeCause    CloseCause             // why the connection is closed
+	readDeadline  readDeadline           // read deadline pushed back on progress
+	closing       bool                   // connection closed by the server
+	goodbye       *goodbye               // close handshake started by CloseGracefully
+	rateLimit     *inboundRateLimit      // token bucket throttling the inbound frames
+	decompressor  *streamDecompressor    // decompressor of the compressed inbound stream
+	dedicated     *dedicatedReactor      // reactor running React on a dedicated goroutine
+	transfer      *fileTransfer          // file being sent by ServeFile
+	batching      bool                   // writes held back until EndBatch
+	batchBegins   int32                  // calls of BeginBatch that haven't taken effect on the event-loop yet
+	batch         []byte                 // data written since BeginBatch
+	pendingWrites int32                  // AsyncWrites issued but not yet written
+	maxPending    int32                  // limit of pendingWrites set by SetMaxPendingWrites
+	released      int32                  // set once the connection is closed and about to be unregistered
+	cleanups      []func()               // callbacks registered by OnCleanup
+	logger        logging.Logger         // logger tagged with the connection
 }
 
 func packTCPConn(c *stdConn, buf []byte) *tcpConn {
@@ -86,6 +121,7 @@ func packUDPConn(c *stdConn, buf []byte) *udpConn {
 
 func newTCPConn(conn net.Conn, el *eventloop) (c *stdConn) {
 	c = &stdConn{
+		id:            nextConnID(),
 		conn:          conn,
 		loop:          el,
 		codec:         el.svr.codec,
@@ -93,6 +129,10 @@ func newTCPConn(conn net.Conn, el *eventloop) (c *stdConn) {
 	}
 	c.localAddr = el.svr.ln.lnaddr
 	c.remoteAddr = c.conn.RemoteAddr()
+	c.acceptedAt = time.Now()
+	if init := el.svr.opts.ConnInitFunc; init != nil {
+		init(c)
+	}
 
 	var (
 		ok bool
@@ -124,6 +164,27 @@ func (c *stdConn) releaseTCP() {
 	c.inboundBuffer = ringbuffer.EmptyRingBuffer
 	bytebuffer.Put(c.buffer)
 	c.buffer = nil
+	c.resetLabels()
+	c.codecStates = nil
+	c.logger = nil
+	c.batching = false
+	c.batch = nil
+	if c.goodbye != nil {
+		c.goodbye.timer.Stop()
+		c.goodbye = nil
+	}
+	if c.rateLimit.throttled() {
+		c.rateLimit.timer.Stop()
+	}
+	c.rateLimit = nil
+	if c.decompressor != nil {
+		c.decompressor.stop()
+		c.decompressor = nil
+	}
+	if c.dedicated != nil {
+		c.dedicated.stop()
+		c.dedicated = nil
+	}
 }
 
 func newUDPConn(el *eventloop, localAddr, remoteAddr net.Addr) *stdConn {
@@ -136,23 +197,82 @@ func newUDPConn(el *eventloop, localAddr, remoteAddr net.Addr) *stdConn {
 }
 
 func (c *stdConn) releaseUDP() {
+	c.runCleanups()
 	c.ctx = nil
 	c.localAddr = nil
 	bytebuffer.Put(c.buffer)
 	c.buffer = nil
+	c.resetLabels()
+}
+
+func (c *stdConn) dump() ConnDump {
+	return ConnDump{
+		ID:              c.id,
+		LocalAddr:       addrString(c.localAddr),
+		RemoteAddr:      addrString(c.remoteAddr),
+		InboundBuffered: c.inboundBuffer.Length(),
+		LastActive:      c.lastActive,
+		State:           connState(c.pendingOpen, c.handshaked),
+		Labels:          c.Labels(),
+	}
 }
 
 func (c *stdConn) read() ([]byte, error) {
+	if c.loop.svr.opts.NoInboundBuffer {
+		if c.BufferLength() == 0 {
+			return nil, nil
+		}
+		buf := c.Read()
+		c.buffer = bytebuffer.Get()
+		c.inboundBuffer.Reset()
+		return buf, nil
+	}
 	return c.codec.Decode(c)
 }
 
+// resync skips the corrupted bytes in buffers if the codec implements Resyncer,
+// it reports whether the decoding is able to go on.
+func (c *stdConn) resync() bool {
+	r, ok := c.codec.(Resyncer)
+	if !ok {
+		return false
+	}
+	skip, err := r.Resync(c.Read())
+	if err != nil || skip <= 0 {
+		return false
+	}
+	c.ShiftN(skip)
+	return true
+}
+
 func (c *stdConn) write(data []byte) (n int, err error) {
+	// The data written during a file transfer is held back until the file is sent.
+	if c.transfer != nil {
+		c.transfer.tail = append(c.transfer.tail, data...)
+		return len(data), nil
+	}
 	if c.conn != nil {
 		n, err = c.conn.Write(data)
+		c.addWritten(n, time.Now())
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = errors.ErrWriteTimeout
+		}
 	}
 	return
 }
 
+func (c *stdConn) writeFrame(frame []byte) (int, error) {
+	c.addFrameWritten()
+	// The data written in a batch is held back until the batch ends, a batch begins as soon as BeginBatch is called,
+	// otherwise the data returned by the callback calling BeginBatch would be written ahead of the batch.
+	if c.batching || atomic.LoadInt32(&c.batchBegins) > 0 {
+		c.batching = true
+		c.batch = append(c.batch, frame...)
+		return len(frame), nil
+	}
+	return c.write(frame)
+}
+
 // ================================= Public APIs of gnet.Conn =================================
 
 func (c *stdConn) Read() []byte {
@@ -228,22 +348,200 @@ func (c *stdConn) BufferLength() int {
 	return c.inboundBuffer.Length() + c.buffer.Len()
 }
 
+func (c *stdConn) OutboundLength() int {
+	return 0
+}
+
+func (c *stdConn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *stdConn) Write(data []byte) (n int, flushed bool, err error) {
+	if c.conn == nil {
+		return 0, false, errors.ErrConnClosed
+	}
+	var frame []byte
+	if frame, err = c.codec.Encode(c, data); err != nil {
+		return
+	}
+	c.loop.eventHandler.PreWrite()
+	n, err = c.writeFrame(frame)
+	return n, err == nil, err
+}
+
+func (c *stdConn) OnWriteReady(_ func(c Conn, freeSpace int)) {}
+
+func (c *stdConn) MigrateToLoop(_ int) error {
+	return errors.ErrUnsupportedOp
+}
+
 func (c *stdConn) AsyncWrite(buf []byte) (err error) {
+	if max := atomic.LoadInt32(&c.maxPending); atomic.AddInt32(&c.pendingWrites, 1) > max && max > 0 {
+		atomic.AddInt32(&c.pendingWrites, -1)
+		return errors.ErrTooManyPendingWrites
+	}
 	var encodedBuf []byte
 	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
 		task := dataTaskPool.Get().(*dataTask)
-		task.run = c.write
+		task.run = c.asyncWrite
 		task.buf = encodedBuf
 		c.loop.ch <- task
+		return
 	}
+	atomic.AddInt32(&c.pendingWrites, -1)
 	return
 }
 
+// asyncWrite writes the frame of AsyncWrite, which is flushed once it returns since the writes are not buffered.
+func (c *stdConn) asyncWrite(frame []byte) (int, error) {
+	defer atomic.AddInt32(&c.pendingWrites, -1)
+	return c.writeFrame(frame)
+}
+
+func (c *stdConn) SetMaxPendingWrites(n int) {
+	atomic.StoreInt32(&c.maxPending, int32(n))
+}
+
+func (c *stdConn) AsyncWriteRaw(data []byte) error {
+	task := dataTaskPool.Get().(*dataTask)
+	task.run = c.writeFrame
+	task.buf = data
+	c.loop.ch <- task
+	return nil
+}
+
+func (c *stdConn) AsyncWriteTagged(data []byte, tag uint64) (err error) {
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, data); err == nil {
+		task := dataTaskPool.Get().(*dataTask)
+		// The data is written to the socket directly, thus it is flushed once the write returns.
+		task.run = func(buf []byte) (n int, err error) {
+			if n, err = c.writeFrame(buf); err == nil && c.conn != nil {
+				if fh, ok := c.loop.eventHandler.(FlushHandler); ok {
+					fh.OnWriteFlushed(c, tag)
+				}
+			}
+			return
+		}
+		task.buf = encodedBuf
+		c.loop.ch <- task
+	}
+	return
+}
+
+func (c *stdConn) SetCodec(codec ICodec) {
+	if c.conn == nil {
+		return
+	}
+	if codec == nil {
+		codec = c.loop.svr.codec
+	}
+	c.codec = codec
+	if !c.inboundBuffer.IsEmpty() {
+		task := signalTaskPool.Get().(*signalTask)
+		task.run = c.loop.loopRedecode
+		task.c = c
+		c.loop.ch <- task
+	}
+}
+
+func (c *stdConn) Forward(to Conn, frame []byte) error {
+	return forward(to, frame)
+}
+
 func (c *stdConn) SendTo(buf []byte) (err error) {
 	_, err = c.loop.svr.ln.pconn.WriteTo(buf, c.remoteAddr)
 	return
 }
 
+func (c *stdConn) ID() uint64 {
+	return c.id
+}
+
+func (c *stdConn) SetDedicatedGoroutine(dedicated bool) {
+	if c.conn == nil {
+		return
+	}
+	if !dedicated {
+		if c.dedicated != nil {
+			c.dedicated.drain()
+		}
+		return
+	}
+	if c.dedicated != nil && c.dedicated.resume() {
+		return
+	}
+	el := c.loop
+	c.dedicated = newDedicatedReactor(c, el.eventHandler, func() { el.ch <- errors.ErrServerShutdown })
+}
+
+func (c *stdConn) Logger() logging.Logger {
+	if c.logger == nil {
+		c.logger = logging.With(c.loop.svr.opts.Logger, "conn_id", c.id, "remote_addr", addrString(c.remoteAddr))
+	}
+	return c.logger
+}
+
+func (c *stdConn) CloseCause() CloseCause { return c.closeCause }
+
+func (c *stdConn) SetCloseBehavior(behavior CloseBehavior) {
+	c.closeBehavior = behavior
+}
+
+func (c *stdConn) BeginBatch() error {
+	atomic.AddInt32(&c.batchBegins, 1)
+	task := signalTaskPool.Get().(*signalTask)
+	task.run = (*stdConn).beginBatch
+	task.c = c
+	c.loop.ch <- task
+	return nil
+}
+
+func (c *stdConn) EndBatch() error {
+	task := signalTaskPool.Get().(*signalTask)
+	task.run = (*stdConn).endBatch
+	task.c = c
+	c.loop.ch <- task
+	return nil
+}
+
+func (c *stdConn) beginBatch() error {
+	atomic.AddInt32(&c.batchBegins, -1)
+	if c.conn != nil {
+		c.batching = true
+	}
+	return nil
+}
+
+// endBatch sends the data written during the batch all at once.
+func (c *stdConn) endBatch() (err error) {
+	if c.conn == nil || !c.batching {
+		return nil
+	}
+	data := c.batch
+	c.batching, c.batch = false, nil
+	if len(data) > 0 {
+		_, err = c.write(data)
+	}
+	return
+}
+
+func (c *stdConn) Cork() error {
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) Uncork() error {
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) CongestionControl() (string, error) {
+	return "", errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetCongestionControl(_ string) error {
+	return errors.ErrUnsupportedOp
+}
+
 func (c *stdConn) Wake() error {
 	task := signalTaskPool.Get().(*signalTask)
 	task.run = c.loop.loopWake
@@ -252,6 +550,66 @@ func (c *stdConn) Wake() error {
 	return nil
 }
 
+func (c *stdConn) Probe() error {
+	if c.conn == nil {
+		return errors.ErrUnsupportedOp
+	}
+	frame := c.loop.svr.opts.ProbeFrame
+	if len(frame) == 0 {
+		return nil
+	}
+	_, err := c.writeFrame(frame)
+	if err != nil {
+		task := signalTaskPool.Get().(*signalTask)
+		task.run = func(c *stdConn) error { return c.loop.loopPeerUnreachable(c, err) }
+		task.c = c
+		c.loop.ch <- task
+	}
+	return err
+}
+
+func (c *stdConn) SetReadDeadline(t time.Time) error {
+	if c.conn == nil {
+		return errors.ErrUnsupportedOp
+	}
+	if c.closing {
+		return nil // the deadline has been brought forward to close the connection.
+	}
+	var window time.Duration
+	if !t.IsZero() {
+		if window = time.Until(t); window < 0 {
+			window = 0
+		}
+	}
+	return c.setReadDeadline(t, window)
+}
+
+// setReadDeadline sets the read deadline of net.Conn to t, which is then pushed back by window on progress.
+func (c *stdConn) setReadDeadline(t time.Time, window time.Duration) error {
+	c.readDeadline.mu.Lock()
+	defer c.readDeadline.mu.Unlock()
+	c.readDeadline.window = window
+	return c.conn.SetReadDeadline(t)
+}
+
+// pushReadDeadline pushes back the read deadline after some data is read, it is called by the reader goroutine.
+func (c *stdConn) pushReadDeadline() {
+	c.readDeadline.mu.Lock()
+	if c.readDeadline.window > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.readDeadline.window))
+	}
+	c.readDeadline.mu.Unlock()
+}
+
+func (c *stdConn) SetWriteDeadline(t time.Time) error {
+	if c.conn == nil {
+		return errors.ErrUnsupportedOp
+	}
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *stdConn) Rand() *rand.Rand { return c.loop.getRand() }
+
 func (c *stdConn) Close() error {
 	task := signalTaskPool.Get().(*signalTask)
 	task.run = c.loop.loopCloseConn
@@ -260,6 +618,38 @@ func (c *stdConn) Close() error {
 	return nil
 }
 
+// CloseAfterFlush is the same as Close on Windows, where the data is written to the socket synchronously.
+func (c *stdConn) CloseAfterFlush() error {
+	return c.Close()
+}
+
+func (c *stdConn) SetLabels(labels map[string]string) {
+	c.setLabels(&c.loop.svr.metrics, labels)
+}
+
+func (c *stdConn) Labels() map[string]string { return c.labels }
+
+func (c *stdConn) Stats() Stats { return c.stats.snapshot() }
+
+func (c *stdConn) ResetStats() Stats { return c.resetStats() }
+
+func (c *stdConn) OnCleanup(fn func()) { c.cleanups = append(c.cleanups, fn) }
+
+// runCleanups runs the callbacks registered by OnCleanup in reverse order, each one of them runs at most once.
+func (c *stdConn) runCleanups() {
+	for len(c.cleanups) > 0 {
+		fn := c.cleanups[len(c.cleanups)-1]
+		c.cleanups = c.cleanups[:len(c.cleanups)-1]
+		fn()
+	}
+	c.cleanups = nil
+}
+
+// isReleased reports whether the connection has been closed, it is safe to call from any goroutine.
+func (c *stdConn) isReleased() bool { return atomic.LoadInt32(&c.released) == 1 }
+
+func (c *stdConn) OpenedAt() time.Time { return c.acceptedAt }
+
 func (c *stdConn) Context() interface{}       { return c.ctx }
 func (c *stdConn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *stdConn) LocalAddr() net.Addr        { return c.localAddr }
@@ -0,0 +1,101 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"time"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+)
+
+// deadline is the deadline for the progress of reading or writing a connection.
+type deadline struct {
+	timer    *time.Timer
+	window   time.Duration // duration by which the deadline is pushed back on progress
+	progress uint64        // bytes read or written when the deadline was set or pushed back
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(&c.readDeadline, t, c.received, (*eventloop).loopReadDeadline)
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(&c.writeDeadline, t, c.written, (*eventloop).loopWriteDeadline)
+}
+
+// setDeadline replaces the deadline in dl with a new one expiring at t, expire is run on the event-loop
+// of the connection when it does, a zero t clears the deadline.
+func (c *conn) setDeadline(dl **deadline, t time.Time, progress uint64,
+	expire func(el *eventloop, c *conn, d *deadline) error) error {
+	if c.pollAttachment == nil {
+		return gerrors.ErrUnsupportedOp
+	}
+	if *dl != nil {
+		(*dl).timer.Stop()
+		*dl = nil
+	}
+	if t.IsZero() {
+		return nil
+	}
+
+	d := &deadline{window: time.Until(t), progress: progress}
+	if d.window < 0 {
+		d.window = 0
+	}
+	*dl = d
+	d.timer = time.AfterFunc(d.window, func() {
+		_ = c.trigger(func(_ interface{}) error { return expire(c.owner(), c, d) }, nil, false)
+	})
+	return nil
+}
+
+// loopReadDeadline checks the progress of reading the connection when the read deadline expires.
+func (el *eventloop) loopReadDeadline(c *conn, d *deadline) error {
+	if !c.opened || c.readDeadline != d {
+		return nil // stale deadline
+	}
+	// The connection isn't to blame for the lack of progress while the server holds off reading it.
+	if d.window > 0 && (c.received > d.progress || c.readPaused) {
+		d.progress = c.received
+		d.timer.Reset(d.window)
+		return nil
+	}
+	return el.loopCloseConn(c, gerrors.ErrReadTimeout)
+}
+
+// loopWriteDeadline checks the progress of the pending outbound data when the write deadline expires.
+func (el *eventloop) loopWriteDeadline(c *conn, d *deadline) error {
+	if !c.opened || c.writeDeadline != d {
+		return nil // stale deadline
+	}
+	if c.outboundBuffer.IsEmpty() && c.transfer == nil {
+		c.writeDeadline = nil
+		return nil
+	}
+	if d.window > 0 && c.written > d.progress {
+		d.progress = c.written
+		d.timer.Reset(d.window)
+		return nil
+	}
+	return el.loopCloseConn(c, gerrors.ErrWriteTimeout)
+}
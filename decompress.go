@@ -0,0 +1,203 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+
+	errorset "github.com/panjf2000/gnet/errors"
+)
+
+// Compression is the algorithm of a compressed inbound stream, see Conn.EnableStreamDecompression.
+type Compression int
+
+const (
+	// CompressionGzip is the gzip format specified by RFC 1952, concatenated members are decompressed in order.
+	CompressionGzip Compression = iota + 1
+	// CompressionZlib is the zlib format specified by RFC 1950.
+	CompressionZlib
+	// CompressionDeflate is the raw DEFLATE format specified by RFC 1951.
+	CompressionDeflate
+)
+
+// decompressChunkSize is the size of the buffer the decompressed data is read into.
+const decompressChunkSize = 16 * 1024
+
+// maxDecompressedRead is the maximum number of bytes decompressed from the data of a single read, which protects
+// the server from the decompression bombs, the connection is closed with errors.ErrDecompressionLimit beyond it.
+const maxDecompressedRead = 4 * 1024 * 1024
+
+// decompressed is the outcome of decompressing the data pushed to a streamDecompressor.
+type decompressed struct {
+	data []byte
+	err  error
+}
+
+// streamDecompressor decompresses the inbound stream of a connection synchronously on the event-loop.
+//
+// The decompressors of the standard library can't be suspended on incomplete input, thus the stream is read by
+// a goroutine parked in Read of the decompressor, which is driven by the event-loop: push hands the compressed data
+// over and waits until the goroutine has consumed all of it and asks for more, so there is at most one chunk
+// of compressed data in flight and the decompressed data is returned straight to the event-loop.
+type streamDecompressor struct {
+	algo    Compression
+	held    []byte // compressed data buffered ahead of the decompression, see hold
+	running bool   // whether the decompressing goroutine has been started
+	err     error  // terminal error of the stream
+
+	in  chan []byte       // compressed data handed over to the decompressing goroutine
+	out chan decompressed // data decompressed from the compressed data handed over
+
+	// Accessed by the decompressing goroutine only.
+	chunk []byte
+	data  []byte
+}
+
+func newStreamDecompressor(algo Compression) (*streamDecompressor, error) {
+	switch algo {
+	case CompressionGzip, CompressionZlib, CompressionDeflate:
+	default:
+		return nil, errorset.ErrUnsupportedCompression
+	}
+	return &streamDecompressor{
+		algo: algo,
+		in:   make(chan []byte),
+		out:  make(chan decompressed, 1),
+	}, nil
+}
+
+// hold buffers a copy of the compressed data to be decompressed along with the data of the next push.
+func (sd *streamDecompressor) hold(data []byte) {
+	sd.held = append(sd.held, data...)
+}
+
+// holding reports whether there is compressed data held by hold.
+func (sd *streamDecompressor) holding() bool {
+	return len(sd.held) > 0
+}
+
+// push decompresses the compressed data, it returns the data decompressed so far and the error that ends
+// the stream, which is io.EOF for the end of a zlib or DEFLATE stream.
+// The data is consumed before push returns, thus it can be reused by the caller afterwards.
+func (sd *streamDecompressor) push(data []byte) ([]byte, error) {
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	if len(sd.held) > 0 {
+		data, sd.held = append(sd.held, data...), nil
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if !sd.running {
+		sd.running = true
+		go sd.run(data)
+	} else {
+		sd.in <- data
+	}
+	res := <-sd.out
+	sd.err = res.err
+	return res.data, res.err
+}
+
+// stop makes the decompressing goroutine exit, the data that hasn't been decompressed is discarded.
+func (sd *streamDecompressor) stop() {
+	sd.held = nil
+	if sd.running && sd.err == nil {
+		sd.err = io.EOF
+		close(sd.in)
+	}
+}
+
+// fill hands the data decompressed so far back to the event-loop once the compressed data runs out and waits
+// for more, it reports false if the decompressor is stopped.
+func (sd *streamDecompressor) fill() bool {
+	for len(sd.chunk) == 0 {
+		sd.out <- decompressed{data: sd.data}
+		sd.data = nil
+		chunk, ok := <-sd.in
+		if !ok {
+			return false
+		}
+		sd.chunk = chunk
+	}
+	return true
+}
+
+// Read feeds the decompressor with the compressed data pushed by the event-loop.
+func (sd *streamDecompressor) Read(p []byte) (n int, err error) {
+	if !sd.fill() {
+		return 0, io.EOF
+	}
+	n = copy(p, sd.chunk)
+	sd.chunk = sd.chunk[n:]
+	return
+}
+
+// ReadByte makes the decompressors read the compressed data without buffering it ahead, which would otherwise
+// be lost when the gzip reader is reset for the next member.
+func (sd *streamDecompressor) ReadByte() (byte, error) {
+	if !sd.fill() {
+		return 0, io.EOF
+	}
+	b := sd.chunk[0]
+	sd.chunk = sd.chunk[1:]
+	return b, nil
+}
+
+func (sd *streamDecompressor) open() (io.Reader, error) {
+	switch sd.algo {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(sd)
+		if err != nil {
+			return nil, err
+		}
+		// The members are read one by one, otherwise the data of a member would be held back until
+		// the header of the next one arrives.
+		zr.Multistream(false)
+		return zr, nil
+	case CompressionZlib:
+		return zlib.NewReader(sd)
+	default:
+		return flate.NewReader(sd), nil
+	}
+}
+
+func (sd *streamDecompressor) run(chunk []byte) {
+	sd.chunk = chunk
+	r, err := sd.open()
+	buf := make([]byte, decompressChunkSize)
+	for err == nil {
+		var n int
+		n, err = r.Read(buf)
+		if sd.data = append(sd.data, buf[:n]...); len(sd.data) > maxDecompressedRead {
+			sd.data, err = nil, errorset.ErrDecompressionLimit
+		}
+		if zr, ok := r.(*gzip.Reader); ok && err == io.EOF {
+			err = zr.Reset(sd)
+			zr.Multistream(false)
+		}
+	}
+	sd.out <- decompressed{data: sd.data, err: err}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+func (c *conn) EnableStreamDecompression(algo Compression) (err error) {
+	if c.decompressor != nil {
+		return nil
+	}
+	if c.decompressor, err = newStreamDecompressor(algo); err != nil {
+		return
+	}
+	// The data buffered after the current frame is already compressed, it is decompressed once loopReact
+	// is done with the current frame.
+	if c.BufferLength() > 0 {
+		c.decompressor.hold(c.Read())
+		c.buffer = c.buffer[:0]
+		c.inboundBuffer.Reset()
+	}
+	return
+}
+
+// loopDecompress fires the events of the connection for the data decompressed from its inbound stream,
+// the connection is closed once the stream turns out to be corrupted or ends.
+func (el *eventloop) loopDecompress(c *conn, data []byte) error {
+	out, err := c.decompressor.push(data)
+	if len(out) > 0 {
+		c.buffer = out
+		if e := el.loopReact(c); e != nil || !c.opened {
+			return e
+		}
+	}
+	if err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	return el.checkBufferBudget(c)
+}
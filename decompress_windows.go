@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build windows
+
+package gnet
+
+import "github.com/panjf2000/gnet/pool/bytebuffer"
+
+func (c *stdConn) EnableStreamDecompression(algo Compression) (err error) {
+	if c.decompressor != nil {
+		return nil
+	}
+	if c.decompressor, err = newStreamDecompressor(algo); err != nil {
+		return
+	}
+	// The data buffered after the current frame is already compressed, it is decompressed once loopReact
+	// is done with the current frame.
+	if c.buffer != nil {
+		c.decompressor.hold(c.Read())
+		c.buffer.Reset()
+	} else {
+		head, tail := c.inboundBuffer.PeekAll()
+		c.decompressor.hold(head)
+		c.decompressor.hold(tail)
+	}
+	c.inboundBuffer.Reset()
+	return
+}
+
+// loopDecompress fires the events of the connection for the data decompressed from its inbound stream,
+// the connection is closed once the stream turns out to be corrupted or ends.
+func (el *eventloop) loopDecompress(c *stdConn, data []byte) error {
+	out, err := c.decompressor.push(data)
+	if len(out) > 0 {
+		c.buffer = bytebuffer.Get()
+		_, _ = c.buffer.Write(out)
+		if e := el.loopReact(c); e != nil || c.closing {
+			return e
+		}
+	}
+	if err != nil {
+		return el.loopError(c, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import "sync"
+
+// dedicatedReactor runs React for the frames of a connection on a dedicated goroutine,
+// the frames are handed over by the event-loop in order and reacted to in the same order.
+type dedicatedReactor struct {
+	c            Conn
+	eventHandler EventHandler
+	shutdown     func()
+
+	mu       sync.Mutex
+	frames   [][]byte
+	draining bool // exiting once the frames queued have been reacted to
+	stopped  bool
+	notify   chan struct{}
+}
+
+func newDedicatedReactor(c Conn, eventHandler EventHandler, shutdown func()) *dedicatedReactor {
+	dr := &dedicatedReactor{
+		c:            c,
+		eventHandler: eventHandler,
+		shutdown:     shutdown,
+		notify:       make(chan struct{}, 1),
+	}
+	go dr.run()
+	return dr
+}
+
+// push hands a copy of the frame over to the dedicated goroutine, it never blocks the event-loop. It reports false
+// if the dedicated goroutine has exited, after which the event-loop is supposed to react to the frame by itself.
+func (dr *dedicatedReactor) push(frame []byte) bool {
+	frame = append([]byte(nil), frame...)
+	dr.mu.Lock()
+	if dr.stopped {
+		dr.mu.Unlock()
+		return false
+	}
+	dr.frames = append(dr.frames, frame)
+	dr.mu.Unlock()
+	dr.wake()
+	return true
+}
+
+// drain makes the dedicated goroutine exit once it has reacted to the frames queued, including the ones pushed
+// in the meantime, thus the frames are still reacted to in order when the event-loop takes over.
+func (dr *dedicatedReactor) drain() {
+	dr.mu.Lock()
+	dr.draining = true
+	dr.mu.Unlock()
+	dr.wake()
+}
+
+// resume cancels drain, it reports false if the dedicated goroutine has exited already.
+func (dr *dedicatedReactor) resume() bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.stopped {
+		return false
+	}
+	dr.draining = false
+	return true
+}
+
+// stop makes the dedicated goroutine exit, the frames that haven't been reacted to are discarded.
+func (dr *dedicatedReactor) stop() {
+	dr.mu.Lock()
+	dr.stopped = true
+	dr.frames = nil
+	dr.mu.Unlock()
+	dr.wake()
+}
+
+func (dr *dedicatedReactor) wake() {
+	select {
+	case dr.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (dr *dedicatedReactor) run() {
+	for range dr.notify {
+		for {
+			dr.mu.Lock()
+			if dr.stopped {
+				dr.mu.Unlock()
+				return
+			}
+			if len(dr.frames) == 0 {
+				if dr.draining {
+					dr.stopped = true
+					dr.mu.Unlock()
+					return
+				}
+				dr.mu.Unlock()
+				break
+			}
+			frame := dr.frames[0]
+			dr.frames[0] = nil
+			dr.frames = dr.frames[1:]
+			dr.mu.Unlock()
+
+			if !reactAsync(dr.eventHandler, dr.c, frame, dr.shutdown) {
+				dr.stop()
+			}
+		}
+	}
+}
+
+// reactAsync runs React for the frame outside the event-loop, it reports whether the connection is still alive.
+func reactAsync(eventHandler EventHandler, c Conn, frame []byte, shutdown func()) bool {
+	out, action := eventHandler.React(frame, c)
+	if out != nil {
+		_ = c.AsyncWrite(out)
+	}
+	switch action {
+	case None:
+	case Close:
+		_ = c.Close()
+		return false
+	case Shutdown:
+		shutdown()
+		return false
+	}
+	return true
+}
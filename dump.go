@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// dumpTimeout is how long Dump waits for an event-loop to take the snapshot of its connections.
+const dumpTimeout = time.Second
+
+// ServerDump is a snapshot of the state of a server for debugging, it can be serialized to JSON.
+type ServerDump struct {
+	// Addr is the listening address of the server.
+	Addr string `json:"addr"`
+
+	// Healthy indicates whether the server is accepting connections.
+	Healthy bool `json:"healthy"`
+
+	// Loops are the snapshots of event-loops.
+	Loops []LoopDump `json:"loops"`
+}
+
+// LoopDump is a snapshot of an event-loop.
+type LoopDump struct {
+	// Index is the index of the event-loop.
+	Index int `json:"index"`
+
+	// Connections is the number of active connections of the event-loop.
+	Connections int32 `json:"connections"`
+
+	// Responsive indicates whether the event-loop took the snapshot of its connections in time,
+	// an unresponsive event-loop is probably stuck in a blocking event callback.
+	Responsive bool `json:"responsive"`
+
+	// Conns are the snapshots of connections of the event-loop, it is empty if the event-loop is unresponsive.
+	Conns []ConnDump `json:"conns,omitempty"`
+}
+
+// ConnDump is a snapshot of a connection.
+type ConnDump struct {
+	// ID is the identifier of the connection.
+	ID uint64 `json:"id"`
+
+	// LocalAddr is the local address of the connection.
+	LocalAddr string `json:"local_addr"`
+
+	// RemoteAddr is the remote address of the connection.
+	RemoteAddr string `json:"remote_addr"`
+
+	// InboundBuffered is the number of bytes which are buffered and not yet consumed by the codec.
+	InboundBuffered int `json:"inbound_buffered"`
+
+	// OutboundBuffered is the number of bytes which are buffered and not yet written to the socket.
+	OutboundBuffered int `json:"outbound_buffered"`
+
+	// LastActive is the time of the last read or write of the connection, as of the wakeup of the event-loop doing it.
+	LastActive time.Time `json:"last_active"`
+
+	// State is one of "pending-open", "handshaking" and "open".
+	State string `json:"state"`
+
+	// Labels are the labels attached to the connection.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Dump takes a snapshot of the event-loops and their connections, it is meant for diagnosing servers in production,
+// e.g. printing the snapshot in JSON on receiving SIGUSR1. Each event-loop is given one second to respond,
+// thus Dump should not be called in event callbacks which would block the event-loop from responding.
+func (s Server) Dump() ServerDump {
+	d := ServerDump{Healthy: s.Healthy()}
+	if s.Addr != nil {
+		d.Addr = s.Addr.String()
+	}
+	// Take a snapshot of the event-loops first rather than waiting for them under the lock of the load-balancer.
+	var eventLoops []*eventloop
+	s.svr.lb.iterate(func(_ int, el *eventloop) bool {
+		eventLoops = append(eventLoops, el)
+		return true
+	})
+	for _, el := range eventLoops {
+		ld := LoopDump{Index: el.idx, Connections: el.loadConn()}
+		ld.Conns, ld.Responsive = el.dump(dumpTimeout)
+		d.Loops = append(d.Loops, ld)
+	}
+	return d
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -31,6 +31,14 @@ var (
 	ErrAcceptSocket = errors.New("accept a new connection error")
 	// ErrTooManyEventLoopThreads occurs when attempting to set up more than 10,000 event-loop goroutines under LockOSThread mode.
 	ErrTooManyEventLoopThreads = errors.New("too many event-loops under LockOSThread mode")
+	// ErrInvalidNumEventLoop occurs when attempting to scale the event-loops to a non-positive number.
+	ErrInvalidNumEventLoop = errors.New("the number of event-loops must be positive")
+	// ErrInvalidLoopIndex occurs when the index of event-loop is out of range.
+	ErrInvalidLoopIndex = errors.New("the index of event-loop is out of range")
+	// ErrInvalidLoadBalancing occurs when the load-balancing algorithm is unknown or the custom one is nil.
+	ErrInvalidLoadBalancing = errors.New("the load-balancing algorithm is unknown")
+	// ErrLastEventLoop occurs when draining the only event-loop that is eligible for new connections.
+	ErrLastEventLoop = errors.New("the last event-loop eligible for new connections can't be drained")
 	// ErrUnsupportedProtocol occurs when trying to use protocol that is not supported.
 	ErrUnsupportedProtocol = errors.New("only unix, tcp/tcp4/tcp6, udp/udp4/udp6 are supported")
 	// ErrUnsupportedTCPProtocol occurs when trying to use an unsupported TCP protocol.
@@ -41,6 +49,41 @@ var (
 	ErrUnsupportedUDSProtocol = errors.New("only unix is supported")
 	// ErrUnsupportedPlatform occurs when running gnet on an unsupported platform.
 	ErrUnsupportedPlatform = errors.New("unsupported platform in gnet")
+	// ErrRawModeUnhandled occurs when serving with the option RawMode by an event handler without OnReadable.
+	ErrRawModeUnhandled = errors.New("the raw mode requires the event handler to implement RawReader")
+	// ErrUnsupportedOp occurs when calling some methods that are not supported on the current platform or protocol.
+	ErrUnsupportedOp = errors.New("unsupported operation")
+	// ErrFileTransferInProgress occurs when serving a file to a connection which is in the middle of another one.
+	ErrFileTransferInProgress = errors.New("another file transfer is in progress on the connection")
+	// ErrFileTransferAborted occurs when the connection is closed before the file is sent completely.
+	ErrFileTransferAborted = errors.New("file transfer is aborted as the connection is closed")
+	// ErrReadTimeout occurs when nothing is read from a connection until the read deadline.
+	ErrReadTimeout = errors.New("read timeout: no data read from the connection")
+	// ErrWriteTimeout occurs when the outbound data of a connection makes no progress until the write deadline.
+	ErrWriteTimeout = errors.New("write timeout: no progress on the pending outbound data")
+	// ErrInvalidConnState occurs when importing a connection with the state which is not exported by Conn.ExportState.
+	ErrInvalidConnState = errors.New("invalid state of connection")
+	// ErrPeerUnreachable occurs when the probe of a connection finds that its peer is no longer reachable.
+	ErrPeerUnreachable = errors.New("peer of the connection is unreachable")
+	// ErrCloseHandshakeTimeout occurs when the peer doesn't acknowledge the goodbye frame of CloseGracefully in time.
+	ErrCloseHandshakeTimeout = errors.New("close handshake timeout: the goodbye frame is not acknowledged")
+	// ErrUnsupportedCompression occurs when the compression algorithm of a stream is not supported.
+	ErrUnsupportedCompression = errors.New("unsupported compression algorithm")
+	// ErrDecompressionLimit occurs when the data decompressed from a single read of a compressed stream is too large.
+	ErrDecompressionLimit = errors.New("decompressed data exceeds the limit")
+	// ErrConnNotFound occurs when the connection of the given identifier doesn't exist or has been closed.
+	ErrConnNotFound = errors.New("connection not found")
+	// ErrConnRegistryDisabled occurs when looking up a connection by its identifier without the option ConnRegistry.
+	ErrConnRegistryDisabled = errors.New("connection registry is disabled")
+	// ErrConnClosed occurs when writing to a connection that has been closed.
+	ErrConnClosed = errors.New("connection is closed")
+	// ErrInboundRateLimited occurs when a connection is closed for exceeding the limit of the inbound frame rate.
+	ErrInboundRateLimited = errors.New("inbound frames exceed the rate limit")
+	// ErrWriteBufferOverflow occurs when the data written to a connection doesn't fit in MaxOutboundBuffer.
+	ErrWriteBufferOverflow = errors.New("data exceeds the limit of the outbound buffer")
+	// ErrTooManyPendingWrites occurs when calling AsyncWrite on a connection with as many unflushed asynchronous
+	// writes as the limit set by Conn.SetMaxPendingWrites.
+	ErrTooManyPendingWrites = errors.New("too many pending asynchronous writes")
 
 	// ================================================= codec errors =================================================.
 
@@ -56,6 +99,45 @@ var (
 	ErrUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
 	// ErrTooLessLength occurs when adjusted frame length is less than zero.
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrFrameAuthentication occurs when an encrypted frame fails to be authenticated.
+	ErrFrameAuthentication = errors.New("failed to authenticate the encrypted frame")
+	// ErrEncryptedFrameTooLarge occurs when an encrypted frame or the plaintext held for the inner codec exceeds
+	// the limit of size.
+	ErrEncryptedFrameTooLarge = errors.New("encrypted frame exceeds the limit of size")
+	// ErrJSONValueTooLarge occurs when a JSON value of NDJSON exceeds the limit of size.
+	ErrJSONValueTooLarge = errors.New("JSON value exceeds the limit of size")
+	// ErrInboundBufferOverflow occurs when the inbound data buffered without being decoded into frames exceeds the limit.
+	ErrInboundBufferOverflow = errors.New("inbound buffer exceeds the limit without producing frames")
+	// ErrInvalidJSON occurs when the data is not a valid JSON value.
+	ErrInvalidJSON = errors.New("invalid JSON value")
+	// ErrInvalidHTTP3Datagram occurs when an HTTP/3 datagram or the QUIC DATAGRAM frame carrying it is malformed.
+	ErrInvalidHTTP3Datagram = errors.New("malformed HTTP/3 datagram")
+	// ErrHTTP3DatagramStream occurs when an HTTP/3 datagram is not associated with the request stream of the session.
+	ErrHTTP3DatagramStream = errors.New("HTTP/3 datagram is not associated with the request stream of the session")
+	// ErrInvalidDNSMessage occurs when the length of a DNS message is out of the valid range.
+	ErrInvalidDNSMessage = errors.New("invalid length of DNS message")
+	// ErrInvalidSyslogFrame occurs when the octet-counting header of a syslog message is malformed.
+	ErrInvalidSyslogFrame = errors.New("malformed octet-counting header of syslog message")
+	// ErrSyslogMessageTooLarge occurs when a syslog message exceeds the limit of size.
+	ErrSyslogMessageTooLarge = errors.New("syslog message exceeds the limit of size")
+	// ErrInvalidConsumed occurs when the decode function of FuncCodec consumes bytes out of the range of the input.
+	ErrInvalidConsumed = errors.New("consumed bytes out of the range of the input data")
+	// ErrInvalidAMQPProtocolHeader occurs when a connection opens with a protocol header other than AMQP 0-9-1.
+	ErrInvalidAMQPProtocolHeader = errors.New("invalid AMQP protocol header")
+	// ErrInvalidAMQPFrame occurs when an AMQP frame is of an unknown type or isn't terminated by the frame-end octet.
+	ErrInvalidAMQPFrame = errors.New("malformed AMQP frame")
+	// ErrAMQPFrameTooLarge occurs when an AMQP frame exceeds the limit of size.
+	ErrAMQPFrameTooLarge = errors.New("AMQP frame exceeds the limit of size")
+	// ErrInvalidUDPFragment occurs when the header of a UDP fragment is inconsistent with the message.
+	ErrInvalidUDPFragment = errors.New("invalid fragment of UDP message")
+	// ErrUDPMessageTooLarge occurs when a message reassembled from UDP fragments exceeds the limit of size.
+	ErrUDPMessageTooLarge = errors.New("UDP message exceeds the limit of size")
+	// ErrInvalidProtobufVarint occurs when the varint prefixing a Protobuf message is longer than 64 bits.
+	ErrInvalidProtobufVarint = errors.New("malformed varint prefix of Protobuf message")
+	// ErrProtobufMessageTooLarge occurs when a Protobuf message exceeds the limit of size.
+	ErrProtobufMessageTooLarge = errors.New("protobuf message exceeds the limit of size")
+	// ErrUDPReassemblyFull occurs when the incomplete UDP messages being reassembled reach the limit.
+	ErrUDPReassemblyFull = errors.New("too many incomplete UDP messages")
 
 	// =============================================== internal errors ===============================================.
 
@@ -27,6 +27,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"sync/atomic"
 	"time"
@@ -37,6 +38,7 @@ import (
 	gerrors "github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/io"
 	"github.com/panjf2000/gnet/internal/netpoll"
+	"github.com/panjf2000/gnet/internal/socket"
 	"github.com/panjf2000/gnet/logging"
 )
 
@@ -56,14 +58,31 @@ type internalEventloop struct {
 	poller       *netpoll.Poller // epoll or kqueue
 	buffer       []byte          // read packet buffer whose capacity is 64KB
 	connCount    int32           // number of active connections in event-loop
+	staged       int32           // number of accepted sockets on their way to the event-loop
+	draining     int32           // taken out of service for new connections by DrainLoop
 	connections  map[int]*conn   // loop connections fd -> conn
 	eventHandler EventHandler    // user eventHandler
+	rand         *rand.Rand      // pseudo-random number generator, created on demand
+	clock        time.Time       // coarse time refreshed every time the poller returns with events
 }
 
 func (el *eventloop) getLogger() logging.Logger {
 	return el.svr.opts.Logger
 }
 
+// tickClock refreshes the coarse clock of the event-loop, which saves the I/O on the event-loop from getting
+// the time on every read and write.
+func (el *eventloop) tickClock() {
+	el.clock = time.Now()
+}
+
+func (el *eventloop) getRand() *rand.Rand {
+	if el.rand == nil {
+		el.rand = newLoopRand(el.svr.opts.RandSeed, el.idx)
+	}
+	return el.rand
+}
+
 func (el *eventloop) addConn(delta int32) {
 	atomic.AddInt32(&el.connCount, delta)
 }
@@ -72,7 +91,25 @@ func (el *eventloop) loadConn() int32 {
 	return atomic.LoadInt32(&el.connCount)
 }
 
+func (el *eventloop) addStaged(delta int32) {
+	atomic.AddInt32(&el.staged, delta)
+}
+
+// loadStaged returns the number of accepted sockets assigned to the event-loop which haven't been set up yet,
+// they are counted by the Least-Connections algorithm along with the connections.
+func (el *eventloop) loadStaged() int32 {
+	return atomic.LoadInt32(&el.staged)
+}
+
+// backlog returns the number of tasks queued to the event-loop.
+func (el *eventloop) backlog() int {
+	return el.poller.Backlog()
+}
+
 func (el *eventloop) closeAllConns() {
+	if grace := el.svr.opts.ShutdownGrace; grace > 0 {
+		el.drainConns(grace)
+	}
 	// Close loops and all outstanding connections
 	for _, c := range el.connections {
 		_ = el.loopCloseConn(c, nil)
@@ -87,13 +124,38 @@ func (el *eventloop) loopRegister(itf interface{}) error {
 		return nil
 	}
 	el.connections[c.fd] = c
-	return el.loopOpen(c)
+	if err := el.loopOpen(c); err != nil {
+		return err
+	}
+	// The connection was assigned to the event-loop before it was taken out of service.
+	if c.opened && el.outOfService() {
+		return el.poller.Trigger(el.loopDrain, nil)
+	}
+	return nil
 }
 
 func (el *eventloop) loopOpen(c *conn) error {
 	c.opened = true
 	el.addConn(1)
+	el.svr.addConnTotal(1)
+	if el.svr.opts.ConnRegistry {
+		el.svr.conns.Store(c.id, c)
+	}
+	el.svr.logConnOpened(c)
+	if pool := el.svr.opts.WorkerPool; pool != nil {
+		c.async = newAsyncReactor(c, el.eventHandler, pool, &el.svr.poolCounters, el.svr.opts.MaxQueuedFrames, func() {
+			_ = el.poller.Trigger(func(_ interface{}) error { return gerrors.ErrServerShutdown }, nil)
+		})
+	}
 
+	if el.svr.opts.LazyOnOpened {
+		c.pendingOpen = true
+		return nil
+	}
+	return el.notifyOpened(c)
+}
+
+func (el *eventloop) notifyOpened(c *conn) error {
 	out, action := el.eventHandler.OnOpened(c)
 	if out != nil {
 		c.open(out)
@@ -106,23 +168,166 @@ func (el *eventloop) loopOpen(c *conn) error {
 	return el.handleAction(c, action)
 }
 
+// loopEvent fires EventHook.OnEvent for the readiness events of the connection,
+// it reports whether the events are to be handled as usual.
+func (el *eventloop) loopEvent(c *conn, events EventFlags) (proceed bool, err error) {
+	hook, ok := el.eventHandler.(EventHook)
+	if !ok {
+		return true, nil
+	}
+	switch hook.OnEvent(c, events) {
+	case Close:
+		return false, el.loopCloseConn(c, nil)
+	case Shutdown:
+		return false, gerrors.ErrServerShutdown
+	}
+	return c.opened, nil
+}
+
 func (el *eventloop) loopRead(c *conn) error {
 	n, err := unix.Read(c.fd, el.buffer)
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if err == nil {
+			c.closeCause = ClosePeerFIN
+		}
 		return el.loopCloseConn(c, os.NewSyscallError("read", err))
 	}
 	c.buffer = el.buffer[:n]
+	c.addRead(n, el.clock)
+	el.svr.metrics.trackFirstByte(&c.connMetrics)
+
+	if resume := el.svr.opts.ResumeToken; resume != nil && !c.resumeChecked {
+		c.resumeChecked = true
+		if token, ok := resume(c, c.Read()); ok {
+			if target := el.svr.loopOfToken(token); target != el {
+				return el.loopMigrate(c, target)
+			}
+		}
+	}
+
+	if c.decompressor != nil {
+		return el.loopDecompress(c, c.buffer)
+	}
+
+	if err = el.loopReact(c); err != nil {
+		return err
+	}
+	return el.checkBufferBudget(c)
+}
+
+// loopReact fires the events of the connection for the inbound data buffered in it.
+func (el *eventloop) loopReact(c *conn) (err error) {
+	if c.pendingOpen {
+		c.pendingOpen = false
+		if err = el.notifyOpened(c); err != nil || !c.opened {
+			return err
+		}
+	}
+
+	if !c.handshaked {
+		consumed, done, action := handshake(el.eventHandler, c)
+		if consumed > 0 {
+			c.ShiftN(consumed)
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopCloseConn(c, nil)
+		case Shutdown:
+			return gerrors.ErrServerShutdown
+		}
+		c.handshaked = done
+	}
 
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
+	raw := el.svr.opts.RawMode
+	if c.handshaked && raw {
+		switch el.eventHandler.(RawReader).OnReadable(c) {
+		case None:
+		case Close:
+			return el.loopCloseConn(c, nil)
+		case Shutdown:
+			return gerrors.ErrServerShutdown
+		}
+		if !c.opened {
+			return nil
+		}
+	}
+
+	br, batching := el.eventHandler.(BatchReactor)
+	var frames [][]byte
+	for c.handshaked && !raw {
+		if rl := c.rateLimit; rl != nil && c.BufferLength() > 0 {
+			if rl.throttled() {
+				break
+			}
+			if wait := rl.wait(); wait > 0 {
+				if err = el.loopThrottle(c, wait); err != nil || !c.opened {
+					return err
+				}
+				break
+			}
+		}
+		buffered, pooled := c.BufferLength(), !c.inboundBuffer.IsEmpty()
+		inFrame, err := c.read()
+		if err != nil && !isIncompleteFrame(err) {
+			if c.resync() {
+				continue
+			}
+			switch onDecodeError(el.eventHandler, c, err) {
+			case Close:
+				return el.loopCloseConn(c, err)
+			case Shutdown:
+				return gerrors.ErrServerShutdown
+			}
+			// Keep decoding only if the corrupted bytes have been skipped, otherwise wait for more data.
+			if c.BufferLength() < buffered {
+				continue
+			}
+			break
+		}
+		if inFrame == nil {
+			break
+		}
+
+		c.addFrameRead()
+		if c.rateLimit != nil {
+			c.rateLimit.take()
+		}
+		if c.goodbye != nil {
+			if err = el.loopGoodbye(c, inFrame); err != nil || !c.opened {
+				return err
+			}
+			continue
+		}
+		if c.dedicated != nil {
+			if c.dedicated.push(inFrame) {
+				continue
+			}
+			c.dedicated = nil // the dedicated goroutine has drained its frames and exited.
+		}
+		if c.async != nil {
+			if !c.async.push(inFrame) && !c.readPaused {
+				el.pauseRead(c)
+			}
+			continue
+		}
+		if batching {
+			// The frame decoded from the pooled byte buffer might be overwritten by the subsequent decoding.
+			if pooled {
+				inFrame = append([]byte(nil), inFrame...)
+			}
+			frames = append(frames, inFrame)
+			continue
+		}
 		out, action := el.eventHandler.React(inFrame, c)
 		if out != nil {
 			// Encode data and try to write it back to the client, this attempt is based on a fact:
 			// a client socket waits for the response data after sending request data to the server,
 			// which makes the client socket writable.
-			if err = c.write(out); err != nil {
+			if err = el.writeReply(c, out); err != nil {
 				return err
 			}
 		}
@@ -140,12 +345,129 @@ func (el *eventloop) loopRead(c *conn) error {
 			return nil
 		}
 	}
+	if len(frames) > 0 {
+		outs, action := br.ReactBatch(frames, c)
+		for _, out := range outs {
+			if out == nil {
+				continue
+			}
+			if err = el.writeReply(c, out); err != nil {
+				return err
+			}
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopCloseConn(c, nil)
+		case Shutdown:
+			return gerrors.ErrServerShutdown
+		}
+		if !c.opened {
+			return nil
+		}
+	}
+	if max := el.svr.opts.MaxInboundBuffer; max > 0 && c.BufferLength() > max {
+		return el.loopCloseConn(c, gerrors.ErrInboundBufferOverflow)
+	}
+	_, _ = c.inboundBuffer.Write(c.buffer)
+	// The data following the frame which enables the decompression is decompressed now that the frame is done with.
+	if c.decompressor != nil && c.decompressor.holding() {
+		return el.loopDecompress(c, nil)
+	}
+
+	return nil
+}
+
+// loopRedecode decodes the inbound data buffered in the connection after its codec is replaced.
+func (el *eventloop) loopRedecode(c *conn) error {
+	if !c.opened || !c.handshaked || c.inboundBuffer.IsEmpty() || el.svr.opts.RawMode {
+		return nil
+	}
+	c.buffer = el.buffer[:0]
+	return el.loopReact(c)
+}
+
+// loopMigrate hands the connection over to the target event-loop along with the inbound data buffered so far.
+func (el *eventloop) loopMigrate(c *conn, target *eventloop) error {
+	// Delete is a no-op on kqueue as closing the descriptor removes its filters, which isn't the case here.
+	if err := el.poller.Detach(c.pollAttachment); err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	delete(el.connections, c.fd)
+	el.addConn(-1)
+
 	_, _ = c.inboundBuffer.Write(c.buffer)
+	c.buffer = nil
+	c.setOwner(target)
+	if err := target.poller.Trigger(target.loopAdopt, c); err != nil {
+		// The connection stays with this event-loop if the target is unable to take it over.
+		el.getLogger().Warnf("failed to migrate connection %d to event-loop(%d): %v", c.id, target.idx, err)
+		c.setOwner(el)
+		return el.loopAdopt(c)
+	}
+	return nil
+}
+
+// outOfService reports whether the event-loop is parked by ScaleEventLoops or being drained by DrainLoop.
+func (el *eventloop) outOfService() bool {
+	return el.isDraining() || el.idx >= el.svr.lb.active()
+}
 
+// loopDrain migrates the connections of the event-loop taken out of service to the event-loops eligible for new
+// connections in turn.
+func (el *eventloop) loopDrain(_ interface{}) error {
+	var targets []*eventloop
+	active := el.svr.lb.active()
+	el.svr.lb.iterate(func(i int, target *eventloop) bool {
+		if i < active && !target.isDraining() {
+			targets = append(targets, target)
+		}
+		return i+1 < active
+	})
+	if len(targets) == 0 {
+		return nil
+	}
+	// The connections are collected ahead since the ones failing to migrate are put back.
+	conns := make([]*conn, 0, len(el.connections))
+	for _, c := range el.connections {
+		conns = append(conns, c)
+	}
+	for i, c := range conns {
+		if err := el.loopMigrate(c, targets[i%len(targets)]); err != nil {
+			el.getLogger().Warnf("failed to migrate connection %d off the drained event-loop: %v", c.id, err)
+		}
+	}
 	return nil
 }
 
+// loopAdopt takes over the connection migrated from another event-loop.
+func (el *eventloop) loopAdopt(itf interface{}) error {
+	c := itf.(*conn)
+	var err error
+	if !c.writePending() {
+		err = el.poller.AddRead(c.pollAttachment)
+	} else {
+		err = el.poller.AddReadWrite(c.pollAttachment)
+	}
+	el.connections[c.fd] = c
+	el.addConn(1)
+	if err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	if c.readPaused || c.drainClose {
+		_ = el.poller.PauseRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+	}
+
+	c.buffer = el.buffer[:0]
+	return el.loopReact(c)
+}
+
 func (el *eventloop) loopWrite(c *conn) error {
+	// Nothing to flush, the writable event was watched for OnWriteReady.
+	if c.outboundBuffer.IsEmpty() {
+		return el.loopWritten(c)
+	}
+
 	el.eventHandler.PreWrite()
 
 	head, tail := c.outboundBuffer.PeekAll()
@@ -159,6 +481,9 @@ func (el *eventloop) loopWrite(c *conn) error {
 		n, err = unix.Write(c.fd, head)
 	}
 	c.outboundBuffer.Discard(n)
+	c.addWritten(n, el.clock)
+	c.refillOutbound()
+	c.accountBuffers()
 	switch err {
 	case nil, gerrors.ErrShortWritev: // do nothing, just go on
 	case unix.EAGAIN:
@@ -167,10 +492,40 @@ func (el *eventloop) loopWrite(c *conn) error {
 		return el.loopCloseConn(c, os.NewSyscallError("write", err))
 	}
 
+	return el.loopWritten(c)
+}
+
+// loopWritten finishes the writable event of the connection.
+func (el *eventloop) loopWritten(c *conn) error {
+	if len(c.flushTags) > 0 {
+		c.fireFlushed()
+	}
+	if len(c.pendingEnds) > 0 {
+		c.releaseFlushed()
+	}
+
+	if c.transfer != nil {
+		if c.outboundBuffer.IsEmpty() {
+			return el.loopTransfer(c)
+		}
+		return nil
+	}
+
 	// All data have been drained, it's no need to monitor the writable events,
 	// remove the writable event from poller to help the future event-loops.
 	if c.outboundBuffer.IsEmpty() {
-		_ = el.poller.ModRead(c.pollAttachment)
+		if c.drainClose {
+			return el.loopCloseConn(c, nil)
+		}
+		if c.readPaused {
+			_ = el.poller.PauseRead(c.pollAttachment, false)
+		} else {
+			_ = el.poller.ModRead(c.pollAttachment)
+		}
+	}
+
+	if c.onWriteReady != nil {
+		c.onWriteReady(c, c.writeHeadroom())
 	}
 
 	return nil
@@ -180,42 +535,204 @@ func (el *eventloop) loopCloseConn(c *conn, err error) (rerr error) {
 	if !c.opened {
 		return
 	}
+	// SO_ERROR holds the precise cause of an asynchronous error, which the errno of read/write may not reveal.
+	if soErr := socket.GetSocketError(c.fd); soErr != nil && !errors.Is(err, soErr) {
+		if err == nil {
+			err = os.NewSyscallError("getsockopt", soErr)
+		} else {
+			err = fmt.Errorf("%v (SO_ERROR: %w)", err, soErr)
+		}
+	}
+	if err != nil {
+		c.closeCause = closeCauseOf(err)
+	}
 
-	// Send residual data in buffer back to client before actually closing the connection.
-	if !c.outboundBuffer.IsEmpty() {
-		el.eventHandler.PreWrite()
-
-		head, tail := c.outboundBuffer.PeekAll()
-		if n, err := unix.Write(c.fd, head); err == nil {
-			if n == len(head) && tail != nil {
-				_, _ = unix.Write(c.fd, tail)
+	switch c.closeBehavior {
+	case FlushOnClose:
+		// Send residual data in buffer back to client before actually closing the connection.
+		if !c.outboundBuffer.IsEmpty() {
+			el.eventHandler.PreWrite()
+
+			head, tail := c.outboundBuffer.PeekAll()
+			if n, err := unix.Write(c.fd, head); err == nil {
+				if n == len(head) && tail != nil {
+					_, _ = unix.Write(c.fd, tail)
+				}
 			}
 		}
+	case LingerClose:
+		_ = socket.SetLinger(c.fd, 0)
 	}
 
-	if err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd); err0 == nil && err1 == nil {
-		delete(el.connections, c.fd)
-		el.addConn(-1)
+	if c.transfer != nil {
+		_ = el.finishTransfer(c, gerrors.ErrFileTransferAborted)
+	}
 
-		if el.eventHandler.OnClosed(c, err) == Shutdown {
-			return gerrors.ErrServerShutdown
+	// Fire OnClosed ahead of closing the file descriptor, otherwise the descriptor might be reused by a new
+	// connection in another event-loop and its OnOpened could fire before the OnClosed of this one.
+	var action Action
+	if !c.pendingOpen {
+		action = el.eventHandler.OnClosed(c, err)
+	}
+	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
+	if err0 != nil {
+		rerr = fmt.Errorf("failed to delete fd=%d from poller in event-loop(%d): %v", c.fd, el.idx, err0)
+	}
+	if err1 != nil {
+		err1 = fmt.Errorf("failed to close fd=%d in event-loop(%d): %v", c.fd, el.idx, os.NewSyscallError("close", err1))
+		if rerr != nil {
+			rerr = errors.New(rerr.Error() + " & " + err1.Error())
+		} else {
+			rerr = err1
 		}
-		c.releaseTCP()
-	} else {
-		if err0 != nil {
-			rerr = fmt.Errorf("failed to delete fd=%d from poller in event-loop(%d): %v", c.fd, el.idx, err0)
-		}
-		if err1 != nil {
-			err1 = fmt.Errorf("failed to close fd=%d in event-loop(%d): %v", c.fd, el.idx, os.NewSyscallError("close", err1))
-			if rerr != nil {
-				rerr = errors.New(rerr.Error() + " & " + err1.Error())
-			} else {
-				rerr = err1
+	}
+	if rerr != nil {
+		el.svr.reportErr(rerr)
+	}
+
+	// OnClosed has fired, thus the connection is released whether the socket is closed or not: close(2) frees
+	// the descriptor even if it fails, there is nothing to retry and the failure is only reported.
+	c.runCleanups()
+	delete(el.connections, c.fd)
+	el.addConn(-1)
+	if c.readPaused && !c.budgetPaused && (c.rateLimit == nil || !c.rateLimit.paused) {
+		atomic.AddInt64(&el.svr.poolCounters.paused, -1)
+	}
+	atomic.StoreInt32(&c.released, 1)
+	el.svr.sessions.remove(c)
+	if el.svr.opts.ConnRegistry {
+		el.svr.conns.Delete(c.id)
+	}
+	el.svr.metrics.trackClose(&c.connMetrics)
+	el.svr.logConnClosed(c, c.closeCause, err)
+
+	c.releaseTCP()
+	if action == Shutdown || el.svr.addConnTotal(-1) == Shutdown {
+		return gerrors.ErrServerShutdown
+	}
+	return
+}
+
+// loopCloseAfterFlush closes the connection right away if there is nothing to flush, otherwise it stops reading
+// from the connection and leaves the close to loopWritten.
+func (el *eventloop) loopCloseAfterFlush(c *conn) error {
+	if !c.opened {
+		return nil
+	}
+	if c.outboundBuffer.IsEmpty() && c.transfer == nil {
+		return el.loopCloseConn(c, nil)
+	}
+	c.drainClose = true
+	return el.poller.PauseRead(c.pollAttachment, true)
+}
+
+// closeCauseOf classifies the error that causes a connection to be closed.
+func closeCauseOf(err error) CloseCause {
+	if errors.Is(err, unix.ECONNRESET) || errors.Is(err, unix.EPIPE) {
+		return ClosePeerRST
+	}
+	if errors.Is(err, gerrors.ErrReadTimeout) || errors.Is(err, gerrors.ErrWriteTimeout) {
+		return CloseTimeout
+	}
+	return CloseError
+}
+
+// pauseRead stops reading the connection until its frames are handed over to the saturated worker pool,
+// the pool is retried periodically.
+func (el *eventloop) pauseRead(c *conn) {
+	c.readPaused = true
+	atomic.AddInt64(&el.svr.poolCounters.paused, 1)
+	_ = el.poller.PauseRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+	el.retryAsync(c)
+}
+
+func (el *eventloop) retryAsync(c *conn) {
+	time.AfterFunc(asyncRetryInterval, func() {
+		_ = el.poller.Trigger(el.loopResumeRead, c)
+	})
+}
+
+// loopResumeRead resumes reading the connection once its pending frames are taken by the worker pool.
+func (el *eventloop) loopResumeRead(itf interface{}) error {
+	c := itf.(*conn)
+	if owner := c.owner(); owner != el {
+		return owner.poller.Trigger(owner.loopResumeRead, c)
+	}
+	if !c.opened || !c.readPaused {
+		return nil
+	}
+	if c.drainClose {
+		return nil
+	}
+	if !c.async.flush() {
+		el.retryAsync(c)
+		return nil
+	}
+	c.readPaused = false
+	atomic.AddInt64(&el.svr.poolCounters.paused, -1)
+	return el.poller.ResumeRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+}
+
+// housekeep closes the connections that have lived longer than maxAge once their outbound data is flushed and
+// shrinks the buffers of the connections that have been idle for the given duration, a zero duration skips
+// the chore. MaxConnAgeFrame is written to the recycled connections beforehand if any. The task is queued without
+// blocking, thus there is no timeout to give up on.
+func (el *eventloop) housekeep(idle, maxAge, _ time.Duration) {
+	_ = el.poller.Trigger(func(_ interface{}) error {
+		now := time.Now()
+		for _, c := range el.connections {
+			if maxAge > 0 && !c.drainClose && now.Sub(c.acceptedAt) >= maxAge {
+				if err := el.recycleConn(c); err != nil {
+					return err
+				}
+				if !c.opened {
+					continue
+				}
+			}
+			if idle > 0 && now.Sub(c.lastActive) >= idle {
+				c.inboundBuffer.Shrink()
+				c.outboundBuffer.Shrink()
 			}
 		}
+		return nil
+	}, nil)
+}
+
+func (el *eventloop) recycleConn(c *conn) error {
+	if frame := el.svr.opts.MaxConnAgeFrame; len(frame) > 0 {
+		if err := c.writeFrame(frame); err != nil {
+			return err
+		}
+		if !c.opened {
+			return nil
+		}
 	}
+	return el.loopCloseAfterFlush(c)
+}
 
-	return
+// dump takes the snapshot of connections in the event-loop, it gives up if the event-loop doesn't respond in time.
+func (el *eventloop) dump(timeout time.Duration) ([]ConnDump, bool) {
+	ch := make(chan []ConnDump, 1)
+	err := el.poller.Trigger(func(_ interface{}) error {
+		conns := make([]ConnDump, 0, len(el.connections))
+		for _, c := range el.connections {
+			conns = append(conns, c.dump())
+		}
+		ch <- conns
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case conns := <-ch:
+		return conns, true
+	case <-timer.C:
+		return nil, false
+	}
 }
 
 func (el *eventloop) loopWake(c *conn) error {
@@ -225,7 +742,7 @@ func (el *eventloop) loopWake(c *conn) error {
 
 	out, action := el.eventHandler.React(nil, c)
 	if out != nil {
-		if err := c.write(out); err != nil {
+		if err := el.writeReply(c, out); err != nil {
 			return err
 		}
 	}
@@ -233,6 +750,18 @@ func (el *eventloop) loopWake(c *conn) error {
 	return el.handleAction(c, action)
 }
 
+// writeReply writes the data returned by the event handler back to the client, the data rejected due to
+// MaxOutboundBuffer is dropped and the error is reported like a failed AsyncWrite, which leaves the connection
+// as well as the action returned along with the data to the event handler.
+func (el *eventloop) writeReply(c *conn, out []byte) error {
+	err := c.write(out)
+	if errors.Is(err, gerrors.ErrWriteBufferOverflow) {
+		el.svr.reportErr(err)
+		return nil
+	}
+	return err
+}
+
 func (el *eventloop) loopTicker(ctx context.Context) {
 	if el == nil {
 		return
@@ -293,15 +822,21 @@ func (el *eventloop) loopReadUDP(fd int) error {
 	}
 
 	c := newUDPConn(fd, el, sa)
-	out, action := el.eventHandler.React(el.buffer[:n], c)
-	if out != nil {
-		el.eventHandler.PreWrite()
-		_ = c.sendTo(out)
+	var action Action
+	if dc, ok := el.svr.codec.(DatagramCodec); ok {
+		action = reactDatagram(el.eventHandler, dc, c, el.buffer[:n], c.sendTo)
+	} else {
+		var out []byte
+		out, action = el.eventHandler.React(el.buffer[:n], c)
+		if out != nil {
+			el.eventHandler.PreWrite()
+			_ = c.sendTo(out)
+		}
 	}
+	c.releaseUDP()
 	if action == Shutdown {
 		return gerrors.ErrServerShutdown
 	}
-	c.releaseUDP()
 
 	return nil
 }
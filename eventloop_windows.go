@@ -23,8 +23,13 @@ package gnet
 
 import (
 	"context"
+	stderrors "errors"
+	"io"
+	"math/rand"
+	"net"
 	"runtime"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -44,17 +49,27 @@ type eventloop struct {
 //nolint:structcheck
 type internalEventloop struct {
 	ch           chan interface{}      // command channel
+	done         chan struct{}         // closed once the event-loop exits
 	idx          int                   // loop index
 	svr          *server               // server in loop
 	connCount    int32                 // number of active connections in event-loop
+	draining     int32                 // taken out of service for new connections by DrainLoop
 	connections  map[*stdConn]struct{} // track all the sockets bound to this loop
 	eventHandler EventHandler          // user eventHandler
+	rand         *rand.Rand            // pseudo-random number generator, created on demand
 }
 
 func (el *eventloop) getLogger() logging.Logger {
 	return el.svr.opts.Logger
 }
 
+func (el *eventloop) getRand() *rand.Rand {
+	if el.rand == nil {
+		el.rand = newLoopRand(el.svr.opts.RandSeed, el.idx)
+	}
+	return el.rand
+}
+
 func (el *eventloop) addConn(delta int32) {
 	atomic.AddInt32(&el.connCount, delta)
 }
@@ -63,6 +78,27 @@ func (el *eventloop) loadConn() int32 {
 	return atomic.LoadInt32(&el.connCount)
 }
 
+// loadStaged returns 0 since the accepted connections are set up right away on Windows.
+func (el *eventloop) loadStaged() int32 {
+	return 0
+}
+
+// backlog returns the number of tasks queued to the event-loop.
+func (el *eventloop) backlog() int {
+	return len(el.ch)
+}
+
+// trigger hands the task over to the event-loop from outside of it, it gives up and reports false if the event-loop
+// has exited, in which case no one would ever take the task.
+func (el *eventloop) trigger(task interface{}) bool {
+	select {
+	case el.ch <- task:
+		return true
+	case <-el.done:
+		return false
+	}
+}
+
 func (el *eventloop) loopRun(lockOSThread bool) {
 	if lockOSThread {
 		runtime.LockOSThread()
@@ -74,6 +110,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 		el.svr.signalShutdownWithErr(err)
 		el.svr.loopWG.Done()
 		el.loopEgress()
+		close(el.done)
 		el.svr.loopWG.Done()
 	}()
 
@@ -103,6 +140,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 			break
 		} else if err != nil {
 			el.getLogger().Errorf("event-loop(%d) is exiting due to the error: %v", el.idx, err)
+			el.svr.reportErr(err)
 		}
 	}
 }
@@ -110,23 +148,160 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 func (el *eventloop) loopAccept(c *stdConn) error {
 	el.connections[c] = struct{}{}
 	el.addConn(1)
+	el.svr.addConnTotal(1)
+	if el.svr.opts.ConnRegistry {
+		el.svr.conns.Store(c.id, c)
+	}
+	el.svr.logConnOpened(c)
+
+	if el.svr.opts.LazyOnOpened {
+		c.pendingOpen = true
+		return nil
+	}
+	return el.notifyOpened(c)
+}
 
+func (el *eventloop) notifyOpened(c *stdConn) error {
 	out, action := el.eventHandler.OnOpened(c)
 	if out != nil {
 		el.eventHandler.PreWrite()
-		_, _ = c.conn.Write(out)
+		_, _ = c.write(out)
 	}
 
 	return el.handleAction(c, action)
 }
 
 func (el *eventloop) loopRead(c *stdConn) error {
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
+	c.addRead(c.buffer.Len(), time.Now())
+	el.svr.metrics.trackFirstByte(&c.connMetrics)
+	if c.decompressor != nil {
+		data := c.buffer.Bytes()
+		defer bytebuffer.Put(c.buffer)
+		c.buffer = nil
+		return el.loopDecompress(c, data)
+	}
+	return el.loopReact(c)
+}
+
+// loopReact fires the events of the connection for the inbound data buffered in it.
+func (el *eventloop) loopReact(c *stdConn) error {
+	if c.pendingOpen {
+		c.pendingOpen = false
+		if err := el.notifyOpened(c); err != nil {
+			return err
+		}
+	}
+	if !c.handshaked {
+		consumed, done, action := handshake(el.eventHandler, c)
+		if consumed > 0 {
+			c.ShiftN(consumed)
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopCloseConn(c)
+		case Shutdown:
+			return errors.ErrServerShutdown
+		}
+		c.handshaked = done
+	}
+
+	raw := el.svr.opts.RawMode
+	if c.handshaked && raw {
+		switch el.eventHandler.(RawReader).OnReadable(c) {
+		case None:
+		case Close:
+			return el.loopCloseConn(c)
+		case Shutdown:
+			return errors.ErrServerShutdown
+		}
+	}
+
+	br, batching := el.eventHandler.(BatchReactor)
+	var frames [][]byte
+	for c.handshaked && !raw {
+		if rl := c.rateLimit; rl != nil && c.BufferLength() > 0 {
+			if rl.throttled() {
+				break
+			}
+			if wait := rl.wait(); wait > 0 {
+				if err := el.loopThrottle(c, wait); err != nil || c.conn == nil {
+					return err
+				}
+				break
+			}
+		}
+		buffered, pooled := c.BufferLength(), !c.inboundBuffer.IsEmpty()
+		inFrame, err := c.read()
+		if err != nil && !isIncompleteFrame(err) {
+			if c.resync() {
+				continue
+			}
+			switch onDecodeError(el.eventHandler, c, err) {
+			case Close:
+				return el.loopCloseConn(c)
+			case Shutdown:
+				return errors.ErrServerShutdown
+			}
+			// Keep decoding only if the corrupted bytes have been skipped, otherwise wait for more data.
+			if c.BufferLength() < buffered {
+				continue
+			}
+			break
+		}
+		if inFrame == nil {
+			break
+		}
+
+		c.addFrameRead()
+		if c.rateLimit != nil {
+			c.rateLimit.take()
+		}
+		if c.goodbye != nil {
+			if err := el.loopGoodbye(c, inFrame); err != nil || c.closing {
+				return err
+			}
+			continue
+		}
+		if c.dedicated != nil {
+			if c.dedicated.push(inFrame) {
+				continue
+			}
+			c.dedicated = nil // the dedicated goroutine has drained its frames and exited.
+		}
+		if batching {
+			// The frame decoded from the pooled byte buffer might be overwritten by the subsequent decoding.
+			if pooled {
+				inFrame = append([]byte(nil), inFrame...)
+			}
+			frames = append(frames, inFrame)
+			continue
+		}
 		out, action := el.eventHandler.React(inFrame, c)
 		if out != nil {
 			outFrame, _ := c.codec.Encode(c, out)
 			el.eventHandler.PreWrite()
-			if _, err := c.conn.Write(outFrame); err != nil {
+			if _, err := c.writeFrame(outFrame); err != nil {
+				return el.loopError(c, err)
+			}
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopCloseConn(c)
+		case Shutdown:
+			return errors.ErrServerShutdown
+		}
+	}
+	if len(frames) > 0 {
+		outs, action := br.ReactBatch(frames, c)
+		for _, out := range outs {
+			if out == nil {
+				continue
+			}
+			outFrame, _ := c.codec.Encode(c, out)
+			el.eventHandler.PreWrite()
+			if _, err := c.writeFrame(outFrame); err != nil {
 				return el.loopError(c, err)
 			}
 		}
@@ -138,20 +313,67 @@ func (el *eventloop) loopRead(c *stdConn) error {
 			return errors.ErrServerShutdown
 		}
 	}
+	if max := el.svr.opts.MaxInboundBuffer; max > 0 && c.BufferLength() > max {
+		return el.loopError(c, errors.ErrInboundBufferOverflow)
+	}
 	_, _ = c.inboundBuffer.Write(c.buffer.Bytes())
 	bytebuffer.Put(c.buffer)
 	c.buffer = nil
+	// The data following the frame which enables the decompression is decompressed now that the frame is done with.
+	if c.decompressor != nil && c.decompressor.holding() {
+		return el.loopDecompress(c, nil)
+	}
 
 	return nil
 }
 
+// loopRedecode decodes the inbound data buffered in the connection after its codec is replaced.
+func (el *eventloop) loopRedecode(c *stdConn) error {
+	if _, ok := el.connections[c]; !ok || !c.handshaked || c.inboundBuffer.IsEmpty() || el.svr.opts.RawMode {
+		return nil
+	}
+	c.buffer = bytebuffer.Get()
+	return el.loopReact(c)
+}
+
+// loopPeerUnreachable fires OnPeerUnreachable for the connection which fails the probe.
+func (el *eventloop) loopPeerUnreachable(c *stdConn, err error) error {
+	if _, ok := el.connections[c]; !ok {
+		return nil
+	}
+	switch onPeerUnreachable(el.eventHandler, c, err) {
+	case Close:
+		return el.loopCloseConn(c)
+	case Shutdown:
+		return errors.ErrServerShutdown
+	}
+	return nil
+}
+
 func (el *eventloop) loopCloseConn(c *stdConn) error {
+	c.closing = true
 	if c.conn != nil {
-		return c.conn.SetReadDeadline(time.Now())
+		return c.setReadDeadline(time.Now(), 0)
 	}
 	return nil
 }
 
+// drainConns writes ShutdownFrame to the connections of the event-loop that is shutting down within the grace period,
+// the data is written to the sockets directly, thus there is nothing else to flush.
+func (el *eventloop) drainConns() {
+	grace, frame := el.svr.opts.ShutdownGrace, el.svr.opts.ShutdownFrame
+	if grace <= 0 || frame == nil {
+		return
+	}
+	deadline := time.Now().Add(grace)
+	for c := range el.connections {
+		if c.conn != nil && !c.closing {
+			_ = c.conn.SetWriteDeadline(deadline)
+			_, _ = c.writeFrame(frame)
+		}
+	}
+}
+
 func (el *eventloop) loopEgress() {
 	var closed bool
 	for v := range el.ch {
@@ -159,6 +381,7 @@ func (el *eventloop) loopEgress() {
 		case error:
 			if v == errCloseAllConns {
 				closed = true
+				el.drainConns()
 				for c := range el.connections {
 					_ = el.loopCloseConn(c)
 				}
@@ -207,30 +430,126 @@ func (el *eventloop) loopTicker(ctx context.Context) {
 }
 
 func (el *eventloop) loopError(c *stdConn, err error) (e error) {
+	if _, ok := el.connections[c]; !ok {
+		return // ignore stale wakes, e.g. the read error of the connection closed by the event-loop.
+	}
+	// A timeout that isn't caused by loopCloseConn comes from the read deadline.
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && !c.closing {
+		err = errors.ErrReadTimeout
+	}
 	defer func() {
-		if _, ok := el.connections[c]; !ok {
-			return // ignore stale wakes.
-		}
+		el.svr.logConnClosed(c, c.closeCause, err)
 
+		// Data is written to the socket synchronously on Windows, thus there is nothing to flush or discard.
+		if tc, ok := c.conn.(*net.TCPConn); ok && c.closeBehavior == LingerClose {
+			_ = tc.SetLinger(0)
+		}
 		if err = c.conn.Close(); err != nil {
 			el.getLogger().Errorf("failed to close connection(%s), error: %v", c.remoteAddr.String(), err)
+			el.svr.reportErr(err)
 			if e == nil {
 				e = err
 			}
 		}
 		delete(el.connections, c)
 		el.addConn(-1)
+		atomic.StoreInt32(&c.released, 1)
+		el.svr.sessions.remove(c)
+		if el.svr.opts.ConnRegistry {
+			el.svr.conns.Delete(c.id)
+		}
+		el.svr.metrics.trackClose(&c.connMetrics)
 
+		c.runCleanups()
 		c.releaseTCP()
+		if el.svr.addConnTotal(-1) == Shutdown && e == nil {
+			e = errors.ErrServerShutdown
+		}
 	}()
 
-	if el.eventHandler.OnClosed(c, err) == Shutdown {
+	switch {
+	case c.closing:
+		c.closeCause = CloseLocal
+	case err == io.EOF:
+		c.closeCause = ClosePeerFIN
+	case isConnReset(err):
+		c.closeCause = ClosePeerRST
+	case stderrors.Is(err, errors.ErrReadTimeout) || stderrors.Is(err, errors.ErrWriteTimeout):
+		c.closeCause = CloseTimeout
+	default:
+		c.closeCause = CloseError
+	}
+	if c.transfer != nil {
+		el.finishTransfer(c, c.transfer, 0, errors.ErrFileTransferAborted)
+	}
+	if !c.pendingOpen && el.eventHandler.OnClosed(c, err) == Shutdown {
 		return errors.ErrServerShutdown
 	}
 
 	return
 }
 
+func isConnReset(err error) bool {
+	var errno syscall.Errno
+	return stderrors.As(err, &errno) && errno == syscall.WSAECONNRESET
+}
+
+// housekeep closes the connections that have lived longer than maxAge and shrinks the buffers of the connections
+// that have been idle for the given duration, a zero duration skips the chore. MaxConnAgeFrame is written to
+// the recycled connections beforehand if any. It gives up if the event-loop doesn't take the task within timeout.
+func (el *eventloop) housekeep(idle, maxAge, timeout time.Duration) {
+	task := &signalTask{run: func(_ *stdConn) error {
+		now := time.Now()
+		for c := range el.connections {
+			if maxAge > 0 && !c.closing && now.Sub(c.acceptedAt) >= maxAge {
+				if frame := el.svr.opts.MaxConnAgeFrame; len(frame) > 0 {
+					_, _ = c.writeFrame(frame)
+				}
+				_ = el.loopCloseConn(c)
+				continue
+			}
+			if idle > 0 && now.Sub(c.lastActive) >= idle {
+				c.inboundBuffer.Shrink()
+			}
+		}
+		return nil
+	}}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	// Give up if the event-loop is too busy or has exited.
+	select {
+	case el.ch <- task:
+	case <-timer.C:
+	}
+}
+
+// dump takes the snapshot of connections in the event-loop, it gives up if the event-loop doesn't respond in time.
+func (el *eventloop) dump(timeout time.Duration) ([]ConnDump, bool) {
+	ch := make(chan []ConnDump, 1)
+	task := &signalTask{run: func(_ *stdConn) error {
+		conns := make([]ConnDump, 0, len(el.connections))
+		for c := range el.connections {
+			conns = append(conns, c.dump())
+		}
+		ch <- conns
+		return nil
+	}}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case el.ch <- task:
+	case <-timer.C:
+		return nil, false
+	}
+	select {
+	case conns := <-ch:
+		return conns, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
 func (el *eventloop) loopWake(c *stdConn) error {
 	if _, ok := el.connections[c]; !ok {
 		return nil // ignore stale wakes.
@@ -240,7 +559,7 @@ func (el *eventloop) loopWake(c *stdConn) error {
 	if out != nil {
 		if frame, err := c.codec.Encode(c, out); err != nil {
 			return err
-		} else if _, err = c.conn.Write(frame); err != nil {
+		} else if _, err = c.writeFrame(frame); err != nil {
 			return err
 		}
 	}
@@ -262,15 +581,29 @@ func (el *eventloop) handleAction(c *stdConn, action Action) error {
 }
 
 func (el *eventloop) loopReadUDP(c *stdConn) error {
-	out, action := el.eventHandler.React(c.buffer.Bytes(), c)
-	if out != nil {
-		el.eventHandler.PreWrite()
-		_, _ = el.svr.ln.pconn.WriteTo(out, c.remoteAddr)
+	frame := c.buffer.Bytes()
+	if frame == nil {
+		// Zero-length datagram, deliver it as an empty frame rather than no data.
+		frame = []byte{}
+	}
+	var action Action
+	if dc, ok := el.svr.codec.(DatagramCodec); ok {
+		action = reactDatagram(el.eventHandler, dc, c, frame, func(out []byte) error {
+			_, err := el.svr.ln.pconn.WriteTo(out, c.remoteAddr)
+			return err
+		})
+	} else {
+		var out []byte
+		out, action = el.eventHandler.React(frame, c)
+		if out != nil {
+			el.eventHandler.PreWrite()
+			_, _ = el.svr.ln.pconn.WriteTo(out, c.remoteAddr)
+		}
 	}
+	c.releaseUDP()
 	if action == Shutdown {
 		return errors.ErrServerShutdown
 	}
-	c.releaseUDP()
 
 	return nil
 }
@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdMonitorInterval is the interval of sampling the number of open file descriptors while accepting is paused.
+const fdMonitorInterval = time.Second
+
+// initFdLimit samples the RLIMIT_NOFILE soft limit, the file descriptors are only checked against the threshold
+// if it succeeds.
+func (svr *server) initFdLimit() {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		svr.opts.Logger.Errorf("failed to get RLIMIT_NOFILE: %v", os.NewSyscallError("getrlimit", err))
+		return
+	}
+	svr.fdLimit = clampLimit(uint64(rlim.Cur))
+}
+
+func (svr *server) overFdThreshold(used int) bool {
+	return float64(used) >= svr.opts.FdLimitThreshold*float64(svr.fdLimit)
+}
+
+// checkFdLimit sheds load if the descriptor of the connection just accepted reaches the threshold, accept(2)
+// returns the lowest descriptor available, thus all the ones below it are in use, which makes it a cheap lower
+// bound of the usage without counting the open file descriptors.
+func (svr *server) checkFdLimit(fd int) {
+	if svr.fdLimit > 0 && svr.overFdThreshold(fd+1) {
+		svr.nearFdLimit()
+	}
+}
+
+// shedLoad pauses accepting new connections if the error returned by accept(2) indicates that
+// the file descriptors are exhausted and the housekeeping is enabled to resume it later.
+func (svr *server) shedLoad(err error) bool {
+	if svr.opts.FdLimitThreshold <= 0 || (err != unix.EMFILE && err != unix.ENFILE) {
+		return false
+	}
+	svr.nearFdLimit()
+	return true
+}
+
+// nearFdLimit pauses accepting new connections and fires OnNearFdLimit once the threshold is crossed, the callback
+// fires after the listeners are actually unwatched by the event-loops rather than as soon as it is requested,
+// since the connections arriving in the meantime are still accepted.
+func (svr *server) nearFdLimit() {
+	if !atomic.CompareAndSwapInt32(&svr.acceptPaused, 0, 1) {
+		return
+	}
+	svr.watchListeners(false, func() {
+		used, limit, err := fdUsage()
+		if err != nil {
+			used, limit = svr.fdLimit, svr.fdLimit
+		}
+		svr.opts.Logger.Warnf("%d of %d file descriptors are in use, stopped accepting new connections", used, limit)
+		if h, ok := svr.eventHandler.(FdLimitHandler); ok {
+			h.OnNearFdLimit(used, limit)
+		}
+	})
+}
+
+// relieveFdLimit samples the usage of file descriptors while accepting is paused by the threshold.
+func (svr *server) relieveFdLimit() {
+	if atomic.LoadInt32(&svr.acceptPaused) == 1 {
+		svr.sampleFdUsage()
+	}
+}
+
+// sampleFdUsage counts the open file descriptors, which sheds load if the usage is over the threshold and resumes
+// accepting otherwise.
+func (svr *server) sampleFdUsage() {
+	if svr.fdLimit == 0 {
+		return
+	}
+	used, _, err := fdUsage()
+	if err != nil {
+		svr.opts.Logger.Errorf("failed to sample the usage of file descriptors: %v", err)
+		return
+	}
+	if svr.overFdThreshold(used) {
+		svr.nearFdLimit()
+	} else if atomic.CompareAndSwapInt32(&svr.acceptPaused, 1, 0) {
+		svr.watchListeners(true, nil)
+	}
+}
+
+// watchListeners has the event-loops accepting connections watch or unwatch their listeners, done runs once all of
+// them are done with it if it isn't nil.
+func (svr *server) watchListeners(watch bool, done func()) {
+	// The extra count held until all the toggles are queued keeps done from running ahead of the last toggle.
+	pending := int32(1)
+	finish := func() {
+		if atomic.AddInt32(&pending, -1) == 0 && done != nil {
+			done()
+		}
+	}
+	toggle := func(el *eventloop, ln *listener) {
+		atomic.AddInt32(&pending, 1)
+		err := el.poller.Trigger(func(_ interface{}) (err error) {
+			if watch {
+				err = el.poller.AddRead(ln.pollAttachment)
+			} else {
+				err = el.poller.DeleteRead(ln.pollAttachment)
+			}
+			finish()
+			return
+		}, nil)
+		if err != nil {
+			finish()
+		}
+	}
+	if svr.mainLoop != nil {
+		toggle(svr.mainLoop, svr.ln)
+	} else {
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			toggle(el, el.ln)
+			return true
+		})
+	}
+	finish()
+}
+
+// fdUsage returns the number of open file descriptors of the process and the RLIMIT_NOFILE soft limit.
+func fdUsage() (used, limit int, err error) {
+	var rlim unix.Rlimit
+	if err = unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		return
+	}
+	limit = clampLimit(uint64(rlim.Cur))
+
+	dir := "/dev/fd"
+	if runtime.GOOS == "linux" {
+		dir = "/proc/self/fd"
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	// Exclude the file descriptor of the directory itself.
+	used = len(names) - 1
+	return
+}
+
+func clampLimit(cur uint64) int {
+	if cur > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(cur)
+}
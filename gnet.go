@@ -23,9 +23,13 @@ package gnet
 
 import (
 	"context"
+	"math/rand"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet/errors"
@@ -47,6 +51,77 @@ const (
 	Shutdown
 )
 
+// CloseBehavior determines what to do with the data pending in the outbound buffer when a connection is closed.
+type CloseBehavior int
+
+const (
+	// FlushOnClose makes a best-effort attempt to send the pending data before closing the connection,
+	// it is the default behavior.
+	FlushOnClose CloseBehavior = iota
+
+	// DiscardOnClose discards the pending data and closes the connection right away.
+	DiscardOnClose
+
+	// LingerClose discards the pending data and aborts the connection with SO_LINGER set to 0, which also drops
+	// the data in the kernel send buffer and sends a RST instead of a FIN to the peer.
+	LingerClose
+)
+
+// CloseCause tells why a connection is closed, it is available via Conn.CloseCause in OnClosed.
+type CloseCause int
+
+const (
+	// CloseLocal indicates that the connection is closed by the server, e.g. Conn.Close or the Close action.
+	CloseLocal CloseCause = iota
+
+	// ClosePeerFIN indicates that the peer closed the connection gracefully by sending a FIN.
+	ClosePeerFIN
+
+	// ClosePeerRST indicates that the peer aborted the connection by sending a RST, e.g. the peer crashed.
+	ClosePeerRST
+
+	// CloseError indicates that the connection is closed due to an error other than the ones above,
+	// which is passed to OnClosed.
+	CloseError
+
+	// CloseTimeout indicates that the connection is closed because it exceeded its read or write deadline,
+	// i.e. it went idle or its outbound data stalled, the errors.ErrReadTimeout or errors.ErrWriteTimeout
+	// is passed to OnClosed.
+	CloseTimeout
+)
+
+// String returns the name of the cause.
+func (cc CloseCause) String() string {
+	switch cc {
+	case CloseLocal:
+		return "local"
+	case ClosePeerFIN:
+		return "peer-fin"
+	case ClosePeerRST:
+		return "peer-rst"
+	case CloseError:
+		return "error"
+	case CloseTimeout:
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// EventFlags is the set of readiness events of a connection reported by epoll or kqueue, see EventHook.
+type EventFlags uint8
+
+const (
+	// EventRead indicates that the connection is readable, i.e. EPOLLIN/EPOLLPRI on Linux and EVFILT_READ on BSD's.
+	EventRead EventFlags = 1 << iota
+
+	// EventWrite indicates that the connection is writable, i.e. EPOLLOUT on Linux and EVFILT_WRITE on BSD's.
+	EventWrite
+
+	// EventError indicates an error or a hang-up on the connection, i.e. EPOLLERR/EPOLLHUP/EPOLLRDHUP on Linux
+	// and EV_EOF on BSD's.
+	EventError
+)
+
 // Server represents a server context which provides information about the
 // running server and has control functions for managing state.
 type Server struct {
@@ -72,6 +147,35 @@ type Server struct {
 	TCPKeepAlive time.Duration
 }
 
+// ServerController is the set of methods of Server, which is meant for unit-testing the handlers depending on
+// the server: a handler that keeps the Server passed to OnInitComplete as a ServerController can be given
+// a fake implementation in tests instead of a running server.
+type ServerController interface {
+	CountConnections() (count int)
+	CountEventLoops() int
+	ScaleEventLoops(n int) error
+	Healthy() bool
+	ServeHealth(ln net.Listener) error
+	DroppedErrors() uint64
+	WorkerPoolStats() WorkerPoolStats
+	ReplaceConn(identity string, c Conn) (old Conn, existed bool)
+	Histograms() (firstByte, connAge Histogram)
+	Dump() ServerDump
+	DupFd() (dupFD int, err error)
+	ImportConn(fd int, state []byte, restore func(c Conn, appState []byte)) error
+	AcceptQueueLen() (int, error)
+	LabelStats(key string) map[string]Stats
+	BufferMemory() int64
+	WriteTo(connID uint64, data []byte) error
+	LoopBacklog() []int
+	DrainLoop(ctx context.Context, index int) error
+	Options() Options
+	SetLoadBalancing(lb LoadBalancing) error
+	SetLoadBalancer(lb LoadBalancer) error
+}
+
+var _ ServerController = Server{}
+
 // CountConnections counts the number of currently active connections and returns it.
 func (s Server) CountConnections() (count int) {
 	s.svr.lb.iterate(func(i int, el *eventloop) bool {
@@ -81,6 +185,69 @@ func (s Server) CountConnections() (count int) {
 	return
 }
 
+// CountEventLoops returns the number of event-loops eligible for new connections.
+func (s Server) CountEventLoops() int {
+	return s.svr.lb.active()
+}
+
+// ScaleEventLoops adjusts the number of event-loops eligible for new connections at runtime without restarting
+// the server, which is meant for elastic scaling, only the main reactor mode of TCP servers supports it for now,
+// it returns errors.ErrUnsupportedOp on UDP servers, servers with ReusePort but not DedicatedAcceptLoop and
+// on Windows.
+//
+// Scaling up spins up additional sub-reactors and scaling down parks the last ones, no connection is dropped: the
+// parked event-loops stop receiving new connections and their existing connections are migrated to the remaining
+// event-loops right after, along with the data buffered in them, as Conn.MigrateToLoop does. The parked
+// event-loops, left empty, are put back into service first by subsequent scale-ups rather than being torn down
+// before the server shuts down. Note that scaling changes the distribution of new connections, in
+// particular, SourceAddrHash maps the same remote address to a different event-loop after the number changes.
+func (s Server) ScaleEventLoops(n int) error {
+	return s.svr.scaleEventLoops(n)
+}
+
+// Healthy reports whether the server is bound to its listener and accepting connections,
+// it turns false as soon as the server starts shutting down, so that load balancers can route traffic away.
+func (s Server) Healthy() bool {
+	return s.svr.isServing()
+}
+
+// ServeHealth serves HTTP health probes on the given side listener, e.g. for readiness probes of Kubernetes,
+// it responds 200 when the server is healthy and 503 otherwise. ServeHealth blocks until ln is closed.
+func (s Server) ServeHealth(ln net.Listener) error {
+	return http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if s.Healthy() {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("unavailable"))
+	}))
+}
+
+// DroppedErrors returns the number of background errors dropped due to the full ErrChan.
+func (s Server) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&s.svr.errDropped)
+}
+
+// WorkerPoolStats returns the statistics of the worker pool set up by WithWorkerPool.
+func (s Server) WorkerPoolStats() WorkerPoolStats {
+	return s.svr.poolCounters.snapshot(s.svr.opts.WorkerPool)
+}
+
+// ReplaceConn registers c as the only active connection of the client identity, which is provided by the application,
+// the connection previously registered under the identity is closed and returned, it is meant for protocols where
+// a client can have only one session at a time. Connections are unregistered once they are closed.
+func (s Server) ReplaceConn(identity string, c Conn) (old Conn, existed bool) {
+	return s.svr.sessions.replace(identity, c)
+}
+
+// Histograms returns the histograms of the latency from accepting TCP connections to their first inbound bytes and
+// of how long TCP connections live, the latter only counts the closed connections.
+func (s Server) Histograms() (firstByte, connAge Histogram) {
+	return s.svr.metrics.firstByte.snapshot(), s.svr.metrics.connAge.snapshot()
+}
+
 // DupFd returns a copy of the underlying file descriptor of listener.
 // It is the caller's responsibility to close dupFD when finished.
 // Closing listener does not affect dupFD, and closing dupFD does not affect listener.
@@ -92,14 +259,147 @@ func (s Server) DupFd() (dupFD int, err error) {
 	return
 }
 
+// ImportConn takes over the TCP connection of fd, which is typically received from another process by SCM_RIGHTS
+// along with the state exported by Conn.ExportState in that process. The inbound and outbound data buffered in the
+// exported connection are restored, parameter:restore, if not nil, is invoked with the application state on the
+// event-loop of the connection before OnOpened, e.g. for unmarshaling it by Conn.SetContext, and the restored
+// inbound data is decoded right after OnOpened. The server owns fd once it returns nil, otherwise fd is left to
+// the caller. It is only supported on unix platforms.
+func (s Server) ImportConn(fd int, state []byte, restore func(c Conn, appState []byte)) error {
+	return s.svr.importConn(fd, state, restore)
+}
+
+// AcceptQueueLen returns the number of established connections waiting in the kernel accept queue of the listener,
+// which keeps growing when the server can't keep up with the rate of new connections. It is only supported by
+// TCP servers on Linux, FreeBSD and DragonFly BSD, and it only covers the first listener with ReusePort.
+func (s Server) AcceptQueueLen() (int, error) {
+	return s.svr.ln.acceptQueueLen()
+}
+
+// LabelStats returns the traffic aggregated by each value of the given label key across all connections
+// that carry it, see Conn.SetLabels. The byte and frame counters are cumulative over the server lifetime,
+// while the number of connections reflects those currently carrying the label.
+func (s Server) LabelStats(key string) map[string]Stats {
+	return s.svr.metrics.labelStats(key)
+}
+
+// BufferMemory returns the number of bytes buffered in the inbound and outbound buffers across all TCP connections,
+// it is only accounted with the option MaxTotalBufferMemory and it is always 0 on Windows.
+func (s Server) BufferMemory() int64 {
+	return atomic.LoadInt64(&s.svr.bufferMemory)
+}
+
+// WriteTo writes data to the TCP connection of the identifier returned by Conn.ID like Conn.AsyncWrite, the write is
+// submitted to the event-loop owning the connection. It is safe to call from any goroutine, which saves keeping
+// the connections in long-lived maps for the sake of pushing data to them. It requires the option ConnRegistry and
+// returns errors.ErrConnRegistryDisabled without it, or errors.ErrConnNotFound if the connection doesn't exist or
+// has been closed.
+func (s Server) WriteTo(connID uint64, data []byte) error {
+	if !s.svr.opts.ConnRegistry {
+		return errors.ErrConnRegistryDisabled
+	}
+	c, ok := s.svr.conns.Load(connID)
+	if !ok {
+		return errors.ErrConnNotFound
+	}
+	return c.(Conn).AsyncWrite(data)
+}
+
+// LoopBacklog returns the number of tasks waiting to run in the queue of each event-loop handling connections, in the
+// order of their indexes, e.g. the asynchronous writes and wakes, a backlog that keeps growing indicates that
+// the event-loop goroutine is saturated. It counts the tasks in the channel of every event-loop on Windows.
+func (s Server) LoopBacklog() []int {
+	backlog := make([]int, 0, s.svr.lb.len())
+	s.svr.lb.iterate(func(i int, el *eventloop) bool {
+		backlog = append(backlog, el.backlog())
+		return true
+	})
+	return backlog
+}
+
+// DrainLoop takes the event-loop of the given index out of service for maintenance: it stops receiving new
+// connections and its existing connections are migrated to the other event-loops eligible for new connections
+// with their buffered data, then DrainLoop returns once the event-loop serves no connection. If ctx is done
+// before that, the connections left on the event-loop are closed and ctx.Err() is returned. The drained
+// event-loop is not removed since the indexes of the others would shift, it stays idle and out of service until
+// the server shuts down instead, and the other event-loops are not affected except for taking over the
+// connections. To remove the event-loops at the end, use ScaleEventLoops instead. It returns
+// errors.ErrLastEventLoop if no other event-loop is eligible for new connections, and errors.ErrUnsupportedOp
+// where ScaleEventLoops isn't supported either.
+func (s Server) DrainLoop(ctx context.Context, index int) error {
+	return s.svr.drainLoop(ctx, index)
+}
+
+// Options returns the options which the server runs with, resolved with the defaults applied, e.g. NumEventLoop is
+// the number of event-loops started by the server, ReadBufferCap is rounded up to a power of two, Codec is the codec
+// in use and ReusePort reports whether the event-loops accept connections on listeners of their own with
+// SO_REUSEPORT, which is false with DedicatedAcceptLoop and on Windows. The returned Options is a copy, modifying it
+// has no effect on the server.
+func (s Server) Options() Options {
+	opts := *s.svr.opts
+	opts.NumEventLoop = s.NumEventLoop
+	opts.ReusePort = s.svr.reusePort()
+	opts.Codec = s.svr.codec
+	opts.LB = s.svr.lb.(*switchableLoadBalancer).algorithm()
+	return opts
+}
+
+// SetLoadBalancing switches the load-balancing algorithm of the server at runtime, e.g. from RoundRobin to
+// LeastConnections for adapting to the workload. The change is not retroactive: it only affects the assignments
+// of the connections accepted afterwards, the existing connections stay on their event-loops. It returns
+// errors.ErrInvalidLoadBalancing if the algorithm is unknown.
+func (s Server) SetLoadBalancing(lb LoadBalancing) error {
+	if !s.svr.lb.(*switchableLoadBalancer).use(lb) {
+		return errors.ErrInvalidLoadBalancing
+	}
+	return nil
+}
+
+// SetLoadBalancer switches the server to the given custom load-balancing algorithm at runtime, Options().LB reports
+// CustomLoadBalancing from then on until SetLoadBalancing switches back to one of the built-in algorithms. Like
+// SetLoadBalancing, the change is not retroactive. It returns errors.ErrInvalidLoadBalancing if lb is nil.
+func (s Server) SetLoadBalancer(lb LoadBalancer) error {
+	if lb == nil {
+		return errors.ErrInvalidLoadBalancing
+	}
+	s.svr.lb.(*switchableLoadBalancer).useCustom(lb)
+	return nil
+}
+
 // Conn is a interface of gnet connection.
 type Conn interface {
+	// ID returns the identifier of the connection which is unique among the TCP connections in the current process,
+	// it is 0 for UDP sockets.
+	ID() (id uint64)
+
 	// Context returns a user-defined context.
 	Context() (ctx interface{})
 
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
+	// SetLabels attaches labels (e.g. tenant, region, protocol variant) to the connection, replacing any labels
+	// that were set before, the traffic of the connection is aggregated by each of its labels from then on.
+	// The labels of a UDP connection are released along with it once its datagram is reacted to.
+	// It is not concurrency-safe, you ought to call it within the event callbacks.
+	SetLabels(labels map[string]string)
+
+	// Labels returns the labels attached to the connection.
+	Labels() (labels map[string]string)
+
+	// Stats returns the bytes and frames read from and written to the connection since it was opened or since the
+	// last ResetStats, the Connections field is always 0. It is safe to be called from any goroutine.
+	Stats() Stats
+
+	// ResetStats zeroes the byte and frame counters of the connection and returns their values before the reset,
+	// thus the traffic of each reporting interval is obtained without tracking the previous values. Every counter
+	// is swapped atomically, so no traffic is lost between reading and zeroing it.
+	ResetStats() Stats
+
+	// OpenedAt returns the time when the TCP connection was accepted, which is meant for age-based policies like
+	// evicting long-lived connections, it is the zero time for UDP sockets.
+	OpenedAt() time.Time
+
 	// LocalAddr is the connection's local socket address.
 	LocalAddr() (addr net.Addr)
 
@@ -124,24 +424,241 @@ type Conn interface {
 	// ShiftN shifts "read" pointer in the internal buffers with the given length.
 	ShiftN(n int) (size int)
 
-	// BufferLength returns the length of available data in the internal buffers.
+	// BufferLength returns the number of inbound bytes available in the internal buffers, i.e. the data read from
+	// the socket that hasn't been consumed by the codec or discarded by ShiftN yet, including the data of the
+	// current read. It is safe to call in React and the other event callbacks as well as in Decode of codecs,
+	// e.g. for deciding whether there is enough data to attempt decoding a frame.
 	BufferLength() (size int)
 
+	// OutboundLength returns the number of bytes written to the connection that are not yet written to the socket,
+	// including the data held back by MaxOutboundBuffer, a batch or an ongoing ServeFile but not the file itself.
+	// It is safe to call in React and the other event callbacks. It is always 0 on Windows where the data is written
+	// to the socket directly.
+	OutboundLength() (size int)
+
+	// OutboundBuffered returns the number of bytes queued in the outbound buffer waiting to be written to the socket,
+	// which lets producers apply backpressure, it is a cheap read that must be called from the event-loop goroutine,
+	// i.e. in the event callbacks. Unlike OutboundLength, it doesn't count the data held back elsewhere. It is always
+	// 0 on Windows where the data is written to the socket directly.
+	OutboundBuffered() int
+
+	// Write encodes data with the codec and writes it to the socket synchronously, the data that the socket can't
+	// take right away is queued in the outbound buffer like the data returned by React. It returns the number of
+	// encoded bytes written to the socket immediately, which doesn't count the data queued ahead, and whether all
+	// of the outbound data has been flushed to the socket, false indicates backpressure from the peer or the network,
+	// so that latency-sensitive code is able to detect congestion inline and adapt its batching. It must be called
+	// within event callbacks, use AsyncWrite anywhere else. It returns errors.ErrConnClosed if the connection is
+	// closed or gets closed by a failure of the write, or errors.ErrWriteBufferOverflow if the data is rejected for
+	// not fitting in MaxOutboundBuffer.
+	Write(data []byte) (n int, flushed bool, err error)
+
 	// InboundBuffer returns the inbound ring-buffer.
 	// InboundBuffer() *ringbuffer.RingBuffer
 
+	// OnWriteReady sets up the callback fired in the event-loop when the socket becomes writable, with the free
+	// space in bytes of the socket send buffer less the data pending in the outbound buffer, so that producers can
+	// size their next batch by how congested the connection is. It fires once after being set up and then every time
+	// the pending outbound data is flushed on writable events, passing nil removes it. It must be called within
+	// event callbacks and is not supported on Windows.
+	OnWriteReady(fn func(c Conn, freeSpace int))
+
 	// SendTo writes data for UDP sockets, it allows you to send data back to UDP socket in individual goroutines.
 	SendTo(buf []byte) error
 
 	// AsyncWrite writes data to client/connection asynchronously, usually you would call it in individual goroutines
-	// instead of the event-loop goroutines.
+	// instead of the event-loop goroutines. A write that doesn't fit in MaxOutboundBuffer is rejected or streamed
+	// as specified by OutboundOverflow, the rejection is reported to ErrChan.
 	AsyncWrite(buf []byte) error
 
+	// SetMaxPendingWrites limits the number of AsyncWrites of the connection that are issued but not yet flushed,
+	// i.e. queued for the event-loop or with data left in the outbound buffer, AsyncWrite returns
+	// errors.ErrTooManyPendingWrites once the limit is reached, which protects the memory from handlers producing
+	// faster than the peer consumes and signals them to slow down. It is goroutine-safe and 0 means unlimited,
+	// which is the default.
+	SetMaxPendingWrites(n int)
+
+	// AsyncWriteRaw writes data to the peer asynchronously like AsyncWrite, but without running it through
+	// the Encode of codec, it is meant for passing frames that are encoded already through verbatim,
+	// e.g. relaying frames from another connection in a proxy, so that they won't be framed twice.
+	AsyncWriteRaw(data []byte) error
+
+	// AsyncWriteTagged writes data to client/connection asynchronously like AsyncWrite and fires OnWriteFlushed
+	// of FlushHandler with the tag once all of the data has been written to the socket, which makes it possible
+	// to correlate application-level acknowledgements with the writes or to keep a window of the unflushed data.
+	// The tags are flushed in the order of writing, those still pending are dropped when the connection is closed.
+	AsyncWriteTagged(data []byte, tag uint64) error
+
+	// Probe checks whether the peer of the TCP connection is still reachable, which helps reap the half-open
+	// connections that TCP keepalive is too slow to catch: it makes a zero-byte write, checks the pending socket
+	// error and the state of the connection reported by TCP_INFO on Linux, and then writes the frame set by
+	// the option ProbeFrame if any, for the peer to acknowledge at application level. It returns the error that
+	// reveals the peer is unreachable, in which case OnPeerUnreachable of UnreachableHandler fires after the current
+	// event callback, or the connection is closed if the event handler doesn't implement it. It must be called
+	// within event callbacks, e.g. in React fired by Wake. On Windows, it only writes ProbeFrame.
+	Probe() error
+
+	// SetCodec replaces the codec of the TCP connection mid-stream, e.g. after a protocol upgrade, nil restores
+	// the codec of the server. The inbound data buffered at the time of the swap is decoded by the new codec:
+	// when it is called in React, the rest of the data read along with the current frame is decoded right after
+	// React returns, otherwise the data buffered is decoded in the event-loop right after the current callback.
+	// Note that the frames already handed over to ReactBatch, the dedicated goroutine or the worker pool have been
+	// decoded by the previous codec. It must be called within event callbacks.
+	SetCodec(codec ICodec)
+
+	// EnableStreamDecompression inserts a streaming decompressor in front of the codec for the protocols that switch
+	// the inbound stream to compression after negotiation, so that the codec decodes the decompressed bytes. It is
+	// meant to be called in React for the frame concluding the negotiation, the data buffered after that frame and
+	// read afterwards is decompressed on the event-loop and decoded in order. The connection is closed with the error
+	// of the decompressor passed to OnClosed once the stream turns out to be corrupted or ends, io.EOF for the end of
+	// a zlib or DEFLATE stream, or with errors.ErrDecompressionLimit if more than 4MB is decompressed from the data
+	// of a single read.
+	// It returns errors.ErrUnsupportedCompression for an unknown algorithm, and does nothing if already enabled.
+	// The option MaxInboundBuffer is recommended to bound the decompressed data buffered without producing frames.
+	EnableStreamDecompression(algo Compression) error
+
+	// SetInboundRateLimit limits the frames decoded from the TCP connection to framesPerSecond on average with bursts
+	// of up to burst frames, which protects the server from abusive clients. Once the connection runs out of tokens,
+	// OnRateLimited of RateLimitHandler fires and the data buffered is held without being decoded until the next
+	// token is available, in the meantime reading the connection is paused, which pushes back on the peer by TCP
+	// flow control. A partial frame buffered is held as well. Non-positive framesPerSecond removes the limit.
+	// It must be called within event callbacks. On Windows, the data keeps being read and buffered in the meantime.
+	SetInboundRateLimit(framesPerSecond, burst int)
+
+	// SetReadDeadline sets up the deadline for reading the connection, the connection is closed with
+	// errors.ErrReadTimeout if nothing has been read from it since the deadline was set when t comes. A connection
+	// that keeps sending data is not closed, the deadline is pushed back by the same duration instead, which makes
+	// it an idle timeout of the connection, and so is it while reading the connection is paused by the server. The
+	// deadline is cleared by a zero t, and it takes no goroutine per connection while pending. It must be called
+	// within event callbacks.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets up the deadline for the pending outbound data, the connection is closed with
+	// errors.ErrWriteTimeout if there is still data pending at t and none of it has been written since the deadline
+	// was set. A connection that is slow but keeps making progress is not closed, the deadline is pushed back by
+	// the same duration instead, and the deadline is cleared once it passes with nothing pending, or by a zero t.
+	// It must be called within event callbacks. On Windows, it maps to the write deadline of net.Conn.
+	SetWriteDeadline(t time.Time) error
+
+	// ServeFile sends length bytes of the file at path starting from offset to the connection, or up to the end of
+	// the file if length is negative, using sendfile(2) without copying the file into user space. The file is sent
+	// after the data pending in the outbound buffer and as fast as the peer receives it, the rest of the file is
+	// sent on writable events rather than blocking the event-loop, and the data written to the connection in the
+	// meantime is held back until the file is sent. Parameter:done fires on the event-loop once the file is sent
+	// or the transfer fails, e.g. errors.ErrFileTransferAborted if the connection is closed before that. Only one
+	// file can be served at a time and it must be called within event callbacks.
+	ServeFile(path string, offset, length int64, done func(c Conn, err error)) error
+
+	// ExportState serializes the data buffered in the connection, both inbound and outbound, along with the state of
+	// the application, which is the context of the connection if it implements encoding.BinaryMarshaler, so that
+	// the connection can be taken over by Server.ImportConn in another process with its file descriptor from DupFd.
+	// The connection is supposed to be closed with DiscardOnClose right after exporting, it must be called within
+	// event callbacks and is only supported by TCP and Unix connections on unix platforms.
+	ExportState() ([]byte, error)
+
+	// DupFd returns a copy of the file descriptor of the connection, e.g. for handing it over to another process
+	// by SCM_RIGHTS, it is the caller's responsibility to close it. It is only supported on unix platforms.
+	DupFd() (int, error)
+
+	// Forward writes a copy of the frame to the connection to by AsyncWrite, the frame is encoded by the codec
+	// of to, which is the way to relay frames in a proxy. The frames forwarded by the same connection are written to
+	// to in the order they are forwarded, regardless of the event-loops of the two connections, and the frame can be
+	// the one passed to React since it is copied before returning.
+	Forward(to Conn, frame []byte) error
+
+	// Cork holds back partial frames in the kernel so that the data written afterwards is coalesced into full
+	// TCP segments until Uncork is called, which is useful when a response is built from multiple writes, like
+	// an HTTP header followed by its body. It maps to TCP_CORK on Linux and TCP_NOPUSH on BSD's.
+	Cork() error
+
+	// Uncork releases the data held back by Cork and sends it out immediately.
+	Uncork() error
+
+	// BeginBatch starts a batch of writes: the data written to the connection afterwards, either by AsyncWrite or
+	// within the event callbacks, is held back in order until EndBatch, which sends all of it with as few syscalls
+	// as possible, so that a logical batch of frames goes out at once rather than frame by frame. Both of them are
+	// goroutine-safe and take effect in the order they are called along with AsyncWrite, calling BeginBatch during
+	// a batch or EndBatch without one does nothing. Called within an event callback, the batch includes the data
+	// returned by the callback. The data of an unfinished batch is discarded when the connection is closed.
+	BeginBatch() error
+
+	// EndBatch ends the batch started by BeginBatch and sends the data written during it.
+	EndBatch() error
+
+	// CongestionControl returns the name of the TCP congestion control algorithm in use on the connection,
+	// e.g. "cubic", it maps to TCP_CONGESTION on Linux and returns errors.ErrUnsupportedOp on non-TCP connections
+	// or other platforms.
+	CongestionControl() (string, error)
+
+	// SetCongestionControl selects the TCP congestion control algorithm of the connection, e.g. "bbr" for
+	// latency-sensitive connections, the algorithm must be available in the kernel. It maps to TCP_CONGESTION on
+	// Linux and returns errors.ErrUnsupportedOp on non-TCP connections or other platforms.
+	SetCongestionControl(algo string) error
+
+	// SetCloseBehavior sets up the way the connection treats the pending outbound data when it is closed by Close,
+	// by returning Close from event callbacks or on errors, FlushOnClose is used if it is never called.
+	// It is recommended to call it in event callbacks, e.g. switching to DiscardOnClose when the server is
+	// overloaded in order to reclaim the memory of outbound buffers quickly.
+	SetCloseBehavior(behavior CloseBehavior)
+
+	// Logger returns a logger derived from the logger of server, which tags every message with the identifier and
+	// the remote address of the connection, so that logs of a connection can be correlated across async work.
+	Logger() (logger logging.Logger)
+
+	// SetDedicatedGoroutine sets up whether React for the frames of this connection runs on a dedicated goroutine
+	// instead of the shared event-loop goroutine, so that a handler with deep stacks or blocking calls doesn't grow
+	// the stack of the event-loop goroutine or stall other connections bound to it.
+	// The frames are copied and reacted to in order, parameter:out of React is sent by AsyncWrite, thus it costs
+	// an extra goroutine, a copy of every frame and a round trip to the event-loop for every response, which is
+	// slower than the shared event-loop for light handlers. Only goroutine-safe methods like AsyncWrite, Wake and
+	// Close should be called on the connection in React when it is enabled. Once it is disabled, the dedicated
+	// goroutine exits after reacting to the frames handed over to it, the event-loop reacts to the following frames
+	// from then on, thus no frame is dropped and the frames are still reacted to in order.
+	SetDedicatedGoroutine(dedicated bool)
+
+	// MigrateToLoop moves the connection to the event-loop of the given index, which lets handlers co-locate related
+	// connections on the same event-loop for sharing state without locks. The migration takes place right after
+	// the current event callback, along with the inbound and outbound data buffered in the connection, the following
+	// events of the connection fire in the new event-loop and the tasks issued by AsyncWrite, Wake and Close follow
+	// the connection. It must be called within event callbacks and is only supported by TCP and Unix connections
+	// on unix platforms.
+	MigrateToLoop(index int) error
+
 	// Wake triggers a React event for this connection.
 	Wake() error
 
-	// Close closes the current connection.
+	// Rand returns the pseudo-random number generator of the event-loop that the connection belongs to, which saves
+	// data-generating handlers from contending for the lock of the global source in math/rand. It is seeded from
+	// Options.RandSeed and the index of the event-loop. It isn't goroutine-safe and must be called within
+	// event callbacks.
+	Rand() *rand.Rand
+
+	// OnCleanup registers fn to run exactly once when the connection is torn down, after OnClosed and the closing
+	// of the socket, including the connections closed by the shutdown of the server, so that the resources held
+	// by the connection such as DB handles and subscriptions are always released. The callbacks run in the reverse
+	// order of their registration. A UDP connection is torn down once its datagram is reacted to. It is not
+	// concurrency-safe, you ought to call it within the event callbacks.
+	OnCleanup(fn func())
+
+	// CloseCause returns why the connection is closed, it is meant to be called in OnClosed,
+	// so that a clean close by the peer can be told apart from an abortion or a local close.
+	CloseCause() CloseCause
+
+	// Close closes the current connection, the pending outbound data is handled as specified by SetCloseBehavior.
 	Close() error
+
+	// CloseGracefully performs the close handshake required by protocols like WebSocket and AMQP: it writes the
+	// goodbye frame to the connection like AsyncWrite, and then closes the connection once a frame decoded from
+	// the peer satisfies ackMatcher, or with errors.ErrCloseHandshakeTimeout passed to OnClosed if none does within
+	// the timeout. The frames decoded in the meantime are passed to ackMatcher instead of React, a nil ackMatcher
+	// takes any frame as the acknowledgement. The frames are not decoded with the option RawMode, in which the
+	// connection is closed by the timeout unless the peer closes it first.
+	CloseGracefully(goodbye []byte, ackMatcher func(frame []byte) bool, timeout time.Duration) error
+
+	// CloseAfterFlush stops reading from the connection and closes it once all the pending outbound data,
+	// including the data issued by AsyncWrite ahead of it, has been written to the socket, which keeps the final
+	// response from being truncated in request/response protocols. Unlike Close, it waits for the socket to become
+	// writable as long as it takes instead of making a best-effort attempt.
+	CloseAfterFlush() error
 }
 
 type (
@@ -163,10 +680,20 @@ type (
 		// It is generally not recommended to send large amounts of data back to the client in OnOpened.
 		//
 		// Note that the bytes returned by OnOpened will be sent back to client without being encoded.
+		//
+		// The first React of a connection never fires before its OnOpened returns, so parameter:out is always
+		// written ahead of any response to the inbound data, even for pipelining clients that send requests
+		// right after connecting without waiting for the greeting.
+		//
+		// With the option LazyOnOpened, OnOpened fires when the first inbound data arrives, the data can be
+		// inspected by c.Read() and parameter:out is sent back to the client ahead of any response from React,
+		// thus it is not suitable for server-first protocols where the client waits for a greeting.
 		OnOpened(c Conn) (out []byte, action Action)
 
 		// OnClosed fires when a connection has been closed.
 		// The parameter:err is the last known connection error.
+		// OnClosed always fires before the underlying file descriptor is released, thus it is guaranteed to be
+		// delivered ahead of the OnOpened of any new connection that reuses the same file descriptor.
 		OnClosed(c Conn, err error) (action Action)
 
 		// PreWrite fires just before any data is written to any client socket, this event function is usually used to
@@ -175,7 +702,11 @@ type (
 
 		// React fires when a connection sends the server data.
 		// Call c.Read() or c.ReadN(n) within the parameter:c to read incoming data from client.
-		// Parameter:out is the return value which is going to be sent back to the client.
+		// Parameter:out is the return value which is going to be sent back to the client, it is dropped and
+		// errors.ErrWriteBufferOverflow is published to Options.ErrChan if it is rejected due to MaxOutboundBuffer,
+		// parameter:action is taken all the same.
+		// For UDP, every datagram fires React once, including zero-length datagrams which arrive as
+		// a non-nil frame of length 0 with c.RemoteAddr() set to the peer.
 		React(frame []byte, c Conn) (out []byte, action Action)
 
 		// Tick fires immediately after the server starts and will fire again
@@ -183,6 +714,101 @@ type (
 		Tick() (delay time.Duration, action Action)
 	}
 
+	// BatchReactor is an optional interface that can be implemented by an EventHandler to process all the frames
+	// decoded from a single read at once instead of calling React for every frame, which amortizes the cost of
+	// dispatching events for connections with high message rates.
+	BatchReactor interface {
+		// ReactBatch fires with all frames decoded from the inbound data of a connection in place of React.
+		// Each of the non-nil parameter:outs will be encoded and sent back to the client in order, the ones rejected
+		// due to MaxOutboundBuffer are dropped as the out of React.
+		// The frames are only valid until ReactBatch returns, copy them if you need to retain them.
+		ReactBatch(frames [][]byte, c Conn) (outs [][]byte, action Action)
+	}
+
+	// IdleHandler is an optional interface that can be implemented by an EventHandler to get notified when
+	// the server runs out of TCP connections, which saves counting the connections for shutting down the server
+	// once no clients remain. The connections are only counted when it's implemented.
+	IdleHandler interface {
+		// OnAllConnectionsClosed fires on the event-loop of the last connection right after its OnClosed,
+		// when the number of connections drops to zero, returning Shutdown shuts the server down.
+		OnAllConnectionsClosed() (action Action)
+	}
+
+	// EventHook is an optional interface that can be implemented by an EventHandler to observe the raw readiness
+	// events of the connections, which are what the event-loops dispatch on to read and write the connections.
+	EventHook interface {
+		// OnEvent fires on every readiness event of a connection before the event-loop handles it. Returning None
+		// lets the event-loop handle the event as usual, Close closes the connection instead and Shutdown shuts
+		// the server down. Note that OnEvent fires on the hot path of the event-loops, it must not block and
+		// it is only supported on unix platforms.
+		OnEvent(c Conn, events EventFlags) (action Action)
+	}
+
+	// DecodeErrorHandler is an optional interface that can be implemented by an EventHandler to handle the errors of
+	// decoding frames, without it the inbound data is left intact and the connection waits for more data.
+	DecodeErrorHandler interface {
+		// OnDecodeError fires when the codec fails to decode a frame from the inbound data of a connection,
+		// which gives you a chance to log the error or send an error frame back to the client before deciding
+		// what to do next: returning Close closes the connection and the error will be passed to OnClosed,
+		// returning None keeps the connection alive with the inbound data left intact, so you may discard the
+		// corrupted bytes by c.ShiftN(n) to resynchronize the stream, decoding goes on if any bytes were discarded.
+		OnDecodeError(c Conn, err error) (action Action)
+	}
+
+	// Handshaker is an optional interface that can be implemented by an EventHandler to run a handshake with
+	// the inbound data of every connection before the codec engages.
+	Handshaker interface {
+		// OnHandshake fires with the inbound data of a connection before the codec engages, it is called repeatedly
+		// as new data arrives until it returns done as true, after which the remaining data will be decoded by the
+		// codec and passed to React. Parameter:consumed is the number of bytes handled by the handshake,
+		// which will be discarded from the inbound buffer.
+		OnHandshake(c Conn, data []byte) (consumed int, done bool, action Action)
+	}
+
+	// RawReader is an optional interface that must be implemented by an EventHandler serving with the option RawMode.
+	RawReader interface {
+		// OnReadable fires instead of decoding frames and React when new data of a TCP connection arrives with
+		// the option RawMode, the inbound data is left for you to handle by c.Read, c.ReadN and c.ShiftN,
+		// the data that isn't discarded stays in the inbound buffer and shows up again in the next OnReadable.
+		OnReadable(c Conn) (action Action)
+	}
+
+	// FlushHandler is an optional interface that can be implemented by an EventHandler to get notified when
+	// the data written by c.AsyncWriteTagged is flushed.
+	FlushHandler interface {
+		// OnWriteFlushed fires when the data written by c.AsyncWriteTagged with the tag has left the outbound buffer
+		// and been written to the socket entirely, it won't fire if the connection is closed before that.
+		OnWriteFlushed(c Conn, tag uint64)
+	}
+
+	// UnreachableHandler is an optional interface that can be implemented by an EventHandler to decide what to do
+	// with the connections whose peers are found unreachable by c.Probe, which are closed without it.
+	UnreachableHandler interface {
+		// OnPeerUnreachable fires when c.Probe finds that the peer of the connection is no longer reachable,
+		// the parameter:err reveals why, returning Close closes the connection with err passed to OnClosed.
+		OnPeerUnreachable(c Conn, err error) (action Action)
+	}
+
+	// RateLimitHandler is an optional interface that can be implemented by an EventHandler to get notified when
+	// the connections run out of the tokens of c.SetInboundRateLimit.
+	RateLimitHandler interface {
+		// OnRateLimited fires when the connection runs out of the tokens of c.SetInboundRateLimit, right before
+		// the decoding is held, returning Close closes the repeat offenders with errors.ErrInboundRateLimited passed
+		// to OnClosed.
+		OnRateLimited(c Conn) (action Action)
+	}
+
+	// FdLimitHandler is an optional interface that can be implemented by an EventHandler to get notified when
+	// the server sheds load by the option FdLimitThreshold.
+	FdLimitHandler interface {
+		// OnNearFdLimit fires when the number of open file descriptors of the process reaches the threshold set by
+		// the option FdLimitThreshold of the RLIMIT_NOFILE soft limit, after which the server stops accepting new
+		// connections until the usage drops below the threshold. It fires once every time the threshold is crossed,
+		// after the listeners have actually stopped being watched, from the event-loop or the main reactor that
+		// stops watching its listener last, the connections arriving before that are still accepted.
+		OnNearFdLimit(used, limit int)
+	}
+
 	// EventServer is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
@@ -220,7 +846,11 @@ func (es *EventServer) PreWrite() {
 
 // React fires when a connection sends the server data.
 // Call c.Read() or c.ReadN(n) within the parameter:c to read incoming data from client.
-// Parameter:out is the return value which is going to be sent back to the client.
+// Parameter:out is the return value which is going to be sent back to the client, it is dropped and
+// errors.ErrWriteBufferOverflow is published to Options.ErrChan if it is rejected due to MaxOutboundBuffer,
+// parameter:action is taken all the same.
+// For UDP, every datagram fires React once, including zero-length datagrams which arrive as
+// a non-nil frame of length 0 with c.RemoteAddr() set to the peer.
 func (es *EventServer) React(frame []byte, c Conn) (out []byte, action Action) {
 	return
 }
@@ -235,6 +865,8 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 //
 // Address should use a scheme prefix and be formatted
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
+// Binding to port 0, like `tcp://:0`, picks an ephemeral port, which is resolved in Server.Addr passed to
+// OnInitComplete and is also how the server is addressed by gnet.Stop, e.g. `tcp://:51234`.
 // Valid network schemes:
 //  tcp   - bind to both IPv4 and IPv6
 //  tcp4  - IPv4
@@ -245,7 +877,44 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 //  unix  - Unix Domain Socket
 //
 // The "tcp" network scheme is assumed when one is not specified.
-func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err error) {
+func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) error {
+	run, err := bind(eventHandler, protoAddr, opts...)
+	if err != nil {
+		return err
+	}
+	return run(nil)
+}
+
+// ServeAsync is the non-blocking counterpart of Serve, it separates starting the server from running it:
+// the errors of binding the address or starting the event-loops, e.g. an address in use, are returned right away,
+// otherwise the server is up and registered for gnet.Stop by the time ServeAsync returns, it keeps running in
+// the background and the returned channel receives the error that Serve would return once the server shuts down,
+// which is nil for a graceful shutdown.
+func ServeAsync(eventHandler EventHandler, protoAddr string, opts ...Option) (<-chan error, error) {
+	run, err := bind(eventHandler, protoAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- run(func() { close(started) })
+	}()
+	select {
+	case <-started:
+	case err = <-done:
+		if err != nil {
+			return nil, err
+		}
+		// The server was shut down by OnInitComplete before it started.
+		done <- nil
+	}
+	return done, nil
+}
+
+// bind sets up the options and binds the listener of the server, it returns the function running the server
+// which blocks until the server shuts down, started is called once the server is up if it is not nil.
+func bind(eventHandler EventHandler, protoAddr string, opts ...Option) (run func(started func()) error, err error) {
 	options := loadOptions(opts...)
 
 	logging.Debugf("default logging level is %s", logging.LogLevel())
@@ -264,19 +933,26 @@ func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err err
 	if options.Logger == nil {
 		options.Logger = logger
 	}
-	defer func() {
+	cleanup := func() {
 		if flush != nil {
 			_ = flush()
 		}
 		logging.Cleanup()
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
 	}()
 
-	// The maximum number of operating system threads that the Go program can use is initially set to 10000,
-	// which should also be the maximum amount of I/O event-loops locked to OS threads that users can start up.
-	if options.LockOSThread && options.NumEventLoop > 10000 {
-		logging.Errorf("too many event-loops under LockOSThread mode, should be less than 10,000 "+
-			"while you are trying to set up %d\n", options.NumEventLoop)
-		return errors.ErrTooManyEventLoopThreads
+	if err = options.validate(); err != nil {
+		logging.Errorf("invalid options: %v\n", err)
+		return
+	}
+	if _, ok := eventHandler.(RawReader); options.RawMode && !ok {
+		err = errors.ErrRawModeUnhandled
+		logging.Errorf("invalid options: %v\n", err)
+		return
 	}
 
 	if rbc := options.ReadBufferCap; rbc <= 0 {
@@ -284,6 +960,9 @@ func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err err
 	} else {
 		options.ReadBufferCap = internal.CeilToPowerOfTwo(rbc)
 	}
+	if options.UDPMaxDatagramSize <= 0 {
+		options.UDPMaxDatagramSize = options.ReadBufferCap
+	}
 
 	network, addr := parseProtoAddr(protoAddr)
 
@@ -291,9 +970,17 @@ func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err err
 	if ln, err = initListener(network, addr, options); err != nil {
 		return
 	}
-	defer ln.close()
+	// Register the server with the port picked by the kernel or from the port range in place of the port 0,
+	// which tells apart the servers bound to ephemeral ports when stopping them by gnet.Stop.
+	if ln.addr != addr {
+		protoAddr = network + "://" + ln.addr
+	}
 
-	return serve(eventHandler, ln, options, protoAddr)
+	return func(started func()) error {
+		defer cleanup()
+		defer ln.close()
+		return serve(eventHandler, ln, options, protoAddr, started)
+	}, nil
 }
 
 var (
@@ -303,6 +990,46 @@ var (
 	shutdownPollInterval = 500 * time.Millisecond
 )
 
+// resolvePort replaces the ephemeral port 0 in addr with the port that the listener is actually bound to.
+func resolvePort(addr string, lnaddr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (port != "" && port != "0") {
+		return addr
+	}
+	switch a := lnaddr.(type) {
+	case *net.TCPAddr:
+		return net.JoinHostPort(host, strconv.Itoa(a.Port))
+	case *net.UDPAddr:
+		return net.JoinHostPort(host, strconv.Itoa(a.Port))
+	}
+	return addr
+}
+
+// newLoopRand returns the pseudo-random number generator of the event-loop of the given index.
+func newLoopRand(seed int64, idx int) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed + int64(idx)))
+}
+
+// bindInPortRange binds the address with port 0 to the first free port within [min, max], trying from a random port
+// in the range, the address is bound as it is if it has a specified port or the range is invalid.
+func bindInPortRange(addr string, min, max int, bind func(addr string) error) (err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (port != "" && port != "0") || min <= 0 || max < min || max > 65535 {
+		return bind(addr)
+	}
+	n := max - min + 1
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		if err = bind(net.JoinHostPort(host, strconv.Itoa(min+(start+i)%n))); err == nil {
+			return
+		}
+	}
+	return
+}
+
 // Stop gracefully shuts down the server without interrupting any active event-loops,
 // it waits indefinitely for connections and event-loops to be closed and then shuts down.
 func Stop(ctx context.Context, protoAddr string) error {
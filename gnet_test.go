@@ -23,16 +23,28 @@ package gnet
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"net/http"
+	"os"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/panjf2000/ants/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -709,12 +721,12 @@ func TestShutdown(t *testing.T) {
 }
 
 type testShutdownServer struct {
+	clients int64 // updated atomically, keep it first for the alignment on 32-bit platforms
 	*EventServer
 	tester  *testing.T
 	network string
 	addr    string
 	count   int
-	clients int64
 	N       int
 }
 
@@ -1030,6 +1042,13 @@ func TestServerOptionsCheck(t *testing.T) {
 	assert.EqualError(t, err, errors.ErrTooManyEventLoopThreads.Error(), "error returned with LockOSThread option")
 }
 
+func TestValidateOptions(t *testing.T) {
+	err := ValidateOptions(WithNumEventLoop(10001), WithLockOSThread(true))
+	assert.EqualError(t, err, errors.ErrTooManyEventLoopThreads.Error(), "error returned with LockOSThread option")
+	assert.NoError(t, ValidateOptions(WithNumEventLoop(10001)))
+	assert.NoError(t, ValidateOptions(WithNumEventLoop(10000), WithLockOSThread(true)))
+}
+
 func TestStop(t *testing.T) {
 	testStop(t, "tcp", ":9997")
 }
@@ -1158,3 +1177,4746 @@ func (tes *testClosedWakeUpServer) OnClosed(c Conn, err error) (action Action) {
 	}
 	return
 }
+
+func TestConnLabels(t *testing.T) {
+	testConnLabels(t, "tcp", ":9771")
+}
+
+type testConnLabelsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	opened        int32
+	closed        int32
+	N             int
+}
+
+func (t *testConnLabelsServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	for i := 0; i < t.N; i++ {
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			data := []byte("Hello World!")
+			_, err = conn.Write(data)
+			require.NoError(t.tester, err)
+			_, err = io.ReadFull(conn, data)
+			require.NoError(t.tester, err)
+		}()
+	}
+	return
+}
+
+func (t *testConnLabelsServer) OnOpened(c Conn) (out []byte, action Action) {
+	tenant := "even"
+	if atomic.AddInt32(&t.opened, 1)%2 == 1 {
+		tenant = "odd"
+	}
+	c.SetLabels(map[string]string{"tenant": tenant, "region": "local"})
+	return
+}
+
+func (t *testConnLabelsServer) OnClosed(c Conn, err error) (action Action) {
+	assert.Len(t.tester, c.Labels(), 2)
+	if int(atomic.AddInt32(&t.closed, 1)) == t.N {
+		action = Shutdown
+	}
+	return
+}
+
+func (t *testConnLabelsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testConnLabels(t *testing.T, network, addr string) {
+	events := &testConnLabelsServer{tester: t, network: network, addr: addr, N: 4}
+	err := Serve(events, network+"://"+addr, WithMulticore(true))
+	assert.NoError(t, err)
+
+	tenants := events.svr.LabelStats("tenant")
+	require.Len(t, tenants, 2)
+	for _, tenant := range []string{"odd", "even"} {
+		stats := tenants[tenant]
+		assert.EqualValues(t, 0, stats.Connections)
+		assert.EqualValues(t, 2*len("Hello World!"), stats.BytesRead)
+		assert.EqualValues(t, 2*len("Hello World!"), stats.BytesWritten)
+		assert.EqualValues(t, 2, stats.FramesRead)
+		assert.EqualValues(t, 2, stats.FramesWritten)
+	}
+	regions := events.svr.LabelStats("region")
+	assert.EqualValues(t, 4*len("Hello World!"), regions["local"].BytesRead)
+	assert.Empty(t, events.svr.LabelStats("unknown"))
+}
+
+func TestUDPConnLabels(t *testing.T) {
+	testUDPConnLabels(t, "udp", ":9851")
+}
+
+type testUDPConnLabelsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	reacted       int32
+	cleaned       int32
+	N             int
+}
+
+func (t *testUDPConnLabelsServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	for i := 0; i < t.N; i++ {
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("Hello World!"))
+			require.NoError(t.tester, err)
+		}()
+	}
+	return
+}
+
+func (t *testUDPConnLabelsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	c.SetLabels(map[string]string{"tenant": "udp"})
+	c.OnCleanup(func() { atomic.AddInt32(&t.cleaned, 1) })
+	if int(atomic.AddInt32(&t.reacted, 1)) == t.N {
+		action = Shutdown
+	}
+	return
+}
+
+func testUDPConnLabels(t *testing.T, network, addr string) {
+	events := &testUDPConnLabelsServer{tester: t, network: network, addr: addr, N: 4}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+
+	// The labels are released along with the connections of the datagrams.
+	tenants := events.svr.LabelStats("tenant")
+	require.Len(t, tenants, 1)
+	assert.EqualValues(t, 0, tenants["udp"].Connections)
+	// So are the callbacks registered by OnCleanup run.
+	assert.EqualValues(t, events.N, events.cleaned)
+}
+
+func TestDecodeError(t *testing.T) {
+	testDecodeError(t, "tcp", ":9772")
+}
+
+var (
+	errBadFrame   = fmt.Errorf("bad frame")
+	errFatalFrame = fmt.Errorf("fatal frame")
+)
+
+type testDecodeErrorCodec struct {
+	LineBasedFrameCodec
+}
+
+func (cc *testDecodeErrorCodec) Decode(c Conn) ([]byte, error) {
+	frame, err := cc.LineBasedFrameCodec.Decode(c)
+	switch string(frame) {
+	case "bad":
+		return nil, errBadFrame
+	case "fatal":
+		return nil, errFatalFrame
+	}
+	return frame, err
+}
+
+type testDecodeErrorServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	decodeErrors  int32
+	closeErr      error
+}
+
+func (t *testDecodeErrorServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("bad\nok\n"))
+		require.NoError(t.tester, err)
+		rd := bufio.NewReader(conn)
+		line, err := rd.ReadString('\n')
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "ok\n", line)
+		_, err = conn.Write([]byte("fatal\n"))
+		require.NoError(t.tester, err)
+		_, err = rd.ReadString('\n')
+		require.Error(t.tester, err)
+	}()
+	return
+}
+
+func (t *testDecodeErrorServer) OnDecodeError(c Conn, err error) (action Action) {
+	atomic.AddInt32(&t.decodeErrors, 1)
+	if err == errFatalFrame {
+		action = Close
+	}
+	return
+}
+
+func (t *testDecodeErrorServer) OnClosed(c Conn, err error) (action Action) {
+	t.closeErr = err
+	return Shutdown
+}
+
+func (t *testDecodeErrorServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testDecodeError(t *testing.T, network, addr string) {
+	events := &testDecodeErrorServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&testDecodeErrorCodec{}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&events.decodeErrors))
+	assert.Equal(t, errFatalFrame, events.closeErr)
+}
+
+func TestCodecResync(t *testing.T) {
+	testCodecResync(t, "tcp", ":9773")
+}
+
+type testResyncCodec struct {
+	LineBasedFrameCodec
+}
+
+func (cc *testResyncCodec) Decode(c Conn) ([]byte, error) {
+	if buf := c.Read(); len(buf) > 0 && buf[0] != '#' {
+		return nil, errBadFrame
+	}
+	return cc.LineBasedFrameCodec.Decode(c)
+}
+
+func (cc *testResyncCodec) Resync(buffered []byte) (skip int, err error) {
+	if skip = bytes.IndexByte(buffered, '#'); skip == -1 {
+		skip = len(buffered)
+	}
+	return
+}
+
+type testCodecResyncServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	decodeErrors  int32
+}
+
+func (t *testCodecResyncServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("garbage#hello\nxx#world\n"))
+		require.NoError(t.tester, err)
+		rd := bufio.NewReader(conn)
+		for _, expected := range []string{"#hello\n", "#world\n"} {
+			line, err := rd.ReadString('\n')
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, expected, line)
+		}
+	}()
+	return
+}
+
+func (t *testCodecResyncServer) OnDecodeError(c Conn, err error) (action Action) {
+	atomic.AddInt32(&t.decodeErrors, 1)
+	return Close
+}
+
+func (t *testCodecResyncServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func (t *testCodecResyncServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testCodecResync(t *testing.T, network, addr string) {
+	events := &testCodecResyncServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&testResyncCodec{}))
+	assert.NoError(t, err)
+	assert.Zero(t, atomic.LoadInt32(&events.decodeErrors))
+}
+
+func TestHandshake(t *testing.T) {
+	testHandshake(t, "tcp", ":9774")
+}
+
+type testHandshakeServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	handshakes    int32
+}
+
+func (t *testHandshakeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("HEL"))
+		require.NoError(t.tester, err)
+		time.Sleep(100 * time.Millisecond)
+		_, err = conn.Write([]byte("LOping\n"))
+		require.NoError(t.tester, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "ping\n", line)
+	}()
+	return
+}
+
+func (t *testHandshakeServer) OnHandshake(c Conn, data []byte) (consumed int, done bool, action Action) {
+	atomic.AddInt32(&t.handshakes, 1)
+	if len(data) < len("HELLO") {
+		return
+	}
+	if string(data[:len("HELLO")]) != "HELLO" {
+		action = Close
+		return
+	}
+	return len("HELLO"), true, None
+}
+
+func (t *testHandshakeServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func (t *testHandshakeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testHandshake(t *testing.T, network, addr string) {
+	events := &testHandshakeServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&LineBasedFrameCodec{}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&events.handshakes))
+}
+
+func TestReactBatch(t *testing.T) {
+	testReactBatch(t, "tcp", ":9775")
+}
+
+type testReactBatchServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	batches       int32
+	frames        int32
+}
+
+func (t *testReactBatchServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a\nb\nc\n"))
+		require.NoError(t.tester, err)
+		data := make([]byte, len("a\nb\nc\n"))
+		_, err = io.ReadFull(conn, data)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "a\nb\nc\n", string(data))
+	}()
+	return
+}
+
+func (t *testReactBatchServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.Fail(t.tester, "React should not be called when ReactBatch is implemented")
+	return
+}
+
+func (t *testReactBatchServer) ReactBatch(frames [][]byte, c Conn) (outs [][]byte, action Action) {
+	atomic.AddInt32(&t.batches, 1)
+	atomic.AddInt32(&t.frames, int32(len(frames)))
+	outs = frames
+	return
+}
+
+func (t *testReactBatchServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testReactBatch(t *testing.T, network, addr string) {
+	events := &testReactBatchServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&LineBasedFrameCodec{}))
+	assert.NoError(t, err)
+	// The frames are sent by a single write, thus they are read at once and handed over in a single batch.
+	assert.EqualValues(t, 3, atomic.LoadInt32(&events.frames))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.batches))
+}
+
+func TestServerHealthy(t *testing.T) {
+	testServerHealthy(t, "tcp", ":9776")
+}
+
+type testServerHealthyServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	healthLn      net.Listener
+}
+
+func (t *testServerHealthyServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	assert.False(t.tester, svr.Healthy())
+	go func() { _ = svr.ServeHealth(t.healthLn) }()
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		_, err = conn.Read(make([]byte, 4))
+		require.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testServerHealthyServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.True(t.tester, t.svr.Healthy())
+	resp, err := http.Get("http://" + t.healthLn.Addr().String())
+	require.NoError(t.tester, err)
+	_ = resp.Body.Close()
+	assert.Equal(t.tester, http.StatusOK, resp.StatusCode)
+	out = frame
+	return
+}
+
+func (t *testServerHealthyServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testServerHealthy(t *testing.T, network, addr string) {
+	healthLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer healthLn.Close()
+	events := &testServerHealthyServer{tester: t, network: network, addr: addr, healthLn: healthLn}
+	err = Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.False(t, events.svr.Healthy())
+	resp, err := http.Get("http://" + healthLn.Addr().String())
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestLazyOnOpened(t *testing.T) {
+	testLazyOnOpened(t, "tcp", ":9777")
+}
+
+type testLazyOnOpenedServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	opened        int32
+	closed        int32
+}
+
+func (t *testLazyOnOpenedServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, conn.Close())
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err = net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hi"))
+		require.NoError(t.tester, err)
+		data := make([]byte, len("hello hi"))
+		_, err = io.ReadFull(conn, data)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "hello hi", string(data))
+	}()
+	return
+}
+
+func (t *testLazyOnOpenedServer) OnOpened(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	assert.Equal(t.tester, "hi", string(c.Read()))
+	out = []byte("hello ")
+	return
+}
+
+func (t *testLazyOnOpenedServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testLazyOnOpenedServer) OnClosed(c Conn, err error) (action Action) {
+	atomic.AddInt32(&t.closed, 1)
+	return Shutdown
+}
+
+func testLazyOnOpened(t *testing.T, network, addr string) {
+	events := &testLazyOnOpenedServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithLazyOnOpened(true))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.opened))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.closed))
+}
+
+func TestServerDump(t *testing.T) {
+	testServerDump(t, "tcp", ":9778")
+}
+
+type testServerDumpServer struct {
+	connID uint64 // updated atomically, keep it first for the alignment on 32-bit platforms
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testServerDumpServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		require.NoError(t.tester, err)
+
+		d := svr.Dump()
+		assert.True(t.tester, d.Healthy)
+		require.Len(t.tester, d.Loops, svr.NumEventLoop)
+		var conns []ConnDump
+		for _, ld := range d.Loops {
+			assert.True(t.tester, ld.Responsive)
+			conns = append(conns, ld.Conns...)
+		}
+		require.Len(t.tester, conns, 1)
+		assert.Equal(t.tester, atomic.LoadUint64(&t.connID), conns[0].ID)
+		assert.Equal(t.tester, conn.LocalAddr().String(), conns[0].RemoteAddr)
+		assert.Equal(t.tester, "open", conns[0].State)
+		assert.False(t.tester, conns[0].LastActive.IsZero())
+		_, err = json.Marshal(d)
+		assert.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testServerDumpServer) OnOpened(c Conn) (out []byte, action Action) {
+	assert.NotZero(t.tester, c.ID())
+	atomic.StoreUint64(&t.connID, c.ID())
+	return
+}
+
+func (t *testServerDumpServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testServerDumpServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testServerDump(t *testing.T, network, addr string) {
+	events := &testServerDumpServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMulticore(true))
+	assert.NoError(t, err)
+}
+
+func TestDedicatedGoroutine(t *testing.T) {
+	testDedicatedGoroutine(t, "tcp", ":9779")
+}
+
+type testDedicatedGoroutineServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       int32
+}
+
+func (t *testDedicatedGoroutineServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a\nb\nc\nbye\n"))
+		require.NoError(t.tester, err)
+		data, err := ioutil.ReadAll(conn)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "a\nb\nc\n", string(data))
+	}()
+	return
+}
+
+func (t *testDedicatedGoroutineServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetDedicatedGoroutine(true)
+	return
+}
+
+func (t *testDedicatedGoroutineServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.reacted, 1)
+	if string(frame) == "bye" {
+		action = Close
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	out = frame
+	return
+}
+
+func (t *testDedicatedGoroutineServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testDedicatedGoroutine(t *testing.T, network, addr string) {
+	events := &testDedicatedGoroutineServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&LineBasedFrameCodec{}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&events.reacted))
+}
+
+func TestDedicatedGoroutineDisable(t *testing.T) {
+	testDedicatedGoroutineDisable(t, "tcp", ":9842")
+}
+
+type testDedicatedGoroutineDisableServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       int32
+}
+
+func (t *testDedicatedGoroutineDisableServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a\nb\nc\n"))
+		require.NoError(t.tester, err)
+		data := make([]byte, 6)
+		_, err = io.ReadFull(conn, data)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "a\nb\nc\n", string(data))
+		_, err = conn.Write([]byte("d\nbye\n"))
+		require.NoError(t.tester, err)
+		data, err = ioutil.ReadAll(conn)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "d\n", string(data))
+	}()
+	return
+}
+
+func (t *testDedicatedGoroutineDisableServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetDedicatedGoroutine(true)
+	return
+}
+
+func (t *testDedicatedGoroutineDisableServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if frame == nil {
+		// Woken up on the event-loop while "b" and "c" are still queued for the dedicated goroutine.
+		c.SetDedicatedGoroutine(false)
+		return
+	}
+	atomic.AddInt32(&t.reacted, 1)
+	switch string(frame) {
+	case "a":
+		require.NoError(t.tester, c.Wake())
+		time.Sleep(50 * time.Millisecond)
+	case "bye":
+		action = Close
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testDedicatedGoroutineDisableServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testDedicatedGoroutineDisable(t *testing.T, network, addr string) {
+	events := &testDedicatedGoroutineDisableServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&LineBasedFrameCodec{}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, atomic.LoadInt32(&events.reacted))
+}
+
+func TestConnLogger(t *testing.T) {
+	testConnLogger(t, "tcp", ":9780")
+}
+
+type recordLogger struct {
+	logging.Logger
+	msgs chan string
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.msgs <- fmt.Sprintf(format, args...)
+}
+
+type testConnLoggerServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testConnLoggerServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testConnLoggerServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.Logger().Infof("opened with %d%%", 100)
+	return
+}
+
+func (t *testConnLoggerServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testConnLogger(t *testing.T, network, addr string) {
+	logger := &recordLogger{Logger: logging.GetDefaultLogger(), msgs: make(chan string, 1)}
+	events := &testConnLoggerServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithLogger(logger))
+	assert.NoError(t, err)
+	msg := <-logger.msgs
+	assert.Regexp(t, `^conn_id=\d+ remote_addr=\S+ opened with 100%$`, msg)
+}
+
+func TestZeroLengthDatagram(t *testing.T) {
+	testZeroLengthDatagram(t, "udp", ":9781")
+}
+
+type testZeroLengthDatagramServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	empty         int32
+}
+
+func (t *testZeroLengthDatagramServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		_, err = conn.Write(nil)
+		require.NoError(t.tester, err)
+		buf := make([]byte, 16)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "empty", string(buf[:n]))
+		_, err = conn.Write([]byte("bye"))
+		require.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testZeroLengthDatagramServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if frame != nil && len(frame) == 0 {
+		require.NotNil(t.tester, c.RemoteAddr())
+		atomic.AddInt32(&t.empty, 1)
+		out = []byte("empty")
+		return
+	}
+	action = Shutdown
+	return
+}
+
+func testZeroLengthDatagram(t *testing.T, network, addr string) {
+	events := &testZeroLengthDatagramServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.empty))
+}
+
+func TestScaleEventLoops(t *testing.T) {
+	testScaleEventLoops(t, "tcp", ":9782")
+}
+
+type testScaleEventLoopsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        int32
+}
+
+func (t *testScaleEventLoopsServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		for !svr.Healthy() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.EqualError(t.tester, svr.ScaleEventLoops(0), errors.ErrInvalidNumEventLoop.Error())
+		require.NoError(t.tester, svr.ScaleEventLoops(3))
+		require.EqualValues(t.tester, 3, svr.CountEventLoops())
+
+		var conns []net.Conn
+		for i := 0; i < 3; i++ {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, conn)
+		}
+		for svr.CountConnections() < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		d := svr.Dump()
+		require.Len(t.tester, d.Loops, 3)
+		for _, ld := range d.Loops {
+			require.EqualValues(t.tester, 1, ld.Connections)
+		}
+
+		// The connections of the parked event-loops are migrated to the remaining one.
+		require.NoError(t.tester, svr.ScaleEventLoops(1))
+		require.EqualValues(t.tester, 1, svr.CountEventLoops())
+		for svr.Dump().Loops[0].Connections < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.EqualValues(t.tester, 3, svr.CountConnections())
+		for _, ld := range svr.Dump().Loops[1:] {
+			require.EqualValues(t.tester, 0, ld.Connections)
+		}
+		for _, conn := range conns {
+			_, err := conn.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "ping", string(buf))
+		}
+
+		// Parked event-loops are put back into service first.
+		require.NoError(t.tester, svr.ScaleEventLoops(2))
+		require.Len(t.tester, svr.Dump().Loops, 3)
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	return
+}
+
+func (t *testScaleEventLoopsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testScaleEventLoopsServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 3 {
+		action = Shutdown
+	}
+	return
+}
+
+func testScaleEventLoops(t *testing.T, network, addr string) {
+	events := &testScaleEventLoopsServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithNumEventLoop(1))
+	assert.NoError(t, err)
+}
+
+func TestFdLimit(t *testing.T) {
+	testFdLimit(t, "tcp", ":9783")
+}
+
+type testFdLimitServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	near          int32
+	conn          net.Conn
+	ticks         int
+	dialErr       error
+	accepted      int
+}
+
+func (t *testFdLimitServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	return
+}
+
+func (t *testFdLimitServer) OnNearFdLimit(used, limit int) {
+	assert.Greater(t.tester, used, 0)
+	assert.Greater(t.tester, limit, 0)
+	atomic.AddInt32(&t.near, 1)
+}
+
+func (t *testFdLimitServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	if atomic.LoadInt32(&t.near) == 0 {
+		return
+	}
+	// Dial on a later tick than the one observing the callback, the failures are recorded rather than asserted
+	// off the test goroutine, which would stop the ticker and leave the server running.
+	switch t.ticks++; t.ticks {
+	case 2:
+		// The connection stays in the backlog of the listener while accepting is paused.
+		if t.conn, t.dialErr = net.Dial(t.network, t.addr); t.dialErr != nil {
+			action = Shutdown
+		}
+	case 6:
+		t.accepted = t.svr.CountConnections()
+		_ = t.conn.Close()
+		action = Shutdown
+	}
+	return
+}
+
+func testFdLimit(t *testing.T, network, addr string) {
+	events := &testFdLimitServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithTicker(true), WithFdLimitThreshold(1e-9))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.near))
+	assert.NoError(t, events.dialErr)
+	assert.Zero(t, events.accepted)
+}
+
+func TestEphemeralPort(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		testEphemeralPort(t, "tcp", false)
+	})
+	t.Run("tcp-reuseport", func(t *testing.T) {
+		testEphemeralPort(t, "tcp", true)
+	})
+	t.Run("udp", func(t *testing.T) {
+		testEphemeralPort(t, "udp", false)
+	})
+}
+
+type testEphemeralPortServer struct {
+	*EventServer
+	tester  *testing.T
+	network string
+}
+
+func (t *testEphemeralPortServer) OnInitComplete(svr Server) (action Action) {
+	_, port, err := net.SplitHostPort(svr.Addr.String())
+	require.NoError(t.tester, err)
+	require.NotEqual(t.tester, "0", port)
+	go func() {
+		for i := 0; i < 4; i++ {
+			conn, err := net.Dial(t.network, "127.0.0.1:"+port)
+			require.NoError(t.tester, err)
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "ping", string(buf))
+			_ = conn.Close()
+		}
+		require.NoError(t.tester, Stop(context.Background(), t.network+"://:"+port))
+	}()
+	return
+}
+
+func (t *testEphemeralPortServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testEphemeralPort(t *testing.T, network string, reuseport bool) {
+	events := &testEphemeralPortServer{tester: t, network: network}
+	err := Serve(events, network+"://:0", WithMulticore(true), WithNumEventLoop(2), WithReusePort(reuseport))
+	assert.NoError(t, err)
+}
+
+func TestDatagramCodec(t *testing.T) {
+	testDatagramCodec(t, "udp", ":9784")
+}
+
+type testDatagramCodecServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	codec         *HTTP3DatagramCodec
+}
+
+func (t *testDatagramCodecServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer conn.Close()
+		packet, _ := t.codec.EncodeDatagram(nil, []byte("hello"))
+		_, err = conn.Write(packet)
+		require.NoError(t.tester, err)
+		buf := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t.tester, err)
+		frames, err := t.codec.DecodeDatagram(nil, buf[:n])
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, [][]byte{[]byte("hello")}, frames)
+		// Malformed datagrams are dropped.
+		_, err = conn.Write([]byte{0xff})
+		require.NoError(t.tester, err)
+		packet, _ = t.codec.EncodeDatagram(nil, []byte("bye"))
+		_, err = conn.Write(packet)
+		require.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testDatagramCodecServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "bye" {
+		action = Shutdown
+		return
+	}
+	out = frame
+	return
+}
+
+func testDatagramCodec(t *testing.T, network, addr string) {
+	codec, err := NewHTTP3DatagramCodec(0)
+	require.NoError(t, err)
+	events := &testDatagramCodecServer{tester: t, network: network, addr: addr, codec: codec}
+	err = Serve(events, network+"://"+addr, WithCodec(codec))
+	assert.NoError(t, err)
+}
+
+func TestResumeToken(t *testing.T) {
+	testResumeToken(t, "tcp", ":9785")
+}
+
+type testResumeTokenServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        int32
+}
+
+func (t *testResumeTokenServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 4; i++ {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			_, err = conn.Write([]byte("tok1:ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 9)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "tok1:ping", string(buf))
+			conns = append(conns, conn)
+		}
+
+		// All connections carrying the same token end up in the same event-loop.
+		var busy int
+		for _, ld := range svr.Dump().Loops {
+			if ld.Connections > 0 {
+				busy++
+				require.EqualValues(t.tester, 4, ld.Connections)
+			}
+		}
+		require.EqualValues(t.tester, 1, busy)
+
+		// The migrated connections keep working.
+		for _, conn := range conns {
+			_, err := conn.Write([]byte("pong"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "pong", string(buf))
+			_ = conn.Close()
+		}
+	}()
+	return
+}
+
+func (t *testResumeTokenServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testResumeTokenServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 4 {
+		action = Shutdown
+	}
+	return
+}
+
+func testResumeToken(t *testing.T, network, addr string) {
+	events := &testResumeTokenServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithNumEventLoop(4), WithResumeToken(func(c Conn, firstBytes []byte) (string, bool) {
+		if i := bytes.IndexByte(firstBytes, ':'); i > 0 {
+			return string(firstBytes[:i]), true
+		}
+		return "", false
+	}))
+	assert.NoError(t, err)
+}
+
+func TestWorkerPoolBackpressure(t *testing.T) {
+	testWorkerPoolBackpressure(t, "tcp", ":9786")
+}
+
+type testWorkerPoolServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	release       chan struct{}
+	closed        int32
+}
+
+func (t *testWorkerPoolServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		blocker, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = blocker.Write([]byte("block"))
+		require.NoError(t.tester, err)
+		for svr.WorkerPoolStats().Running < 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// The saturated pool pauses the reads of the connection rather than blocking the event-loop.
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		for svr.WorkerPoolStats().PausedConnections < 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		stats := svr.WorkerPoolStats()
+		require.EqualValues(t.tester, 0, stats.Free)
+		require.NotZero(t.tester, stats.Rejected)
+
+		close(t.release)
+		for c, want := range map[net.Conn]string{blocker: "block", conn: "ping"} {
+			buf := make([]byte, len(want))
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, want, string(buf))
+		}
+		for svr.WorkerPoolStats().PausedConnections > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = blocker.Close()
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testWorkerPoolServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "block" {
+		<-t.release
+	}
+	out = frame
+	return
+}
+
+func (t *testWorkerPoolServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testWorkerPoolBackpressure(t *testing.T, network, addr string) {
+	pool, err := ants.NewPool(1, ants.WithNonblocking(true))
+	require.NoError(t, err)
+	defer pool.Release()
+	events := &testWorkerPoolServer{tester: t, network: network, addr: addr, release: make(chan struct{})}
+	err = Serve(events, network+"://"+addr, WithWorkerPool(pool))
+	assert.NoError(t, err)
+}
+
+func TestPortRange(t *testing.T) {
+	testPortRange(t, "tcp", 9787, 9788)
+}
+
+type testPortRangeServer struct {
+	*EventServer
+	tester  *testing.T
+	network string
+	port    int
+}
+
+func (t *testPortRangeServer) OnInitComplete(svr Server) (action Action) {
+	require.EqualValues(t.tester, t.port, svr.Addr.(*net.TCPAddr).Port)
+	go func() {
+		require.NoError(t.tester, Stop(context.Background(), fmt.Sprintf("%s://:%d", t.network, t.port)))
+	}()
+	return
+}
+
+func testPortRange(t *testing.T, network string, min, max int) {
+	// Occupy the first port in the range so that the server has to bind to the other one.
+	occupied, err := net.Listen(network, fmt.Sprintf(":%d", min))
+	require.NoError(t, err)
+	defer occupied.Close()
+
+	events := &testPortRangeServer{tester: t, network: network, port: max}
+	err = Serve(events, network+"://:0", WithPortRange(min, max))
+	assert.NoError(t, err)
+
+	events = &testPortRangeServer{tester: t, network: network}
+	err = Serve(events, network+"://:0", WithPortRange(min, min))
+	assert.Error(t, err)
+}
+
+func TestOnWriteReady(t *testing.T) {
+	testOnWriteReady(t, "tcp", ":9788")
+}
+
+type testOnWriteReadyServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	ready         chan int
+}
+
+func (t *testOnWriteReadyServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		// The callback fires once being set up.
+		require.NotZero(t.tester, <-t.ready)
+
+		// The callback fires again once the data congested in the outbound buffer is flushed.
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		time.Sleep(100 * time.Millisecond)
+		_, err = io.CopyN(ioutil.Discard, conn, 16<<20)
+		require.NoError(t.tester, err)
+		<-t.ready
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testOnWriteReadyServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.OnWriteReady(func(c Conn, freeSpace int) {
+		if c.OutboundBuffered() == 0 {
+			t.ready <- freeSpace
+		}
+	})
+	return
+}
+
+func (t *testOnWriteReadyServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = make([]byte, 16<<20)
+	return
+}
+
+func (t *testOnWriteReadyServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testOnWriteReady(t *testing.T, network, addr string) {
+	events := &testOnWriteReadyServer{tester: t, network: network, addr: addr, ready: make(chan int, 1)}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestReplaceConn(t *testing.T) {
+	testReplaceConn(t, "tcp", ":9789")
+}
+
+type testReplaceConnServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	replaced      chan bool
+	closed        int32
+	last          Conn
+}
+
+func (t *testReplaceConnServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		first, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = first.Write([]byte("alice"))
+		require.NoError(t.tester, err)
+		require.False(t.tester, <-t.replaced)
+
+		second, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = second.Write([]byte("alice"))
+		require.NoError(t.tester, err)
+		require.True(t.tester, <-t.replaced)
+
+		// The prior connection of the same identity gets closed.
+		_ = first.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = first.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok {
+			require.False(t.tester, ne.Timeout())
+		} else {
+			require.Error(t.tester, err)
+		}
+		_ = first.Close()
+		_ = second.Close()
+	}()
+	return
+}
+
+func (t *testReplaceConnServer) OnOpened(c Conn) (out []byte, action Action) {
+	// Reset the connection on closing, which spares the port from TIME_WAIT.
+	c.SetCloseBehavior(LingerClose)
+	return
+}
+
+func (t *testReplaceConnServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	old, existed := t.svr.ReplaceConn(string(frame), c)
+	require.True(t.tester, old != c)
+	t.replaced <- existed
+	return
+}
+
+func (t *testReplaceConnServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 2 {
+		t.last = c
+		action = Shutdown
+	}
+	return
+}
+
+func testReplaceConn(t *testing.T, network, addr string) {
+	events := &testReplaceConnServer{tester: t, network: network, addr: addr, replaced: make(chan bool, 1)}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	require.Zero(t, events.svr.svr.sessions.size)
+
+	// Registering a connection that has been closed doesn't leave a stale entry behind.
+	old, existed := events.svr.ReplaceConn("bob", events.last)
+	require.False(t, existed)
+	require.Nil(t, old)
+	require.Zero(t, events.svr.svr.sessions.size)
+}
+
+func TestHistograms(t *testing.T) {
+	testHistograms(t, "tcp", ":9790")
+}
+
+type testHistogramsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+}
+
+func (t *testHistogramsServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		require.NoError(t.tester, err)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testHistogramsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testHistogramsServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testHistograms(t *testing.T, network, addr string) {
+	events := &testHistogramsServer{tester: t, network: network, addr: addr}
+	buckets := []time.Duration{time.Second, 10 * time.Millisecond}
+	err := Serve(events, network+"://"+addr, WithHistogramBuckets(buckets, nil))
+	assert.NoError(t, err)
+
+	firstByte, connAge := events.svr.Histograms()
+	require.Equal(t, []time.Duration{10 * time.Millisecond, time.Second}, firstByte.Buckets)
+	require.Equal(t, []uint64{0, 1, 0}, firstByte.Counts)
+	require.EqualValues(t, 1, firstByte.Count)
+	require.True(t, firstByte.Sum >= 20*time.Millisecond)
+	require.Equal(t, DefaultConnAgeBuckets, connAge.Buckets)
+	require.EqualValues(t, 1, connAge.Count)
+	require.True(t, connAge.Sum >= firstByte.Sum)
+}
+
+func TestMigrateToLoop(t *testing.T) {
+	testMigrateToLoop(t, "tcp", ":9791")
+}
+
+type testMigrateToLoopServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        int32
+}
+
+func (t *testMigrateToLoopServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, conn)
+		}
+		for svr.CountConnections() < 2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, ld := range svr.Dump().Loops {
+			require.EqualValues(t.tester, 1, ld.Connections)
+		}
+
+		for _, conn := range conns {
+			_, err := conn.Write([]byte("move"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "move", string(buf))
+		}
+		d := svr.Dump()
+		require.EqualValues(t.tester, 2, d.Loops[0].Connections)
+		require.EqualValues(t.tester, 0, d.Loops[1].Connections)
+
+		// The migrated connection keeps working in the new event-loop.
+		for _, conn := range conns {
+			_, err := conn.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "ping", string(buf))
+			_ = conn.Close()
+		}
+	}()
+	return
+}
+
+func (t *testMigrateToLoopServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "move" {
+		require.EqualError(t.tester, c.MigrateToLoop(2), errors.ErrInvalidLoopIndex.Error())
+		require.NoError(t.tester, c.MigrateToLoop(0))
+		// The reply is written after the migration.
+		_ = c.AsyncWrite(append([]byte(nil), frame...))
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testMigrateToLoopServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testMigrateToLoop(t *testing.T, network, addr string) {
+	events := &testMigrateToLoopServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithNumEventLoop(2))
+	assert.NoError(t, err)
+}
+
+func TestAsyncWriteRaw(t *testing.T) {
+	testAsyncWriteRaw(t, "tcp", ":9792")
+}
+
+type testAsyncWriteRawServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testAsyncWriteRawServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("ping\n"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 9)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "raw\nping\n", string(buf))
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testAsyncWriteRawServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The raw frame is written as it is, while the other one is encoded with a newline.
+	require.NoError(t.tester, c.AsyncWriteRaw([]byte("raw\n")))
+	require.NoError(t.tester, c.AsyncWrite(append([]byte(nil), frame...)))
+	return
+}
+
+func (t *testAsyncWriteRawServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testAsyncWriteRaw(t *testing.T, network, addr string) {
+	events := &testAsyncWriteRawServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)))
+	assert.NoError(t, err)
+}
+
+func TestCloseCause(t *testing.T) {
+	testCloseCause(t, "tcp", ":9793")
+}
+
+type testCloseCauseServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	causes        chan CloseCause
+	closed        int
+}
+
+func (t *testCloseCauseServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		dial := func() net.Conn {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			return conn
+		}
+
+		conn := dial()
+		_ = conn.Close()
+		require.Equal(t.tester, ClosePeerFIN, <-t.causes)
+
+		conn = dial()
+		require.NoError(t.tester, conn.(*net.TCPConn).SetLinger(0))
+		_ = conn.Close()
+		require.Equal(t.tester, ClosePeerRST, <-t.causes)
+
+		conn = dial()
+		_, err := conn.Write([]byte("close"))
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, CloseLocal, <-t.causes)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testCloseCauseServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "close" {
+		c.SetCloseBehavior(LingerClose)
+		action = Close
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testCloseCauseServer) OnClosed(c Conn, err error) (action Action) {
+	t.causes <- c.CloseCause()
+	if t.closed++; t.closed == 3 {
+		action = Shutdown
+	}
+	return
+}
+
+func testCloseCause(t *testing.T, network, addr string) {
+	events := &testCloseCauseServer{tester: t, network: network, addr: addr, causes: make(chan CloseCause, 3)}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestOnOpenedOrdering(t *testing.T) {
+	t.Run("eager", func(t *testing.T) {
+		testOnOpenedOrdering(t, "tcp", ":9794", false)
+	})
+	t.Run("lazy", func(t *testing.T) {
+		testOnOpenedOrdering(t, "tcp", ":9795", true)
+	})
+}
+
+type testOnOpenedOrderingServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	clients       int32
+	closed        int32
+}
+
+func (t *testOnOpenedOrderingServer) OnInitComplete(svr Server) (action Action) {
+	for i := int32(0); i < t.clients; i++ {
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			// Pipeline the requests without waiting for the banner.
+			_, err = conn.Write([]byte("first\nsecond\n"))
+			require.NoError(t.tester, err)
+			expected := "banner\r\nfirst\nsecond\n"
+			buf := make([]byte, len(expected))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, expected, string(buf))
+			_ = conn.Close()
+		}()
+	}
+	return
+}
+
+func (t *testOnOpenedOrderingServer) OnOpened(c Conn) (out []byte, action Action) {
+	out = []byte("banner\r\n")
+	return
+}
+
+func (t *testOnOpenedOrderingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testOnOpenedOrderingServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == t.clients {
+		action = Shutdown
+	}
+	return
+}
+
+func testOnOpenedOrdering(t *testing.T, network, addr string, lazy bool) {
+	events := &testOnOpenedOrderingServer{tester: t, network: network, addr: addr, clients: 10}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)), WithLazyOnOpened(lazy),
+		WithMulticore(true))
+	assert.NoError(t, err)
+}
+
+func TestCloseAfterFlush(t *testing.T) {
+	testCloseAfterFlush(t, "tcp", ":9796")
+}
+
+type testCloseAfterFlushServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	data          []byte
+	cause         CloseCause
+}
+
+func (t *testCloseAfterFlushServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("bye"))
+		require.NoError(t.tester, err)
+		// Let the server buffer up the response before reading it.
+		time.Sleep(100 * time.Millisecond)
+		got, err := ioutil.ReadAll(conn)
+		require.NoError(t.tester, err)
+		require.True(t.tester, bytes.Equal(t.data, got), "the final response is truncated: %d/%d", len(got), len(t.data))
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testCloseAfterFlushServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = t.data
+	_ = c.CloseAfterFlush()
+	return
+}
+
+func (t *testCloseAfterFlushServer) OnClosed(c Conn, err error) (action Action) {
+	t.cause = c.CloseCause()
+	action = Shutdown
+	return
+}
+
+func testCloseAfterFlush(t *testing.T, network, addr string) {
+	data := make([]byte, 16*1024*1024)
+	_, _ = rand.Read(data)
+	events := &testCloseAfterFlushServer{tester: t, network: network, addr: addr, data: data}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.Equal(t, CloseLocal, events.cause)
+}
+
+func TestMaxQueuedFrames(t *testing.T) {
+	testMaxQueuedFrames(t, "tcp", ":9797")
+}
+
+type testMaxQueuedFramesServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	release       chan struct{}
+}
+
+func (t *testMaxQueuedFramesServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("block\n"))
+		require.NoError(t.tester, err)
+		for svr.WorkerPoolStats().Running < 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// The reads are paused once two frames are outstanding even though the pool has plenty of free workers.
+		_, err = conn.Write([]byte("ping\n"))
+		require.NoError(t.tester, err)
+		for svr.WorkerPoolStats().PausedConnections < 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Zero(t.tester, svr.WorkerPoolStats().Rejected)
+
+		close(t.release)
+		buf := make([]byte, len("block\nping\n"))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "block\nping\n", string(buf))
+		for svr.WorkerPoolStats().PausedConnections > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testMaxQueuedFramesServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "block" {
+		<-t.release
+	}
+	out = frame
+	return
+}
+
+func (t *testMaxQueuedFramesServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testMaxQueuedFrames(t *testing.T, network, addr string) {
+	pool, err := ants.NewPool(8, ants.WithNonblocking(true))
+	require.NoError(t, err)
+	defer pool.Release()
+	events := &testMaxQueuedFramesServer{tester: t, network: network, addr: addr, release: make(chan struct{})}
+	err = Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)), WithWorkerPool(pool),
+		WithMaxQueuedFrames(2))
+	assert.NoError(t, err)
+}
+
+func TestConnRand(t *testing.T) {
+	testConnRand(t, "tcp", ":9798")
+}
+
+type testConnRandServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testConnRandServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		// The sequence is reproducible with the same seed.
+		r := rand.New(rand.NewSource(42))
+		for i := 0; i < 3; i++ {
+			_, err = conn.Write([]byte("next"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 8)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			require.EqualValues(t.tester, r.Int63(), binary.BigEndian.Uint64(buf))
+		}
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testConnRandServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = make([]byte, 8)
+	binary.BigEndian.PutUint64(out, uint64(c.Rand().Int63()))
+	return
+}
+
+func (t *testConnRandServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testConnRand(t *testing.T, network, addr string) {
+	events := &testConnRandServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithRandSeed(42))
+	assert.NoError(t, err)
+}
+
+func TestAcceptQueueLen(t *testing.T) {
+	testAcceptQueueLen(t, "tcp", ":9799")
+}
+
+type testAcceptQueueLenServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	opened        chan struct{}
+	release       chan struct{}
+	closed        int32
+}
+
+func (t *testAcceptQueueLenServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		conns = append(conns, conn)
+		<-t.opened
+
+		// The event-loop is blocked, thus the following connections are left in the accept queue.
+		for i := 0; i < 3; i++ {
+			conn, err = net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, conn)
+		}
+		n, err := svr.AcceptQueueLen()
+		if err != errors.ErrUnsupportedOp {
+			require.NoError(t.tester, err)
+			for start := time.Now(); n < 3 && time.Since(start) < time.Second; n, _ = svr.AcceptQueueLen() {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.EqualValues(t.tester, 3, n)
+		}
+
+		close(t.release)
+		for _, conn := range conns {
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			_ = conn.Close()
+		}
+	}()
+	return
+}
+
+func (t *testAcceptQueueLenServer) OnOpened(c Conn) (out []byte, action Action) {
+	select {
+	case <-t.release:
+	default:
+		close(t.opened)
+		<-t.release
+	}
+	return
+}
+
+func (t *testAcceptQueueLenServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testAcceptQueueLenServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 4 {
+		action = Shutdown
+	}
+	return
+}
+
+func testAcceptQueueLen(t *testing.T, network, addr string) {
+	events := &testAcceptQueueLenServer{
+		tester:  t,
+		network: network,
+		addr:    addr,
+		opened:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	// The event-loop accepts the connections itself with ReusePort, rather than the main reactor.
+	err := Serve(events, network+"://"+addr, WithReusePort(true), WithAcceptQueueThreshold(2))
+	assert.NoError(t, err)
+}
+
+func TestForward(t *testing.T) {
+	testForward(t, "tcp", ":9800")
+}
+
+type testForwardServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	backend       Conn
+	closed        int32
+}
+
+func (t *testForwardServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		backend, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = backend.Write([]byte("backend\n"))
+		require.NoError(t.tester, err)
+		r := bufio.NewReader(backend)
+		line, err := r.ReadString('\n')
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "ok\n", line)
+
+		client, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			for i := 0; i < 1000; i++ {
+				_, _ = client.Write([]byte(fmt.Sprintf("%d\n", i)))
+			}
+		}()
+		// The frames are relayed to the backend in the order they are sent by the client.
+		for i := 0; i < 1000; i++ {
+			line, err = r.ReadString('\n')
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, fmt.Sprintf("%d\n", i), line)
+		}
+		_ = client.Close()
+		_ = backend.Close()
+	}()
+	return
+}
+
+func (t *testForwardServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "backend" {
+		t.backend = c
+		out = []byte("ok")
+		return
+	}
+	_ = c.Forward(t.backend, frame)
+	return
+}
+
+func (t *testForwardServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testForward(t *testing.T, network, addr string) {
+	events := &testForwardServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)), WithMulticore(true),
+		WithLoadBalancing(RoundRobin))
+	assert.NoError(t, err)
+}
+
+func TestConnInitFunc(t *testing.T) {
+	testConnInitFunc(t, "tcp", ":9801")
+}
+
+type testConnInitFuncServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	inits         int32
+}
+
+func (t *testConnInitFuncServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, len("scratch:hello"))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "scratch:hello", string(buf))
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testConnInitFuncServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	scratch, _ := c.Context().(*bytes.Buffer)
+	if scratch == nil {
+		action = Close
+		return
+	}
+	scratch.Write(frame)
+	out = scratch.Bytes()
+	return
+}
+
+func (t *testConnInitFuncServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testConnInitFunc(t *testing.T, network, addr string) {
+	events := &testConnInitFuncServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithConnInitFunc(func(c Conn) {
+		atomic.AddInt32(&events.inits, 1)
+		c.SetContext(bytes.NewBufferString("scratch:"))
+	}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.inits))
+}
+
+func TestServeFile(t *testing.T) {
+	testServeFile(t, "tcp", ":9802")
+}
+
+type testServeFileServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	path          string
+	data          []byte
+	served        chan error
+}
+
+func (t *testServeFileServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("get"))
+		require.NoError(t.tester, err)
+		// Let the socket send buffer fill up, so that the file is sent on writable events.
+		time.Sleep(100 * time.Millisecond)
+		expected := append(append([]byte(nil), t.data[1024:]...), "trailer"...)
+		buf := make([]byte, len(expected))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.True(t.tester, bytes.Equal(expected, buf), "the file is corrupted")
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testServeFileServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if err := c.ServeFile(t.path, 1024, -1, func(c Conn, err error) { t.served <- err }); err != nil {
+		t.served <- err
+		action = Close
+		return
+	}
+	// The data written during the transfer is sent after the file.
+	out = []byte("trailer")
+	return
+}
+
+func (t *testServeFileServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testServeFile(t *testing.T, network, addr string) {
+	f, err := ioutil.TempFile("", "gnet-serve-file")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	data := make([]byte, 16<<20)
+	_, _ = rand.Read(data)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	events := &testServeFileServer{
+		tester:  t,
+		network: network,
+		addr:    addr,
+		path:    f.Name(),
+		data:    data,
+		served:  make(chan error, 1),
+	}
+	err = Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.NoError(t, <-events.served)
+}
+
+func TestServeFileShort(t *testing.T) {
+	testServeFileShort(t, "tcp", ":9843")
+}
+
+type testServeFileShortServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	path          string
+	data          []byte
+	served        chan error
+}
+
+func (t *testServeFileShortServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("get"))
+		require.NoError(t.tester, err)
+		// Let the socket send buffer fill up, so that the file is sent on writable events.
+		time.Sleep(100 * time.Millisecond)
+		// The data written during the transfer is still sent after the file falls short.
+		expected := append(append([]byte(nil), t.data...), "trailer"...)
+		buf := make([]byte, len(expected))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.True(t.tester, bytes.Equal(expected, buf), "the file is corrupted")
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testServeFileShortServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if err := c.ServeFile(t.path, 0, int64(len(t.data))+1024, func(c Conn, err error) { t.served <- err }); err != nil {
+		t.served <- err
+		action = Close
+		return
+	}
+	out = []byte("trailer")
+	return
+}
+
+func (t *testServeFileShortServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testServeFileShort(t *testing.T, network, addr string) {
+	f, err := ioutil.TempFile("", "gnet-serve-file-short")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	data := make([]byte, 16<<20)
+	_, _ = rand.Read(data)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	events := &testServeFileShortServer{
+		tester:  t,
+		network: network,
+		addr:    addr,
+		path:    f.Name(),
+		data:    data,
+		served:  make(chan error, 1),
+	}
+	err = Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.Equal(t, io.ErrUnexpectedEOF, <-events.served)
+}
+
+func TestRawMode(t *testing.T) {
+	testRawMode(t, "tcp", ":9803")
+}
+
+type testRawModeServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       int32
+}
+
+func (t *testRawModeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		// The data is handled in chunks of 5 bytes no matter how it arrives.
+		for _, piece := range []string{"hel", "lo wo", "rld!!", "xy"} {
+			_, err = conn.Write([]byte(piece))
+			require.NoError(t.tester, err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		buf := make([]byte, 15)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "hello world!!xy", string(buf))
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testRawModeServer) OnReadable(c Conn) (action Action) {
+	for c.BufferLength() >= 5 {
+		_, chunk := c.ReadN(5)
+		_ = c.AsyncWrite(append([]byte(nil), chunk...))
+		c.ShiftN(5)
+	}
+	return
+}
+
+func (t *testRawModeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.reacted, 1)
+	return
+}
+
+func (t *testRawModeServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testRawMode(t *testing.T, network, addr string) {
+	events := &testRawModeServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithRawMode(true), WithCodec(new(LineBasedFrameCodec)))
+	assert.NoError(t, err)
+	assert.Zero(t, atomic.LoadInt32(&events.reacted))
+
+	err = Serve(new(EventServer), network+"://"+addr, WithRawMode(true))
+	assert.ErrorIs(t, err, errors.ErrRawModeUnhandled)
+}
+
+func TestExportImportState(t *testing.T) {
+	testExportImportState(t, "tcp", ":9804")
+}
+
+type testSessionState string
+
+func (s testSessionState) MarshalBinary() ([]byte, error) {
+	return []byte(s), nil
+}
+
+type testExportImportStateServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+	imported      chan error
+	closed        int32
+}
+
+func (t *testExportImportStateServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = conn.Write([]byte("login alice\nmove\npart"))
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, <-t.imported)
+
+		// The partial frame buffered before the migration is completed on the imported connection.
+		_, err = conn.Write([]byte("ial\n"))
+		require.NoError(t.tester, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "alice:partial\n", line)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testExportImportStateServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch s := string(frame); {
+	case strings.HasPrefix(s, "login "):
+		c.SetContext(testSessionState(strings.TrimPrefix(s, "login ")))
+	case s == "move":
+		state, err := c.ExportState()
+		if err != nil {
+			t.imported <- err
+			return nil, Close
+		}
+		fd, err := c.DupFd()
+		if err != nil {
+			t.imported <- err
+			return nil, Close
+		}
+		c.SetCloseBehavior(DiscardOnClose)
+		go func() {
+			t.imported <- t.svr.ImportConn(fd, state, func(c Conn, appState []byte) {
+				c.SetContext(testSessionState(appState))
+			})
+		}()
+		action = Close
+	default:
+		out = []byte(fmt.Sprintf("%s:%s", c.Context(), frame))
+	}
+	return
+}
+
+func (t *testExportImportStateServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testExportImportState(t *testing.T, network, addr string) {
+	events := &testExportImportStateServer{tester: t, network: network, addr: addr, imported: make(chan error, 1)}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)))
+	assert.NoError(t, err)
+}
+
+// testLimitedServer rejects new connections once the server holds as many connections as the limit.
+type testLimitedServer struct {
+	*EventServer
+	svr   ServerController
+	limit int
+}
+
+func (t *testLimitedServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	return
+}
+
+func (t *testLimitedServer) OnOpened(c Conn) (out []byte, action Action) {
+	if t.svr.CountConnections() > t.limit {
+		out, action = []byte("busy"), Close
+	}
+	return
+}
+
+type fakeServer struct {
+	ServerController
+	connections int
+}
+
+func (s *fakeServer) CountConnections() int {
+	return s.connections
+}
+
+func TestServerController(t *testing.T) {
+	fake := &fakeServer{connections: 1}
+	events := &testLimitedServer{svr: fake, limit: 1}
+	out, action := events.OnOpened(nil)
+	assert.Nil(t, out)
+	assert.Equal(t, None, action)
+
+	fake.connections = 2
+	out, action = events.OnOpened(nil)
+	assert.Equal(t, "busy", string(out))
+	assert.Equal(t, Close, action)
+}
+
+func TestWriteDeadline(t *testing.T) {
+	testWriteDeadline(t, "tcp", ":9805")
+}
+
+type testWriteDeadlineServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	errs          chan error
+}
+
+func (t *testWriteDeadlineServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// A peer that never reads is cut off at the deadline.
+		stalled, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = stalled.Write([]byte("stall"))
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, errors.ErrWriteTimeout, <-t.errs)
+		_ = stalled.Close()
+
+		// A slow peer that keeps reading survives the deadline.
+		slow, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = slow.Write([]byte("slow"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 256<<10)
+		for read := 0; read < 8<<20; {
+			n, err := slow.Read(buf)
+			require.NoError(t.tester, err)
+			read += n
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = slow.Close()
+		require.NoError(t.tester, <-t.errs)
+	}()
+	return
+}
+
+func (t *testWriteDeadlineServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetCloseBehavior(LingerClose)
+	return
+}
+
+func (t *testWriteDeadlineServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = make([]byte, 8<<20)
+	_ = c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	return
+}
+
+func (t *testWriteDeadlineServer) OnClosed(c Conn, err error) (action Action) {
+	if err == errors.ErrWriteTimeout {
+		assert.Equal(t.tester, CloseTimeout, c.CloseCause())
+		t.errs <- err
+		return
+	}
+	t.errs <- nil
+	action = Shutdown
+	return
+}
+
+func testWriteDeadline(t *testing.T, network, addr string) {
+	events := &testWriteDeadlineServer{tester: t, network: network, addr: addr, errs: make(chan error, 2)}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestAsyncWriteTagged(t *testing.T) {
+	testAsyncWriteTagged(t, "tcp", ":9806")
+}
+
+type testAsyncWriteTaggedServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	flushed       []uint64
+}
+
+func (t *testAsyncWriteTaggedServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("go"))
+		require.NoError(t.tester, err)
+		// Let the outbound buffer fill up before reading.
+		time.Sleep(100 * time.Millisecond)
+		_, err = io.ReadFull(c, make([]byte, 3<<20))
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("done"))
+		require.NoError(t.tester, err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testAsyncWriteTaggedServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "done" {
+		assert.Equal(t.tester, []uint64{1, 2, 3}, t.flushed)
+		action = Shutdown
+		return
+	}
+	go func() {
+		for tag := uint64(1); tag <= 3; tag++ {
+			_ = c.AsyncWriteTagged(make([]byte, 1<<20), tag)
+		}
+	}()
+	return
+}
+
+func (t *testAsyncWriteTaggedServer) OnWriteFlushed(c Conn, tag uint64) {
+	t.flushed = append(t.flushed, tag)
+}
+
+func testAsyncWriteTagged(t *testing.T, network, addr string) {
+	events := &testAsyncWriteTaggedServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestMaxTotalBufferMemory(t *testing.T) {
+	testMaxTotalBufferMemory(t, "tcp", ":9807")
+}
+
+type testMaxTotalBufferMemoryServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testMaxTotalBufferMemoryServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		waitFor := func(cond func() bool) bool {
+			for i := 0; i < 100; i++ {
+				if cond() {
+					return true
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return false
+		}
+
+		quiet, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer quiet.Close()
+
+		// A peer that doesn't read piles up the outbound buffer beyond the limit.
+		hog, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer hog.Close()
+		_, err = hog.Write([]byte("big"))
+		require.NoError(t.tester, err)
+		assert.True(t.tester, waitFor(func() bool { return svr.BufferMemory() > 1024 }))
+
+		// The connections with nothing to drain keep being served.
+		_, err = quiet.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_ = quiet.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(quiet, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "ping", string(buf))
+
+		// New connections are refused meanwhile.
+		refused, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_ = refused.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = refused.Read(make([]byte, 1))
+		assert.Error(t.tester, err)
+		_ = refused.Close()
+
+		// They are accepted again once the buffered data is drained.
+		_, err = io.ReadFull(hog, make([]byte, 16<<20))
+		require.NoError(t.tester, err)
+		assert.True(t.tester, waitFor(func() bool { return svr.BufferMemory() == 0 }))
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "ping", string(buf))
+		_, _ = c.Write([]byte("stop"))
+	}()
+	return
+}
+
+func (t *testMaxTotalBufferMemoryServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "big":
+		out = make([]byte, 16<<20)
+	case "stop":
+		action = Shutdown
+	default:
+		out = frame
+	}
+	return
+}
+
+func testMaxTotalBufferMemory(t *testing.T, network, addr string) {
+	events := &testMaxTotalBufferMemoryServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMaxTotalBufferMemory(1024))
+	assert.NoError(t, err)
+}
+
+func TestConnLogSampling(t *testing.T) {
+	count := func(rate float64) (n int) {
+		svr := &server{opts: &Options{ConnLogSampling: rate}}
+		for i := 0; i < 1000; i++ {
+			if svr.sampleConnLog() {
+				n++
+			}
+		}
+		return
+	}
+	assert.Equal(t, 1000, count(0))
+	assert.Equal(t, 1000, count(1))
+	assert.Equal(t, 100, count(0.1))
+	assert.Equal(t, 250, count(0.25))
+
+	// The connections closed due to errors are always logged.
+	svr := &server{opts: &Options{ConnLogSampling: 0.1}}
+	logger := &countLogger{Logger: logging.GetDefaultLogger()}
+	c := &logConn{logger: logger}
+	for i := 0; i < 100; i++ {
+		svr.logConnClosed(c, ClosePeerRST, syscall.ECONNRESET)
+	}
+	assert.Equal(t, 100, logger.n)
+	logger.n = 0
+	for i := 0; i < 100; i++ {
+		svr.logConnClosed(c, ClosePeerFIN, nil)
+	}
+	assert.Equal(t, 10, logger.n)
+}
+
+type countLogger struct {
+	logging.Logger
+	n int
+}
+
+func (l *countLogger) Debugf(format string, args ...interface{}) {
+	l.n++
+}
+
+type logConn struct {
+	Conn
+	logger logging.Logger
+}
+
+func (c *logConn) Logger() logging.Logger {
+	return c.logger
+}
+
+func TestProbe(t *testing.T) {
+	testProbe(t, "tcp", ":9808")
+}
+
+type testProbeServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        int
+}
+
+func (t *testProbeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// A live peer receives the probe frame.
+		live, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = live.Write([]byte("live"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(live, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "ping", string(buf))
+		_ = live.Close()
+
+		// A peer that resets the connection fails the probe.
+		dead, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = dead.Write([]byte("dead"))
+		require.NoError(t.tester, err)
+		_ = dead.(*net.TCPConn).SetLinger(0)
+		_ = dead.Close()
+	}()
+	return
+}
+
+func (t *testProbeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "live":
+		assert.NoError(t.tester, c.Probe())
+	case "dead":
+		// Wait for the reset to arrive.
+		time.Sleep(100 * time.Millisecond)
+		assert.Error(t.tester, c.Probe())
+	}
+	return
+}
+
+func (t *testProbeServer) OnClosed(c Conn, err error) (action Action) {
+	if t.closed++; t.closed == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testProbe(t *testing.T, network, addr string) {
+	events := &testProbeServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithProbeFrame([]byte("ping")))
+	assert.NoError(t, err)
+}
+
+func TestSetCodec(t *testing.T) {
+	testSetCodec(t, "tcp", ":9809")
+}
+
+type testSetCodecServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testSetCodecServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		// The upgrade request and the first frame of the new protocol arrive in a single packet.
+		_, err = c.Write([]byte("UPGRADE\n\x00\x05hello"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 7)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "\x00\x05hello", string(buf))
+
+		_, err = c.Write([]byte("\x00\x05world"))
+		require.NoError(t.tester, err)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "\x00\x05world", string(buf))
+	}()
+	return
+}
+
+func (t *testSetCodecServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "UPGRADE" {
+		c.SetCodec(NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+		))
+		return
+	}
+	out = append([]byte(nil), frame...)
+	return
+}
+
+func (t *testSetCodecServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testSetCodec(t *testing.T, network, addr string) {
+	events := &testSetCodecServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestSocketControl(t *testing.T) {
+	testSocketControl(t, "tcp", ":9810")
+}
+
+type testSocketControlServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	listened      int32
+	accepted      int32
+}
+
+func (t *testSocketControlServer) OnInitComplete(svr Server) (action Action) {
+	assert.EqualValues(t.tester, 1, atomic.LoadInt32(&t.listened))
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		_ = c.Close()
+
+		// The connection is closed if the control function fails.
+		refused, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_ = refused.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = refused.Read(buf)
+		assert.Error(t.tester, err)
+		_ = refused.Close()
+		assert.EqualValues(t.tester, 2, atomic.LoadInt32(&t.accepted))
+
+		require.NoError(t.tester, Stop(context.Background(), t.network+"://"+t.addr))
+	}()
+	return
+}
+
+func (t *testSocketControlServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func testSocketControl(t *testing.T, network, addr string) {
+	events := &testSocketControlServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr,
+		WithSocketControl(func(fd uintptr) error {
+			atomic.AddInt32(&events.listened, 1)
+			return nil
+		}),
+		WithAcceptedSocketControl(func(fd uintptr) error {
+			if atomic.AddInt32(&events.accepted, 1) > 1 {
+				return fmt.Errorf("refuse connection")
+			}
+			return nil
+		}))
+	assert.NoError(t, err)
+}
+
+func TestOutboundLength(t *testing.T) {
+	testOutboundLength(t, "tcp", ":9811")
+}
+
+type testOutboundLengthServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	pending       int
+}
+
+func (t *testOutboundLengthServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("big"))
+		require.NoError(t.tester, err)
+		time.Sleep(100 * time.Millisecond)
+		_, err = c.Write([]byte("len"))
+		require.NoError(t.tester, err)
+		time.Sleep(100 * time.Millisecond)
+		_, err = io.ReadFull(c, make([]byte, 16<<20))
+		assert.NoError(t.tester, err)
+		_ = c.Close()
+	}()
+	return
+}
+
+func (t *testOutboundLengthServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "big" {
+		out = make([]byte, 16<<20)
+		return
+	}
+	assert.Zero(t.tester, c.BufferLength())
+	t.pending = c.OutboundLength()
+	return
+}
+
+func (t *testOutboundLengthServer) OnClosed(c Conn, err error) (action Action) {
+	assert.Greater(t.tester, t.pending, 0)
+	return Shutdown
+}
+
+func testOutboundLength(t *testing.T, network, addr string) {
+	events := &testOutboundLengthServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestDedicatedAcceptLoop(t *testing.T) {
+	testDedicatedAcceptLoop(t, "tcp", ":9812")
+}
+
+type testDedicatedAcceptLoopServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	ticked        int32
+}
+
+func (t *testDedicatedAcceptLoopServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		for !svr.Healthy() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for i := 0; i < 4; i++ {
+			if i == 2 {
+				// The main reactor serves ReusePort servers as well, which makes them scalable.
+				assert.NoError(t.tester, svr.ScaleEventLoops(2))
+			}
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(c, buf)
+			assert.NoError(t.tester, err)
+			assert.Equal(t.tester, "ping", string(buf))
+			_ = c.Close()
+		}
+		assert.NotZero(t.tester, atomic.LoadInt32(&t.ticked))
+		require.NoError(t.tester, Stop(context.Background(), t.network+"://"+t.addr))
+	}()
+	return
+}
+
+func (t *testDedicatedAcceptLoopServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testDedicatedAcceptLoopServer) Tick() (delay time.Duration, action Action) {
+	atomic.AddInt32(&t.ticked, 1)
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testDedicatedAcceptLoop(t *testing.T, network, addr string) {
+	events := &testDedicatedAcceptLoopServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithDedicatedAcceptLoop(true), WithReusePort(true),
+		WithNumEventLoop(4), WithTicker(true), WithTCPKeepAlive(time.Minute))
+	assert.NoError(t, err)
+}
+
+func TestOpenedAt(t *testing.T) {
+	testOpenedAt(t, "tcp", ":9813")
+}
+
+type testOpenedAtServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	dialed        time.Time
+}
+
+func (t *testOpenedAtServer) OnInitComplete(svr Server) (action Action) {
+	t.dialed = time.Now()
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		time.Sleep(50 * time.Millisecond)
+		_ = c.Close()
+	}()
+	return
+}
+
+func (t *testOpenedAtServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The time of accepting the connection rather than the time of the first read.
+	assert.False(t.tester, c.OpenedAt().Before(t.dialed))
+	assert.True(t.tester, c.OpenedAt().Before(time.Now()))
+	return
+}
+
+func (t *testOpenedAtServer) OnClosed(c Conn, err error) (action Action) {
+	assert.True(t.tester, time.Since(c.OpenedAt()) >= 50*time.Millisecond)
+	return Shutdown
+}
+
+func testOpenedAt(t *testing.T, network, addr string) {
+	events := &testOpenedAtServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestMaxConnAge(t *testing.T) {
+	testMaxConnAge(t, "tcp", ":9814")
+}
+
+type testMaxConnAgeServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testMaxConnAgeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		start := time.Now()
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		data, err := ioutil.ReadAll(c)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "reconnect", string(data))
+		assert.True(t.tester, time.Since(start) >= 100*time.Millisecond)
+	}()
+	return
+}
+
+func (t *testMaxConnAgeServer) OnClosed(c Conn, err error) (action Action) {
+	assert.NoError(t.tester, err)
+	assert.Equal(t.tester, CloseLocal, c.CloseCause())
+	return Shutdown
+}
+
+func testMaxConnAge(t *testing.T, network, addr string) {
+	events := &testMaxConnAgeServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMaxConnAge(100*time.Millisecond),
+		WithMaxConnAgeFrame([]byte("reconnect")))
+	assert.NoError(t, err)
+}
+
+func TestCloseGracefully(t *testing.T) {
+	testCloseGracefully(t, "tcp", ":9815")
+}
+
+type testCloseGracefullyServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       []string
+	errs          []error
+}
+
+func (t *testCloseGracefullyServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// The peer acknowledges the goodbye.
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("bye\n"))
+		require.NoError(t.tester, err)
+		r := bufio.NewReader(c)
+		line, err := r.ReadString('\n')
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "goodbye\n", line)
+		_, err = c.Write([]byte("data\nack\n"))
+		require.NoError(t.tester, err)
+		_, err = r.ReadByte()
+		assert.Equal(t.tester, io.EOF, err)
+		_ = c.Close()
+
+		// The peer ignores the goodbye.
+		c, err = net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		_, err = c.Write([]byte("bye\n"))
+		require.NoError(t.tester, err)
+		_, err = ioutil.ReadAll(c)
+		assert.NoError(t.tester, err)
+		_ = c.Close()
+	}()
+	return
+}
+
+func (t *testCloseGracefullyServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.reacted = append(t.reacted, string(frame))
+	_ = c.CloseGracefully([]byte("goodbye"), func(frame []byte) bool {
+		return string(frame) == "ack"
+	}, 100*time.Millisecond)
+	return
+}
+
+func (t *testCloseGracefullyServer) OnClosed(c Conn, err error) (action Action) {
+	if t.errs = append(t.errs, err); len(t.errs) == 2 {
+		assert.Equal(t.tester, []string{"bye", "bye"}, t.reacted)
+		assert.Equal(t.tester, []error{nil, errors.ErrCloseHandshakeTimeout}, t.errs)
+		action = Shutdown
+	}
+	return
+}
+
+func testCloseGracefully(t *testing.T, network, addr string) {
+	events := &testCloseGracefullyServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestStreamDecompression(t *testing.T) {
+	testStreamDecompression(t, "tcp", ":9816")
+}
+
+type testStreamDecompressionServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       []string
+}
+
+func (t *testStreamDecompressionServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+
+		// The compressed data follows the negotiation right away.
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte("hello\n"))
+		_ = gz.Flush()
+		_, err = c.Write(append([]byte("compress\n"), buf.Bytes()...))
+		require.NoError(t.tester, err)
+		buf.Reset()
+		_, _ = gz.Write([]byte("world\nagain\n"))
+		_ = gz.Flush()
+		_, err = c.Write(buf.Bytes())
+		require.NoError(t.tester, err)
+
+		r := bufio.NewReader(c)
+		for _, expected := range []string{"hello\n", "world\n", "again\n"} {
+			line, err := r.ReadString('\n')
+			assert.NoError(t.tester, err)
+			assert.Equal(t.tester, expected, line)
+		}
+
+		// The corrupted stream closes the connection.
+		buf.Reset()
+		_ = gz.Close()
+		_, err = c.Write(append(buf.Bytes(), "not a gzip member"...))
+		require.NoError(t.tester, err)
+		_, err = r.ReadByte()
+		assert.Equal(t.tester, io.EOF, err)
+	}()
+	return
+}
+
+func (t *testStreamDecompressionServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.reacted = append(t.reacted, string(frame))
+	if string(frame) == "compress" {
+		assert.Equal(t.tester, errors.ErrUnsupportedCompression, c.EnableStreamDecompression(Compression(0)))
+		assert.NoError(t.tester, c.EnableStreamDecompression(CompressionGzip))
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testStreamDecompressionServer) OnClosed(c Conn, err error) (action Action) {
+	assert.Equal(t.tester, []string{"compress", "hello", "world", "again"}, t.reacted)
+	assert.Equal(t.tester, gzip.ErrHeader, err)
+	return Shutdown
+}
+
+func testStreamDecompression(t *testing.T, network, addr string) {
+	events := &testStreamDecompressionServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestStreamDecompressionLimit(t *testing.T) {
+	testStreamDecompressionLimit(t, "tcp", ":9839")
+}
+
+type testStreamDecompressionLimitServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testStreamDecompressionLimitServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+
+		// A small stream that inflates to a huge amount of data.
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write(make([]byte, 16*1024*1024))
+		_ = gz.Close()
+		_, err = c.Write(append([]byte("compress\n"), buf.Bytes()...))
+		require.NoError(t.tester, err)
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = c.Read(make([]byte, 1))
+		assert.Error(t.tester, err)
+	}()
+	return
+}
+
+func (t *testStreamDecompressionLimitServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.Equal(t.tester, "compress", string(frame))
+	assert.NoError(t.tester, c.EnableStreamDecompression(CompressionGzip))
+	return
+}
+
+func (t *testStreamDecompressionLimitServer) OnClosed(c Conn, err error) (action Action) {
+	assert.ErrorIs(t.tester, err, errors.ErrDecompressionLimit)
+	return Shutdown
+}
+
+func testStreamDecompressionLimit(t *testing.T, network, addr string) {
+	events := &testStreamDecompressionLimitServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestServerWriteTo(t *testing.T) {
+	testServerWriteTo(t, "tcp", ":9817")
+}
+
+type testServerWriteToServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	opened        chan uint64
+}
+
+func (t *testServerWriteToServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		id := <-t.opened
+		assert.NoError(t.tester, svr.WriteTo(id, []byte("pushed")))
+		buf := make([]byte, 6)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "pushed", string(buf))
+		_ = c.Close()
+
+		for svr.CountConnections() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t.tester, errors.ErrConnNotFound, svr.WriteTo(id, []byte("pushed")))
+
+		c, err = net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		<-t.opened
+		_, err = c.Write([]byte("shutdown"))
+		assert.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testServerWriteToServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c.ID()
+	return
+}
+
+func (t *testServerWriteToServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return nil, Shutdown
+}
+
+func testServerWriteTo(t *testing.T, network, addr string) {
+	svr := Server{svr: &server{opts: &Options{}}}
+	assert.Equal(t, errors.ErrConnRegistryDisabled, svr.WriteTo(1, []byte("pushed")))
+
+	events := &testServerWriteToServer{tester: t, network: network, addr: addr, opened: make(chan uint64, 1)}
+	err := Serve(events, network+"://"+addr, WithConnRegistry(true))
+	assert.NoError(t, err)
+}
+
+func TestInboundRateLimit(t *testing.T) {
+	testInboundRateLimit(t, "tcp", ":9818")
+}
+
+type testInboundRateLimitServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	limited       int
+	strict        bool
+}
+
+func (t *testInboundRateLimitServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+
+		// The frames beyond the burst are throttled to the rate.
+		start := time.Now()
+		_, err = c.Write([]byte("1\n2\n3\n4\n5\n6\n"))
+		require.NoError(t.tester, err)
+		r := bufio.NewReader(c)
+		for i := 1; i <= 6; i++ {
+			line, err := r.ReadString('\n')
+			assert.NoError(t.tester, err)
+			assert.Equal(t.tester, fmt.Sprintf("%d\n", i), line)
+		}
+		assert.GreaterOrEqual(t.tester, int64(time.Since(start)), int64(150*time.Millisecond))
+
+		// The repeat offender is closed.
+		_, err = c.Write([]byte("strict\n7\n8\n9\n10\n"))
+		require.NoError(t.tester, err)
+		_, err = ioutil.ReadAll(r)
+		assert.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testInboundRateLimitServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetInboundRateLimit(20, 2)
+	return
+}
+
+func (t *testInboundRateLimitServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "strict" {
+		t.strict = true
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testInboundRateLimitServer) OnRateLimited(c Conn) (action Action) {
+	t.limited++
+	if t.strict {
+		action = Close
+	}
+	return
+}
+
+func (t *testInboundRateLimitServer) OnClosed(c Conn, err error) (action Action) {
+	assert.GreaterOrEqual(t.tester, t.limited, 2)
+	assert.Equal(t.tester, errors.ErrInboundRateLimited, err)
+	return Shutdown
+}
+
+func testInboundRateLimit(t *testing.T, network, addr string) {
+	events := &testInboundRateLimitServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestNoInboundBuffer(t *testing.T) {
+	testNoInboundBuffer(t, "tcp", ":9819")
+}
+
+type testNoInboundBufferServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testNoInboundBufferServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		// The data of the read reaches React without waiting for the delimiter of the codec.
+		_, err = c.Write([]byte("partial"))
+		require.NoError(t.tester, err)
+		line, err := bufio.NewReader(c).ReadString('\n')
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "partial\n", line)
+	}()
+	return
+}
+
+func (t *testNoInboundBufferServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.Equal(t.tester, 0, c.BufferLength())
+	return append([]byte(nil), frame...), Shutdown
+}
+
+func testNoInboundBuffer(t *testing.T, network, addr string) {
+	events := &testNoInboundBufferServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)), WithNoInboundBuffer(true))
+	assert.NoError(t, err)
+}
+
+func TestAllConnectionsClosed(t *testing.T) {
+	testAllConnectionsClosed(t, "tcp", ":9820")
+}
+
+type testAllConnectionsClosedServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        int32
+}
+
+func (t *testAllConnectionsClosedServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 3; i++ {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, c)
+		}
+		for svr.CountConnections() < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	return
+}
+
+func (t *testAllConnectionsClosedServer) OnClosed(c Conn, err error) (action Action) {
+	atomic.AddInt32(&t.closed, 1)
+	return
+}
+
+func (t *testAllConnectionsClosedServer) OnAllConnectionsClosed() (action Action) {
+	assert.EqualValues(t.tester, 3, atomic.LoadInt32(&t.closed))
+	return Shutdown
+}
+
+func testAllConnectionsClosed(t *testing.T, network, addr string) {
+	events := &testAllConnectionsClosedServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMulticore(true))
+	assert.NoError(t, err)
+}
+
+func TestConnWrite(t *testing.T) {
+	testConnWrite(t, "tcp", ":9821")
+}
+
+type testConnWriteServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	payload       []byte
+}
+
+func (t *testConnWriteServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("write\n"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, len("small\n")+len(t.payload)+1)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "small\n", string(buf[:6]))
+		_, err = c.Write([]byte("done\n"))
+		require.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testConnWriteServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "done" {
+		return nil, Shutdown
+	}
+	n, flushed, err := c.Write([]byte("small"))
+	assert.NoError(t.tester, err)
+	assert.Equal(t.tester, 6, n)
+	assert.True(t.tester, flushed)
+
+	// The socket can't take all of the large payload at once.
+	n, flushed, err = c.Write(t.payload)
+	assert.NoError(t.tester, err)
+	assert.Less(t.tester, n, len(t.payload)+1)
+	assert.False(t.tester, flushed)
+	assert.Equal(t.tester, len(t.payload)+1-n, c.OutboundBuffered())
+	return
+}
+
+func testConnWrite(t *testing.T, network, addr string) {
+	events := &testConnWriteServer{tester: t, network: network, addr: addr, payload: make([]byte, 16<<20)}
+	err := Serve(events, network+"://"+addr, WithCodec(NewLineCodec(false)))
+	assert.NoError(t, err)
+}
+
+func TestShutdownGrace(t *testing.T) {
+	testShutdownGrace(t, "tcp", ":9822")
+}
+
+type testShutdownGraceServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	payload       []byte
+	clients       sync.WaitGroup
+}
+
+func (t *testShutdownGraceServer) OnInitComplete(svr Server) (action Action) {
+	idle, err := net.Dial(t.network, t.addr)
+	require.NoError(t.tester, err)
+	c, err := net.Dial(t.network, t.addr)
+	require.NoError(t.tester, err)
+	t.clients.Add(2)
+	expect := func(c net.Conn, data []byte) {
+		defer t.clients.Done()
+		defer c.Close()
+		buf, err := ioutil.ReadAll(c)
+		assert.NoError(t.tester, err)
+		assert.True(t.tester, bytes.Equal(data, buf), "unexpected data of %d bytes", len(buf))
+	}
+	go expect(idle, []byte("bye\n"))
+	go func() {
+		for svr.CountConnections() < 2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err := c.Write([]byte("shutdown"))
+		require.NoError(t.tester, err)
+		// The data returned by React goes ahead of the shutdown frame.
+		expect(c, append(append([]byte(nil), t.payload...), "bye\n"...))
+	}()
+	return
+}
+
+func (t *testShutdownGraceServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return t.payload, Shutdown
+}
+
+func testShutdownGrace(t *testing.T, network, addr string) {
+	payload := make([]byte, 16<<20)
+	rand.Read(payload)
+	events := &testShutdownGraceServer{tester: t, network: network, addr: addr, payload: payload}
+	err := Serve(events, network+"://"+addr, WithMulticore(true), WithShutdownGrace(5*time.Second),
+		WithShutdownFrame([]byte("bye\n")))
+	assert.NoError(t, err)
+	events.clients.Wait()
+}
+
+func TestLoopBacklog(t *testing.T) {
+	testLoopBacklog(t, "tcp", ":9823")
+}
+
+type testLoopBacklogServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	svr           Server
+}
+
+func (t *testLoopBacklogServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("block"))
+		require.NoError(t.tester, err)
+		_, err = c.Read(make([]byte, 1))
+		assert.Equal(t.tester, io.EOF, err)
+	}()
+	return
+}
+
+func (t *testLoopBacklogServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// Queue up tasks while the event-loop is busy.
+	for i := 0; i < 3; i++ {
+		_ = c.Wake()
+	}
+	assert.Equal(t.tester, []int{3}, t.svr.LoopBacklog())
+	action = Shutdown
+	return
+}
+
+func testLoopBacklog(t *testing.T, network, addr string) {
+	events := &testLoopBacklogServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestOutboundOverflow(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the writes are not buffered on Windows")
+	}
+	t.Run("reject", func(t *testing.T) {
+		testOutboundOverflow(t, "tcp", ":9824", RejectOverflow)
+	})
+	t.Run("stream", func(t *testing.T) {
+		testOutboundOverflow(t, "tcp", ":9825", StreamOverflow)
+	})
+}
+
+type testOutboundOverflowServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	overflow      OutboundOverflow
+	payload       []byte
+}
+
+func (t *testOutboundOverflowServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("x"))
+		require.NoError(t.tester, err)
+		if t.overflow == RejectOverflow {
+			line, err := bufio.NewReader(c).ReadString('\n')
+			assert.NoError(t.tester, err)
+			assert.Equal(t.tester, "rejected\n", line)
+			return
+		}
+		// Read slowly at first to have the socket fill up and the data held back.
+		time.Sleep(100 * time.Millisecond)
+		buf := make([]byte, len(t.payload))
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.True(t.tester, bytes.Equal(t.payload, buf), "the streamed data is corrupted")
+	}()
+	return
+}
+
+func (t *testOutboundOverflowServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	n, flushed, err := c.Write(t.payload)
+	if t.overflow == RejectOverflow {
+		assert.ErrorIs(t.tester, err, errors.ErrWriteBufferOverflow)
+		assert.Zero(t.tester, n)
+		assert.False(t.tester, flushed)
+		return []byte("rejected\n"), None
+	}
+	assert.NoError(t.tester, err)
+	assert.False(t.tester, flushed)
+	assert.Equal(t.tester, len(t.payload), n+c.OutboundLength())
+	assert.LessOrEqual(t.tester, c.OutboundBuffered(), 64*1024)
+	return
+}
+
+func (t *testOutboundOverflowServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testOutboundOverflow(t *testing.T, network, addr string, overflow OutboundOverflow) {
+	payload := make([]byte, 16*1024*1024)
+	_, _ = rand.Read(payload)
+	events := &testOutboundOverflowServer{tester: t, network: network, addr: addr, overflow: overflow, payload: payload}
+	err := Serve(events, network+"://"+addr, WithMaxOutboundBuffer(64*1024), WithOutboundOverflow(overflow))
+	assert.NoError(t, err)
+}
+
+func TestReactOverflow(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("MaxOutboundBuffer is ignored on Windows")
+	}
+	testReactOverflow(t, "tcp", ":9838")
+}
+
+type testReactOverflowServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testReactOverflowServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		// The reply that doesn't fit is dropped and the connection keeps being served.
+		_, err = c.Write([]byte("big"))
+		require.NoError(t.tester, err)
+		time.Sleep(50 * time.Millisecond)
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t.tester, err)
+		assert.Equal(t.tester, "pong", string(buf))
+
+		// The action is taken even if the reply is dropped.
+		_, err = c.Write([]byte("bye"))
+		require.NoError(t.tester, err)
+		n, err := c.Read(buf)
+		assert.Zero(t.tester, n)
+		assert.ErrorIs(t.tester, err, io.EOF)
+	}()
+	return
+}
+
+func (t *testReactOverflowServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "big":
+		return make([]byte, 4096), None
+	case "bye":
+		return make([]byte, 4096), Close
+	}
+	return []byte("pong"), None
+}
+
+func (t *testReactOverflowServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testReactOverflow(t *testing.T, network, addr string) {
+	errCh := make(chan error, 8)
+	events := &testReactOverflowServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMaxOutboundBuffer(1024), WithErrChan(errCh))
+	assert.NoError(t, err)
+	require.Len(t, errCh, 2)
+	assert.ErrorIs(t, <-errCh, errors.ErrWriteBufferOverflow)
+	assert.ErrorIs(t, <-errCh, errors.ErrWriteBufferOverflow)
+}
+
+func TestConnResetStats(t *testing.T) {
+	testConnResetStats(t, "tcp", ":9826")
+}
+
+type testConnResetStatsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       int
+}
+
+func (t *testConnResetStatsServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		buf := make([]byte, 4)
+		for i := 0; i < 2; i++ {
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t.tester, err)
+		}
+	}()
+	return
+}
+
+func (t *testConnResetStatsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.reacted++
+	stats := c.ResetStats()
+	assert.EqualValues(t.tester, 4, stats.BytesRead)
+	assert.EqualValues(t.tester, 1, stats.FramesRead)
+	if t.reacted == 1 {
+		assert.Zero(t.tester, stats.BytesWritten)
+		assert.Zero(t.tester, stats.FramesWritten)
+		return []byte("pong"), None
+	}
+	// Only the traffic since the last reset is counted.
+	assert.EqualValues(t.tester, 4, stats.BytesWritten)
+	assert.EqualValues(t.tester, 1, stats.FramesWritten)
+	assert.Equal(t.tester, Stats{}, c.Stats())
+	return []byte("pong"), Shutdown
+}
+
+func testConnResetStats(t *testing.T, network, addr string) {
+	events := &testConnResetStatsServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestDrainLoop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("draining event-loops is not supported on Windows")
+	}
+	testDrainLoop(t, "tcp", ":9827")
+}
+
+type testDrainLoopServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func loopConns(svr Server) (counts []int32) {
+	svr.svr.lb.iterate(func(i int, el *eventloop) bool {
+		counts = append(counts, el.loadConn())
+		return true
+	})
+	return
+}
+
+func (t *testDrainLoopServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 4; i++ {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, c)
+		}
+		for svr.CountConnections() < 4 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t.tester, []int32{2, 2}, loopConns(svr))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.EqualError(t.tester, svr.DrainLoop(ctx, 2), errors.ErrInvalidLoopIndex.Error())
+		require.NoError(t.tester, svr.DrainLoop(ctx, 0))
+		assert.Equal(t.tester, []int32{0, 4}, loopConns(svr))
+		assert.EqualError(t.tester, svr.DrainLoop(ctx, 1), errors.ErrLastEventLoop.Error())
+
+		// The drained event-loop receives no new connections.
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		conns = append(conns, c)
+		for svr.CountConnections() < 5 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t.tester, []int32{0, 5}, loopConns(svr))
+
+		// The migrated connections keep working.
+		buf := make([]byte, 4)
+		for _, c := range conns {
+			_, err = c.Write([]byte("ping"))
+			require.NoError(t.tester, err)
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t.tester, err)
+			assert.Equal(t.tester, "ping", string(buf))
+		}
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	return
+}
+
+func (t *testDrainLoopServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return append([]byte(nil), frame...), None
+}
+
+func (t *testDrainLoopServer) OnAllConnectionsClosed() (action Action) {
+	return Shutdown
+}
+
+func testDrainLoop(t *testing.T, network, addr string) {
+	events := &testDrainLoopServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithNumEventLoop(2))
+	assert.NoError(t, err)
+}
+
+func TestServeAsync(t *testing.T) {
+	protoAddr := "tcp://:9828"
+	done, err := ServeAsync(new(EventServer), protoAddr)
+	require.NoError(t, err)
+
+	// The listener is bound by the time ServeAsync returns.
+	c, err := net.Dial("tcp", ":9828")
+	require.NoError(t, err)
+	_ = c.Close()
+
+	// Binding errors are returned right away rather than through the channel.
+	_, err = ServeAsync(new(EventServer), protoAddr)
+	assert.Error(t, err)
+
+	require.NoError(t, Stop(context.Background(), protoAddr))
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the server to shut down")
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	testWriteBatch(t, "tcp", ":9829")
+}
+
+type testWriteBatchServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	ended         int32
+}
+
+func (t *testWriteBatchServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("go\n"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 6)
+		_, err = io.ReadFull(c, buf[:1])
+		require.NoError(t.tester, err)
+		assert.EqualValues(t.tester, 1, atomic.LoadInt32(&t.ended), "the batch is sent before EndBatch")
+		_, err = io.ReadFull(c, buf[1:])
+		require.NoError(t.tester, err)
+		assert.Equal(t.tester, "a\nb\nc\n", string(buf))
+	}()
+	return
+}
+
+func (t *testWriteBatchServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	go func() {
+		require.NoError(t.tester, c.BeginBatch())
+		for _, data := range []string{"a", "b", "c"} {
+			require.NoError(t.tester, c.AsyncWrite([]byte(data)))
+		}
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&t.ended, 1)
+		require.NoError(t.tester, c.EndBatch())
+	}()
+	return
+}
+
+func (t *testWriteBatchServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testWriteBatch(t *testing.T, network, addr string) {
+	events := &testWriteBatchServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)))
+	assert.NoError(t, err)
+}
+
+func TestWriteBatchInCallback(t *testing.T) {
+	testWriteBatchInCallback(t, "tcp", ":9840")
+}
+
+type testWriteBatchInCallbackServer struct {
+	ended int32 // updated atomically, keep it first for the alignment on 32-bit platforms
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testWriteBatchInCallbackServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("go\n"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf[:1])
+		require.NoError(t.tester, err)
+		assert.EqualValues(t.tester, 1, atomic.LoadInt32(&t.ended), "the output of React is sent before EndBatch")
+		_, err = io.ReadFull(c, buf[1:])
+		require.NoError(t.tester, err)
+		assert.Equal(t.tester, "a\nb\n", string(buf))
+	}()
+	return
+}
+
+func (t *testWriteBatchInCallbackServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.NoError(t.tester, c.BeginBatch())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&t.ended, 1)
+		assert.NoError(t.tester, c.AsyncWrite([]byte("b")))
+		assert.NoError(t.tester, c.EndBatch())
+	}()
+	return []byte("a"), None
+}
+
+func (t *testWriteBatchInCallbackServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testWriteBatchInCallback(t *testing.T, network, addr string) {
+	events := &testWriteBatchInCallbackServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(new(LineBasedFrameCodec)))
+	assert.NoError(t, err)
+}
+
+func TestServerOptions(t *testing.T) {
+	testServerOptions(t, "tcp", ":9830")
+}
+
+type testServerOptionsServer struct {
+	*EventServer
+	tester *testing.T
+}
+
+func (t *testServerOptionsServer) OnInitComplete(svr Server) (action Action) {
+	opts := svr.Options()
+	assert.Equal(t.tester, 3, opts.NumEventLoop)
+	assert.Equal(t.tester, 1024, opts.ReadBufferCap)
+	assert.Equal(t.tester, 1024, opts.UDPMaxDatagramSize)
+	assert.IsType(t.tester, new(BuiltInFrameCodec), opts.Codec)
+	assert.NotNil(t.tester, opts.Logger)
+	assert.True(t.tester, opts.Multicore)
+	assert.False(t.tester, opts.ReusePort)
+	return Shutdown
+}
+
+func testServerOptions(t *testing.T, network, addr string) {
+	events := &testServerOptionsServer{tester: t}
+	err := Serve(events, network+"://"+addr, WithMulticore(true), WithNumEventLoop(3), WithReadBufferCap(1000))
+	assert.NoError(t, err)
+
+	// The main reactor accepts the connections with DedicatedAcceptLoop in spite of ReusePort.
+	err = Serve(events, network+"://"+addr, WithMulticore(true), WithNumEventLoop(3), WithReadBufferCap(1000),
+		WithReusePort(true), WithDedicatedAcceptLoop(true))
+	assert.NoError(t, err)
+}
+
+func TestAcceptBatching(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("accept batching is not supported on Windows")
+	}
+	testAcceptBatching(t, "tcp", ":9831")
+}
+
+type testAcceptBatchingServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testAcceptBatchingServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		dial := func() {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, c)
+		}
+		// A full batch is handed over right away.
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			dial()
+		}
+		for svr.CountConnections() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Less(t.tester, int64(time.Since(start)), int64(200*time.Millisecond))
+
+		// A partial batch waits for the delay.
+		start = time.Now()
+		dial()
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t.tester, 3, svr.CountConnections())
+		for svr.CountConnections() < 4 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.GreaterOrEqual(t.tester, int64(time.Since(start)), int64(200*time.Millisecond))
+
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	return
+}
+
+func (t *testAcceptBatchingServer) OnAllConnectionsClosed() (action Action) {
+	return Shutdown
+}
+
+func testAcceptBatching(t *testing.T, network, addr string) {
+	events := &testAcceptBatchingServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithAcceptBatching(3, 200*time.Millisecond))
+	assert.NoError(t, err)
+}
+
+func TestAcceptBatchingLeastConnections(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("accept batching is not supported on Windows")
+	}
+	testAcceptBatchingLeastConnections(t, "tcp", ":9841")
+}
+
+type testAcceptBatchingLeastConnectionsServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testAcceptBatchingLeastConnectionsServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// The sockets staged for an event-loop count as its connections, thus a burst is spread across event-loops.
+		var conns []net.Conn
+		for i := 0; i < 4; i++ {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			conns = append(conns, c)
+		}
+		for svr.CountConnections() < 4 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(t.tester, []int32{2, 2}, loopConns(svr))
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	return
+}
+
+func (t *testAcceptBatchingLeastConnectionsServer) OnAllConnectionsClosed() (action Action) {
+	return Shutdown
+}
+
+func testAcceptBatchingLeastConnections(t *testing.T, network, addr string) {
+	events := &testAcceptBatchingLeastConnectionsServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithMulticore(true), WithNumEventLoop(2),
+		WithLoadBalancing(LeastConnections), WithAcceptBatching(4, 50*time.Millisecond))
+	assert.NoError(t, err)
+}
+
+func BenchmarkAcceptBatching(b *testing.B) {
+	if runtime.GOOS == "windows" {
+		b.Skip("accept batching is not supported on Windows")
+	}
+	b.Run("unbatched", func(b *testing.B) { benchmarkAccept(b, 0) })
+	b.Run("batched", func(b *testing.B) { benchmarkAccept(b, 0, WithAcceptBatching(16, time.Millisecond)) })
+}
+
+func BenchmarkDedicatedAcceptLoop(b *testing.B) {
+	if runtime.GOOS == "windows" {
+		b.Skip("DedicatedAcceptLoop has no effect on Windows")
+	}
+	b.Run("shared", func(b *testing.B) { benchmarkAccept(b, 64, WithTicker(true)) })
+	b.Run("dedicated", func(b *testing.B) { benchmarkAccept(b, 64, WithTicker(true), WithDedicatedAcceptLoop(true)) })
+}
+
+type benchmarkAcceptServer struct {
+	*EventServer
+	addr chan string
+}
+
+func (s *benchmarkAcceptServer) OnInitComplete(svr Server) (action Action) {
+	s.addr <- svr.Addr.String()
+	return
+}
+
+func (s *benchmarkAcceptServer) Tick() (delay time.Duration, action Action) {
+	return time.Millisecond, None
+}
+
+func (s *benchmarkAcceptServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "shutdown" {
+		return nil, Shutdown
+	}
+	return frame, None
+}
+
+// benchmarkAccept measures the time from dialing a connection to its first response, accepting the connections
+// from concurrent clients while the given number of connections keep echoing 64KB messages, and reports the p99
+// of it along with the mean.
+func benchmarkAccept(b *testing.B, load int, opts ...Option) {
+	events := &benchmarkAcceptServer{addr: make(chan string, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(events, "tcp://127.0.0.1:0", append(opts, WithMulticore(true), WithLogLevel(zapcore.ErrorLevel))...)
+	}()
+	addr := <-events.addr
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < load; i++ {
+		c, err := net.Dial("tcp", addr)
+		require.NoError(b, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.Close()
+			msg, buf := bytes.Repeat([]byte{'x'}, 64*1024), make([]byte, 64*1024)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := c.Write(msg); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+	)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, 1)
+		var local []time.Duration
+		defer func() {
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}()
+		for pb.Next() {
+			start := time.Now()
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err = c.Write([]byte{'x'}); err == nil {
+				_, err = io.ReadFull(c, buf)
+			}
+			local = append(local, time.Since(start))
+			_ = c.Close()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	}
+
+	c, err := net.Dial("tcp", addr)
+	require.NoError(b, err)
+	_, _ = c.Write([]byte("shutdown"))
+	assert.NoError(b, <-done)
+	_ = c.Close()
+}
+
+func TestEventHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("EventHook is not supported on Windows")
+	}
+	testEventHook(t, "tcp", ":9832")
+}
+
+type testEventHookServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reads         int
+	reacted       int
+}
+
+func (t *testEventHookServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t.tester, err)
+		assert.Equal(t.tester, "ping", string(buf))
+
+		// The connection is closed by OnEvent on the next readable event without reaching React.
+		_, err = c.Write([]byte("pong"))
+		require.NoError(t.tester, err)
+		_, err = c.Read(buf)
+		assert.Error(t.tester, err)
+	}()
+	return
+}
+
+func (t *testEventHookServer) OnEvent(c Conn, events EventFlags) (action Action) {
+	if events&EventRead == 0 {
+		return
+	}
+	if t.reads++; t.reads > 1 {
+		return Close
+	}
+	return
+}
+
+func (t *testEventHookServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.reacted++
+	assert.Equal(t.tester, 1, t.reads)
+	return append([]byte(nil), frame...), None
+}
+
+func (t *testEventHookServer) OnClosed(c Conn, err error) (action Action) {
+	assert.Equal(t.tester, 1, t.reacted)
+	return Shutdown
+}
+
+func testEventHook(t *testing.T, network, addr string) {
+	events := &testEventHookServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestMaxPendingWrites(t *testing.T) {
+	testMaxPendingWrites(t, "tcp", ":9833")
+}
+
+type testMaxPendingWritesServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	payload       []byte
+}
+
+func (t *testMaxPendingWritesServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("go"))
+		require.NoError(t.tester, err)
+		// Hold off reading to keep the writes pending.
+		time.Sleep(100 * time.Millisecond)
+		buf := make([]byte, 2*len(t.payload)+1)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t.tester, err)
+		assert.Equal(t.tester, byte('x'), buf[len(buf)-1])
+	}()
+	return
+}
+
+func (t *testMaxPendingWritesServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetMaxPendingWrites(2)
+	return
+}
+
+func (t *testMaxPendingWritesServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	go func() {
+		require.NoError(t.tester, c.AsyncWrite(t.payload))
+		require.NoError(t.tester, c.AsyncWrite(t.payload))
+		assert.ErrorIs(t.tester, c.AsyncWrite([]byte("x")), errors.ErrTooManyPendingWrites)
+		// The writes are uncounted as they are flushed.
+		for {
+			err := c.AsyncWrite([]byte("x"))
+			if err == nil {
+				return
+			}
+			require.ErrorIs(t.tester, err, errors.ErrTooManyPendingWrites)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return
+}
+
+func (t *testMaxPendingWritesServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testMaxPendingWrites(t *testing.T, network, addr string) {
+	events := &testMaxPendingWritesServer{tester: t, network: network, addr: addr, payload: make([]byte, 16*1024*1024)}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestOnCleanup(t *testing.T) {
+	testOnCleanup(t, "tcp", ":9834")
+}
+
+type testOnCleanupServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	closed        bool
+	order         []int
+}
+
+func (t *testOnCleanupServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("bye"))
+		require.NoError(t.tester, err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testOnCleanupServer) OnOpened(c Conn) (out []byte, action Action) {
+	for i := 0; i < 3; i++ {
+		i := i
+		c.OnCleanup(func() {
+			assert.True(t.tester, t.closed, "cleanup ran ahead of OnClosed")
+			t.order = append(t.order, i)
+		})
+	}
+	return
+}
+
+func (t *testOnCleanupServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return nil, Shutdown
+}
+
+func (t *testOnCleanupServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed = true
+	return
+}
+
+func testOnCleanup(t *testing.T, network, addr string) {
+	events := &testOnCleanupServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 0}, events.order, "cleanups ought to run once in LIFO order during shutdown")
+}
+
+func TestOnCleanupStop(t *testing.T) {
+	testOnCleanupStop(t, "tcp", ":9837")
+}
+
+type testOnCleanupStopServer struct {
+	cleaned int32 // updated atomically, keep it first for the alignment on 32-bit platforms
+	opened  int32
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	conns         int
+}
+
+func (t *testOnCleanupStopServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		for i := 0; i < t.conns; i++ {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer c.Close()
+		}
+		for start := time.Now(); atomic.LoadInt32(&t.opened) < int32(t.conns); time.Sleep(10 * time.Millisecond) {
+			require.Less(t.tester, int64(time.Since(start)), int64(5*time.Second), "connections aren't opened")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t.tester, Stop(ctx, t.network+"://"+t.addr))
+	}()
+	return
+}
+
+func (t *testOnCleanupStopServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.OnCleanup(func() { atomic.AddInt32(&t.cleaned, 1) })
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func testOnCleanupStop(t *testing.T, network, addr string) {
+	events := &testOnCleanupStopServer{tester: t, network: network, addr: addr, conns: 4}
+	err := Serve(events, network+"://"+addr, WithMulticore(true))
+	assert.NoError(t, err)
+	assert.EqualValues(t, events.conns, atomic.LoadInt32(&events.cleaned),
+		"cleanups ought to run for the connections closed by Stop")
+}
+
+func TestSetLoadBalancing(t *testing.T) {
+	testSetLoadBalancing(t, "tcp", ":9835")
+}
+
+type testSetLoadBalancingServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testSetLoadBalancingServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		waitConns := func(want []int32) {
+			for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+				if assert.ObjectsAreEqual(want, loopConns(svr)) {
+					return
+				}
+			}
+			assert.Equal(t.tester, want, loopConns(svr))
+		}
+		dial := func() net.Conn {
+			c, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			return c
+		}
+		c1, c2 := dial(), dial()
+		defer c1.Close()
+		waitConns([]int32{1, 1})
+		_ = c2.Close()
+		waitConns([]int32{1, 0})
+
+		assert.ErrorIs(t.tester, svr.SetLoadBalancing(LoadBalancing(42)), errors.ErrInvalidLoadBalancing)
+		require.NoError(t.tester, svr.SetLoadBalancing(LeastConnections))
+		assert.Equal(t.tester, LeastConnections, svr.Options().LB)
+		// Round-Robin would have assigned the connection to the first event-loop.
+		c3 := dial()
+		defer c3.Close()
+		waitConns([]int32{1, 1})
+
+		assert.ErrorIs(t.tester, svr.SetLoadBalancer(nil), errors.ErrInvalidLoadBalancing)
+		assert.ErrorIs(t.tester, svr.SetLoadBalancing(CustomLoadBalancing), errors.ErrInvalidLoadBalancing)
+		picker := &testLoadBalancer{}
+		require.NoError(t.tester, svr.SetLoadBalancer(picker))
+		assert.Equal(t.tester, CustomLoadBalancing, svr.Options().LB)
+		c4 := dial()
+		defer c4.Close()
+		waitConns([]int32{1, 2})
+		assert.Equal(t.tester, []int{1, 1}, picker.loads)
+		_ = Stop(context.Background(), t.network+"://"+t.addr)
+	}()
+	return
+}
+
+// testLoadBalancer assigns the connections to the last event-loop.
+type testLoadBalancer struct {
+	loads []int
+}
+
+func (lb *testLoadBalancer) Next(_ net.Addr, loads []int) int {
+	lb.loads = append([]int(nil), loads...)
+	return len(loads) - 1
+}
+
+func testSetLoadBalancing(t *testing.T, network, addr string) {
+	events := &testSetLoadBalancingServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithNumEventLoop(2), WithLoadBalancing(RoundRobin))
+	assert.NoError(t, err)
+}
+
+func TestReadDeadline(t *testing.T) {
+	testReadDeadline(t, "tcp", ":9836")
+}
+
+type testReadDeadlineServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	opened        time.Time
+	lived         time.Duration
+	err           error
+	cause         CloseCause
+}
+
+func (t *testReadDeadlineServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		// Keep sending data for longer than the deadline, then go idle.
+		for i := 0; i < 5; i++ {
+			_, err = c.Write([]byte("a"))
+			require.NoError(t.tester, err)
+			time.Sleep(40 * time.Millisecond)
+		}
+		_, err = c.Read(make([]byte, 1))
+		assert.Error(t.tester, err)
+	}()
+	return
+}
+
+func (t *testReadDeadlineServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened = time.Now()
+	// A cleared deadline never expires.
+	require.NoError(t.tester, c.SetReadDeadline(time.Now().Add(time.Millisecond)))
+	require.NoError(t.tester, c.SetReadDeadline(time.Time{}))
+	require.NoError(t.tester, c.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	return
+}
+
+func (t *testReadDeadlineServer) OnClosed(c Conn, err error) (action Action) {
+	t.lived, t.err, t.cause = time.Since(t.opened), err, c.CloseCause()
+	return Shutdown
+}
+
+func testReadDeadline(t *testing.T, network, addr string) {
+	events := &testReadDeadlineServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, events.err, errors.ErrReadTimeout)
+	assert.Equal(t, CloseTimeout, events.cause)
+	assert.GreaterOrEqual(t, int64(events.lived), int64(200*time.Millisecond), "the deadline ought to be pushed back by the data read")
+}
+
+func TestCloseBehavior(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the writes are not buffered on Windows")
+	}
+	t.Run("flush", func(t *testing.T) {
+		testCloseBehavior(t, "tcp", ":9844", FlushOnClose)
+	})
+	t.Run("discard", func(t *testing.T) {
+		testCloseBehavior(t, "tcp", ":9845", DiscardOnClose)
+	})
+	t.Run("linger", func(t *testing.T) {
+		testCloseBehavior(t, "tcp", ":9846", LingerClose)
+	})
+}
+
+type testCloseBehaviorServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	behavior      CloseBehavior
+	written       uint64
+	received      int
+	readErr       error
+	done          chan struct{}
+}
+
+func (t *testCloseBehaviorServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		defer close(t.done)
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("fill"))
+		require.NoError(t.tester, err)
+		// Have the socket fill up and the rest of the data held back in the outbound buffer.
+		time.Sleep(100 * time.Millisecond)
+		_, err = c.Write([]byte("close"))
+		require.NoError(t.tester, err)
+		// The event-loop is blocked until the data in the socket is read up, then the connection is closed
+		// while there is still data pending in the outbound buffer.
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := c.Read(buf)
+			t.received += n
+			if err != nil {
+				t.readErr = err
+				return
+			}
+		}
+	}()
+	return
+}
+
+func (t *testCloseBehaviorServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetCloseBehavior(t.behavior)
+	return
+}
+
+func (t *testCloseBehaviorServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "fill" {
+		_, flushed, err := c.Write(make([]byte, 32*1024*1024))
+		require.NoError(t.tester, err)
+		require.False(t.tester, flushed)
+		return
+	}
+	time.Sleep(300 * time.Millisecond)
+	require.NotZero(t.tester, c.OutboundBuffered())
+	t.written = c.Stats().BytesWritten
+	action = Close
+	return
+}
+
+func (t *testCloseBehaviorServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testCloseBehavior(t *testing.T, network, addr string, behavior CloseBehavior) {
+	events := &testCloseBehaviorServer{
+		tester:   t,
+		network:  network,
+		addr:     addr,
+		behavior: behavior,
+		done:     make(chan struct{}),
+	}
+	err := Serve(events, network+"://"+addr)
+	require.NoError(t, err)
+	<-events.done
+	switch behavior {
+	case FlushOnClose:
+		// The pending data is sent as much as the socket takes before closing.
+		assert.Greater(t, uint64(events.received), events.written)
+		assert.Equal(t, io.EOF, events.readErr)
+	case DiscardOnClose:
+		assert.EqualValues(t, events.written, events.received)
+		assert.Equal(t, io.EOF, events.readErr)
+	case LingerClose:
+		// The connection is aborted with a RST instead of a FIN.
+		assert.LessOrEqual(t, uint64(events.received), events.written)
+		assert.ErrorIs(t, events.readErr, syscall.ECONNRESET)
+	}
+}
+
+func TestMaxInboundBuffer(t *testing.T) {
+	testMaxInboundBuffer(t, "tcp", ":9847")
+}
+
+type testMaxInboundBufferServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	reacted       int32
+	err           error
+	cause         CloseCause
+}
+
+func (t *testMaxInboundBufferServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		// The partial line that follows the complete one keeps growing without producing a frame.
+		_, err = c.Write(append([]byte("ok\n"), bytes.Repeat([]byte("x"), 2048)...))
+		require.NoError(t.tester, err)
+		data, _ := ioutil.ReadAll(c)
+		require.Equal(t.tester, "ok\n", string(data))
+	}()
+	return
+}
+
+func (t *testMaxInboundBufferServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.reacted, 1)
+	out = frame
+	return
+}
+
+func (t *testMaxInboundBufferServer) OnClosed(c Conn, err error) (action Action) {
+	t.err, t.cause = err, c.CloseCause()
+	return Shutdown
+}
+
+func testMaxInboundBuffer(t *testing.T, network, addr string) {
+	events := &testMaxInboundBufferServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr, WithCodec(&LineBasedFrameCodec{}), WithMaxInboundBuffer(1024))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.reacted))
+	assert.ErrorIs(t, events.err, errors.ErrInboundBufferOverflow)
+	assert.Equal(t, CloseError, events.cause)
+}
+
+func TestCork(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("corking is not supported on Windows")
+	}
+	testCork(t, "tcp", ":9848")
+}
+
+type testCorkServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testCorkServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("begin"))
+		require.NoError(t.tester, err)
+		if runtime.GOOS == "linux" {
+			// The partial segment is held back in the kernel until the connection is uncorked.
+			_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			_, err = c.Read(make([]byte, 1))
+			ne, ok := err.(net.Error)
+			require.True(t.tester, ok && ne.Timeout(), "the corked data ought to be held back")
+			_ = c.SetReadDeadline(time.Time{})
+		}
+		_, err = c.Write([]byte("end"))
+		require.NoError(t.tester, err)
+		buf := make([]byte, len("header:body"))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "header:body", string(buf))
+	}()
+	return
+}
+
+func (t *testCorkServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "begin":
+		require.NoError(t.tester, c.Cork())
+		out = []byte("header:")
+	case "end":
+		_, _, err := c.Write([]byte("body"))
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, c.Uncork())
+	}
+	return
+}
+
+func (t *testCorkServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func testCork(t *testing.T, network, addr string) {
+	events := &testCorkServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestCongestionControl(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		testCongestionControl(t, "tcp", ":9849")
+	})
+	t.Run("udp", func(t *testing.T) {
+		testCongestionControl(t, "udp", ":9850")
+	})
+}
+
+type testCongestionControlServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+}
+
+func (t *testCongestionControlServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		_, err = c.Write([]byte("algo"))
+		require.NoError(t.tester, err)
+	}()
+	return
+}
+
+func (t *testCongestionControlServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	action = Shutdown
+	if t.network != "tcp" || runtime.GOOS != "linux" {
+		_, err := c.CongestionControl()
+		assert.ErrorIs(t.tester, err, errors.ErrUnsupportedOp)
+		assert.ErrorIs(t.tester, c.SetCongestionControl("cubic"), errors.ErrUnsupportedOp)
+		return
+	}
+	algo, err := c.CongestionControl()
+	assert.NoError(t.tester, err)
+	assert.NotEmpty(t.tester, algo)
+	// The algorithm in use is always available, the unknown one is rejected and leaves it intact.
+	assert.NoError(t.tester, c.SetCongestionControl(algo))
+	assert.Error(t.tester, c.SetCongestionControl("no-such-algorithm"))
+	got, err := c.CongestionControl()
+	assert.NoError(t.tester, err)
+	assert.Equal(t.tester, algo, got)
+	return
+}
+
+func testCongestionControl(t *testing.T, network, addr string) {
+	events := &testCongestionControlServer{tester: t, network: network, addr: addr}
+	err := Serve(events, network+"://"+addr)
+	assert.NoError(t, err)
+}
+
+func TestDNSCodecUDP(t *testing.T) {
+	testDNSCodecUDP(t, "udp", ":9852")
+}
+
+type testDNSCodecUDPServer struct {
+	*EventServer
+	tester        *testing.T
+	network, addr string
+	query         []byte
+	decodeErr     error
+}
+
+func (t *testDNSCodecUDPServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close()
+		// The datagram shorter than the DNS header is rejected by the codec before React.
+		_, err = c.Write(t.query[:dnsHeaderLen-1])
+		require.NoError(t.tester, err)
+		_, err = c.Write(t.query)
+		require.NoError(t.tester, err)
+		buf := make([]byte, 512)
+		_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := c.Read(buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, t.query, buf[:n])
+	}()
+	return
+}
+
+func (t *testDNSCodecUDPServer) OnDecodeError(c Conn, err error) (action Action) {
+	t.decodeErr = err
+	return
+}
+
+func (t *testDNSCodecUDPServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	assert.Equal(t.tester, t.query, frame)
+	return append([]byte(nil), frame...), Shutdown
+}
+
+func testDNSCodecUDP(t *testing.T, network, addr string) {
+	query := make([]byte, 32)
+	_, _ = rand.Read(query)
+	events := &testDNSCodecUDPServer{tester: t, network: network, addr: addr, query: query}
+	err := Serve(events, network+"://"+addr, WithCodec(NewDNSCodec()))
+	assert.NoError(t, err)
+	assert.Equal(t, errors.ErrInvalidDNSMessage, events.decodeErr)
+}
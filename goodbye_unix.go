@@ -0,0 +1,74 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"time"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+)
+
+// goodbye is the close handshake of a connection started by CloseGracefully.
+type goodbye struct {
+	timer *time.Timer
+	ack   func(frame []byte) bool // reports whether the frame acknowledges the goodbye
+}
+
+func (c *conn) CloseGracefully(frame []byte, ackMatcher func(frame []byte) bool, timeout time.Duration) error {
+	return c.trigger(func(_ interface{}) error { return c.owner().loopCloseGracefully(c, frame, ackMatcher, timeout) },
+		nil, false)
+}
+
+// loopCloseGracefully sends the goodbye frame and waits for the acknowledgement of the peer before closing
+// the connection.
+func (el *eventloop) loopCloseGracefully(c *conn, frame []byte, ack func([]byte) bool, timeout time.Duration) error {
+	if !c.opened || c.goodbye != nil {
+		return nil
+	}
+	if ack == nil {
+		ack = func(_ []byte) bool { return true }
+	}
+	gb := &goodbye{ack: ack}
+	c.goodbye = gb
+	gb.timer = time.AfterFunc(timeout, func() {
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopGoodbyeTimeout(c, gb) }, nil, false)
+	})
+	return c.write(frame)
+}
+
+// loopGoodbye consumes the frame decoded during the close handshake, it closes the connection once the frame
+// acknowledges the goodbye.
+func (el *eventloop) loopGoodbye(c *conn, frame []byte) error {
+	if !c.goodbye.ack(frame) {
+		return nil
+	}
+	return el.loopCloseConn(c, nil)
+}
+
+// loopGoodbyeTimeout closes the connection whose peer fails to acknowledge the goodbye in time.
+func (el *eventloop) loopGoodbyeTimeout(c *conn, gb *goodbye) error {
+	if !c.opened || c.goodbye != gb {
+		return nil // stale handshake
+	}
+	return el.loopCloseConn(c, gerrors.ErrCloseHandshakeTimeout)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// goodbye is the close handshake of a connection started by CloseGracefully.
+type goodbye struct {
+	timer *time.Timer
+	ack   func(frame []byte) bool // reports whether the frame acknowledges the goodbye
+}
+
+func (c *stdConn) CloseGracefully(frame []byte, ackMatcher func(frame []byte) bool, timeout time.Duration) error {
+	task := signalTaskPool.Get().(*signalTask)
+	task.run = func(c *stdConn) error { return c.loop.loopCloseGracefully(c, frame, ackMatcher, timeout) }
+	task.c = c
+	c.loop.ch <- task
+	return nil
+}
+
+// loopCloseGracefully sends the goodbye frame and waits for the acknowledgement of the peer before closing
+// the connection.
+func (el *eventloop) loopCloseGracefully(c *stdConn, frame []byte, ack func([]byte) bool, timeout time.Duration) error {
+	if _, ok := el.connections[c]; !ok || c.closing || c.goodbye != nil {
+		return nil
+	}
+	if ack == nil {
+		ack = func(_ []byte) bool { return true }
+	}
+	gb := &goodbye{ack: ack}
+	c.goodbye = gb
+	gb.timer = time.AfterFunc(timeout, func() {
+		task := signalTaskPool.Get().(*signalTask)
+		task.run = func(c *stdConn) error { return el.loopGoodbyeTimeout(c, gb) }
+		task.c = c
+		// The timer may fire after the event-loop has exited, nothing is left to close by then.
+		if !el.trigger(task) {
+			signalTaskPool.Put(task)
+		}
+	})
+	outFrame, err := c.codec.Encode(c, frame)
+	if err != nil {
+		return nil
+	}
+	el.eventHandler.PreWrite()
+	if _, err = c.writeFrame(outFrame); err != nil {
+		return el.loopError(c, err)
+	}
+	return nil
+}
+
+// loopGoodbye consumes the frame decoded during the close handshake, it closes the connection once the frame
+// acknowledges the goodbye.
+func (el *eventloop) loopGoodbye(c *stdConn, frame []byte) error {
+	if !c.goodbye.ack(frame) {
+		return nil
+	}
+	return el.loopCloseConn(c)
+}
+
+// loopGoodbyeTimeout closes the connection whose peer fails to acknowledge the goodbye in time.
+func (el *eventloop) loopGoodbyeTimeout(c *stdConn, gb *goodbye) error {
+	if _, ok := el.connections[c]; !ok || c.closing || c.goodbye != gb {
+		return nil // stale handshake
+	}
+	return el.loopError(c, errors.ErrCloseHandshakeTimeout)
+}
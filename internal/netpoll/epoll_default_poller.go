@@ -45,6 +45,7 @@ type Poller struct {
 	netpollWakeSig      int32
 	asyncTaskQueue      queue.AsyncTaskQueue // queue with low priority
 	priorAsyncTaskQueue queue.AsyncTaskQueue // queue with high priority
+	onWake              func()               // invoked every time the poller returns with events
 }
 
 // OpenPoller instantiates a poller.
@@ -72,6 +73,12 @@ func OpenPoller() (poller *Poller, err error) {
 	return
 }
 
+// OnWake registers fn to be invoked on the polling goroutine every time the poller returns with events,
+// before the events are handled, it must be called before Polling.
+func (p *Poller) OnWake(fn func()) {
+	p.onWake = fn
+}
+
 // Close closes the poller.
 func (p *Poller) Close() error {
 	if err := os.NewSyscallError("close", unix.Close(p.fd)); err != nil {
@@ -118,6 +125,11 @@ func (p *Poller) Trigger(fn queue.TaskFunc, arg interface{}) (err error) {
 	return os.NewSyscallError("write", err)
 }
 
+// Backlog returns the number of tasks queued by UrgentTrigger and Trigger which are waiting to run.
+func (p *Poller) Backlog() int {
+	return p.priorAsyncTaskQueue.Length() + p.asyncTaskQueue.Length()
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling(callback func(fd int, ev uint32) error) error {
 	el := newEventList(InitPollEventsCap)
@@ -135,6 +147,9 @@ func (p *Poller) Polling(callback func(fd int, ev uint32) error) error {
 			return err
 		}
 		msec = 0
+		if p.onWake != nil {
+			p.onWake()
+		}
 
 		for i := 0; i < n; i++ {
 			ev := &el.events[i]
@@ -229,7 +244,39 @@ func (p *Poller) ModReadWrite(pa *PollAttachment) error {
 		unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &unix.EpollEvent{Fd: int32(pa.FD), Events: readWriteEvents}))
 }
 
+// DeleteRead stops watching the given file-descriptor for readable events,
+// which is the same as Delete for the file-descriptors that are only registered with readable events.
+func (p *Poller) DeleteRead(pa *PollAttachment) error {
+	return p.Delete(pa.FD)
+}
+
+// PauseRead stops watching the given file-descriptor for readable events, it is still watched for writable events
+// if writing is true.
+func (p *Poller) PauseRead(pa *PollAttachment, writing bool) error {
+	var events uint32
+	if writing {
+		events = writeEvents
+	}
+	return os.NewSyscallError("epoll_ctl mod",
+		unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &unix.EpollEvent{Fd: int32(pa.FD), Events: events}))
+}
+
+// ResumeRead watches the given file-descriptor for readable events again, along with writable events
+// if writing is true.
+func (p *Poller) ResumeRead(pa *PollAttachment, writing bool) error {
+	if writing {
+		return p.ModReadWrite(pa)
+	}
+	return p.ModRead(pa)
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
 	return os.NewSyscallError("epoll_ctl del", unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil))
 }
+
+// Detach stops watching the given file-descriptor for any events while it is kept open, e.g. for handing it over
+// to another poller.
+func (p *Poller) Detach(pa *PollAttachment) error {
+	return p.Delete(pa.FD)
+}
@@ -42,6 +42,7 @@ type Poller struct {
 	netpollWakeSig      int32
 	asyncTaskQueue      queue.AsyncTaskQueue // queue with low priority
 	priorAsyncTaskQueue queue.AsyncTaskQueue // queue with high priority
+	onWake              func()               // invoked every time the poller returns with events
 }
 
 // OpenPoller instantiates a poller.
@@ -67,6 +68,12 @@ func OpenPoller() (poller *Poller, err error) {
 	return
 }
 
+// OnWake registers fn to be invoked on the polling goroutine every time the poller returns with events,
+// before the events are handled, it must be called before Polling.
+func (p *Poller) OnWake(fn func()) {
+	p.onWake = fn
+}
+
 // Close closes the poller.
 func (p *Poller) Close() error {
 	return os.NewSyscallError("close", unix.Close(p.fd))
@@ -109,6 +116,11 @@ func (p *Poller) Trigger(fn queue.TaskFunc, arg interface{}) (err error) {
 	return os.NewSyscallError("kevent trigger", err)
 }
 
+// Backlog returns the number of tasks queued by UrgentTrigger and Trigger which are waiting to run.
+func (p *Poller) Backlog() int {
+	return p.priorAsyncTaskQueue.Length() + p.asyncTaskQueue.Length()
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling() error {
 	el := newEventList(InitPollEventsCap)
@@ -129,6 +141,9 @@ func (p *Poller) Polling() error {
 			return err
 		}
 		tsp = &ts
+		if p.onWake != nil {
+			p.onWake()
+		}
 
 		var evFilter int16
 		for i := 0; i < n; i++ {
@@ -249,7 +264,55 @@ func (p *Poller) ModReadWrite(pa *PollAttachment) error {
 	return os.NewSyscallError("kevent add", err)
 }
 
+// DeleteRead stops watching the given file-descriptor for readable events.
+func (p *Poller) DeleteRead(pa *PollAttachment) error {
+	var evs [1]unix.Kevent_t
+	evs[0].Ident = uint64(pa.FD)
+	evs[0].Flags = unix.EV_DELETE
+	evs[0].Filter = unix.EVFILT_READ
+	evs[0].Udata = (*byte)(unsafe.Pointer(pa))
+	_, err := unix.Kevent(p.fd, evs[:], nil, nil)
+	return os.NewSyscallError("kevent delete", err)
+}
+
+// PauseRead stops watching the given file-descriptor for readable events, it is still watched for writable events
+// if writing is true.
+func (p *Poller) PauseRead(pa *PollAttachment, writing bool) error {
+	return p.modRead(pa, unix.EV_DELETE, writing)
+}
+
+// ResumeRead watches the given file-descriptor for readable events again, along with writable events
+// if writing is true.
+func (p *Poller) ResumeRead(pa *PollAttachment, writing bool) error {
+	return p.modRead(pa, unix.EV_ADD, writing)
+}
+
+// modRead applies the flags to the readable filter of the given file-descriptor and adds or deletes its writable
+// filter, the filters are changed one by one since deleting a filter that doesn't exist fails with ENOENT.
+func (p *Poller) modRead(pa *PollAttachment, flags uint16, writing bool) error {
+	var writeFlags uint16 = unix.EV_DELETE
+	if writing {
+		writeFlags = unix.EV_ADD
+	}
+	evs := [2]unix.Kevent_t{
+		{Ident: uint64(pa.FD), Flags: writeFlags, Filter: unix.EVFILT_WRITE, Udata: (*byte)(unsafe.Pointer(pa))},
+		{Ident: uint64(pa.FD), Flags: flags, Filter: unix.EVFILT_READ, Udata: (*byte)(unsafe.Pointer(pa))},
+	}
+	for i := range evs {
+		if _, err := unix.Kevent(p.fd, evs[i:i+1], nil, nil); err != nil && err != unix.ENOENT {
+			return os.NewSyscallError("kevent", err)
+		}
+	}
+	return nil
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(_ int) error {
 	return nil
 }
+
+// Detach stops watching the given file-descriptor for any events while it is kept open, e.g. for handing it over
+// to another poller, unlike Delete, which relies on the file-descriptor being closed to remove its filters.
+func (p *Poller) Detach(pa *PollAttachment) error {
+	return p.modRead(pa, unix.EV_DELETE, false)
+}
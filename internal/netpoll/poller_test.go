@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2017 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+// +build !poll_opt
+
+package netpoll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+func TestDetach(t *testing.T) {
+	p, err := OpenPoller()
+	require.NoError(t, err)
+	defer p.Close()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	require.NoError(t, err)
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	pa := &PollAttachment{FD: fds[0]}
+	require.NoError(t, p.AddReadWrite(pa))
+	require.NoError(t, p.Detach(pa))
+	// The descriptor is both readable and writable now, but it mustn't be reported once it is detached.
+	_, err = unix.Write(fds[1], []byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, p.Trigger(func(_ interface{}) error { return errors.ErrServerShutdown }, nil))
+
+	var reported bool
+	err = pollFd(p, func(fd int) {
+		if fd == fds[0] {
+			reported = true
+		}
+	})
+	assert.Equal(t, errors.ErrServerShutdown, err)
+	assert.False(t, reported, "the detached descriptor is still watched")
+}
@@ -163,6 +163,14 @@ func (q *lockFreeQueue) Empty() bool {
 	return atomic.LoadInt32(&q.length) == 0
 }
 
+// Length returns the number of tasks in this queue, which may lag behind the concurrent enqueues and dequeues.
+func (q *lockFreeQueue) Length() int {
+	if n := atomic.LoadInt32(&q.length); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
 func load(p *unsafe.Pointer) (n *node) {
 	return (*node)(atomic.LoadPointer(p))
 }
@@ -49,4 +49,5 @@ type AsyncTaskQueue interface {
 	Enqueue(*Task)
 	Dequeue() *Task
 	Empty() bool
+	Length() int
 }
@@ -55,5 +55,8 @@ func TestLockFreeQueue(t *testing.T) {
 	}()
 	wg.Wait()
 
+	if n := q.Length(); n != 0 {
+		t.Fatalf("expect the queue to be empty, but got %d tasks", n)
+	}
 	t.Logf("sent and received all %d tasks", 2*taskNum)
 }
@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build freebsd dragonfly darwin
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// SetCork enables/disables the TCP_NOPUSH socket option, which is the BSD counterpart of TCP_CORK on Linux,
+// while it is set, the kernel holds back partial frames until the option is cleared.
+func SetCork(fd, cork int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NOPUSH, cork))
+}
+
+// GetCongestionControl is not supported on BSD's yet.
+func GetCongestionControl(_ int) (string, error) {
+	return "", errors.ErrUnsupportedOp
+}
+
+// SetCongestionControl is not supported on BSD's yet.
+func SetCongestionControl(_ int, _ string) error {
+	return errors.ErrUnsupportedOp
+}
+
+// IsPeerReachable is not supported on BSD's yet.
+func IsPeerReachable(_ int) (bool, error) {
+	return true, errors.ErrUnsupportedOp
+}
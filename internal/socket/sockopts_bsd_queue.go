@@ -0,0 +1,47 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build freebsd dragonfly
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fionwrite is FIONWRITE, which is missing in golang.org/x/sys/unix for FreeBSD and DragonFly BSD.
+const fionwrite = 0x40046677
+
+// soListenQLen is SO_LISTENQLEN, which is missing in golang.org/x/sys/unix for DragonFly BSD.
+const soListenQLen = 0x1012
+
+// GetSendQueue returns the number of bytes in the transmit buffer of the socket which are not yet sent or acknowledged.
+func GetSendQueue(fd int) (int, error) {
+	n, err := unix.IoctlGetInt(fd, fionwrite)
+	return n, os.NewSyscallError("ioctl", err)
+}
+
+// GetAcceptQueue returns the number of established connections waiting in the accept queue of the listening socket.
+func GetAcceptQueue(fd int) (int, error) {
+	n, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, soListenQLen)
+	return n, os.NewSyscallError("getsockopt", err)
+}
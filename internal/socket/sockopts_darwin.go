@@ -26,6 +26,8 @@ import (
 	"os"
 
 	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/errors"
 )
 
 // SetKeepAlive sets whether the operating system should send
@@ -44,3 +46,14 @@ func SetKeepAlive(fd, secs int) error {
 	}
 	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPALIVE, secs))
 }
+
+// GetSendQueue returns the number of bytes in the transmit buffer of the socket which are not yet sent or acknowledged.
+func GetSendQueue(fd int) (int, error) {
+	n, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_NWRITE)
+	return n, os.NewSyscallError("getsockopt", err)
+}
+
+// GetAcceptQueue is not supported on macOS yet.
+func GetAcceptQueue(_ int) (int, error) {
+	return 0, gerrors.ErrUnsupportedOp
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetCork enables/disables the TCP_CORK socket option, while it is set, the kernel holds back partial frames
+// and coalesces the subsequent writes into full segments until the option is cleared.
+func SetCork(fd, cork int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_CORK, cork))
+}
+
+// GetCongestionControl returns the name of the TCP congestion control algorithm in use on the socket.
+func GetCongestionControl(fd int) (string, error) {
+	algo, err := unix.GetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	return algo, os.NewSyscallError("getsockopt", err)
+}
+
+// SetCongestionControl sets the TCP congestion control algorithm of the socket, e.g. "cubic" or "bbr",
+// the algorithm must be available in the kernel, see /proc/sys/net/ipv4/tcp_available_congestion_control.
+func SetCongestionControl(fd int, algo string) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION, algo))
+}
+
+// GetSendQueue returns the number of bytes in the transmit buffer of the socket which are not yet sent or acknowledged.
+func GetSendQueue(fd int) (int, error) {
+	n, err := unix.IoctlGetInt(fd, unix.SIOCOUTQ)
+	return n, os.NewSyscallError("ioctl", err)
+}
+
+// maxUnrecoveredRetransmits is the number of consecutive retransmission timeouts of the unacknowledged data after
+// which the peer is deemed unreachable.
+const maxUnrecoveredRetransmits = 3
+
+// tcpEstablished is the TCP_ESTABLISHED state reported by TCP_INFO.
+const tcpEstablished = 1
+
+// IsPeerReachable reports whether the peer of the TCP socket is deemed reachable according to TCP_INFO, it isn't
+// once the connection leaves the established state or the unacknowledged data keeps timing out on retransmissions.
+func IsPeerReachable(fd int) (bool, error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return true, os.NewSyscallError("getsockopt", err)
+	}
+	return info.State == tcpEstablished && info.Retransmits < maxUnrecoveredRetransmits, nil
+}
+
+// GetAcceptQueue returns the number of established connections waiting in the accept queue of the listening socket,
+// which is reported as tcpi_unacked by TCP_INFO for listening sockets.
+func GetAcceptQueue(fd int) (int, error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return 0, os.NewSyscallError("getsockopt", err)
+	}
+	return int(info.Unacked), nil
+}
@@ -49,6 +49,23 @@ func SetSendBuffer(fd, size int) error {
 	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, size)
 }
 
+// GetSendBuffer returns the size of the operating system's
+// transmit buffer associated with the connection.
+func GetSendBuffer(fd int) (int, error) {
+	size, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF)
+	return size, os.NewSyscallError("getsockopt", err)
+}
+
+// GetSocketError returns and clears the pending error of the socket, i.e. SO_ERROR, it returns nil if there is
+// no pending error.
+func GetSocketError(fd int) error {
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil || errno == 0 {
+		return nil
+	}
+	return unix.Errno(errno)
+}
+
 // SetReuseport enables SO_REUSEPORT option on socket.
 func SetReuseport(fd, reusePort int) error {
 	if err := os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, reusePort)); err != nil {
@@ -57,6 +74,17 @@ func SetReuseport(fd, reusePort int) error {
 	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, reusePort))
 }
 
+// SetLinger sets the behavior of Close on a connection which still has data waiting to be sent or to be acknowledged,
+// the data is discarded and the connection is reset when sec is 0, a negative sec disables the option.
+func SetLinger(fd, sec int) error {
+	var l unix.Linger
+	if sec >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(sec)
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &l))
+}
+
 // SetIPv6Only restricts a IPv6 socket to only process IPv6 requests or both IPv4 and IPv6 requests.
 func SetIPv6Only(fd, ipv6only int) error {
 	return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, ipv6only)
@@ -24,6 +24,7 @@ package socket
 
 import (
 	"net"
+	"os"
 
 	"golang.org/x/sys/unix"
 )
@@ -104,3 +105,19 @@ func int2decimal(i uint) string {
 	}
 	return string(b[bp:])
 }
+
+// boundPort returns the local port that the socket is bound to, which is how the ephemeral port picked by
+// the kernel is found out after binding to port 0.
+func boundPort(fd int) (int, error) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return 0, os.NewSyscallError("getsockname", err)
+	}
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return sa.Port, nil
+	case *unix.SockaddrInet6:
+		return sa.Port, nil
+	}
+	return 0, nil
+}
@@ -147,6 +147,11 @@ func tcpSocket(proto, addr string, sockopts ...Option) (fd int, netAddr net.Addr
 	if err = os.NewSyscallError("bind", unix.Bind(fd, sockaddr)); err != nil {
 		return
 	}
+	if tcpAddr := netAddr.(*net.TCPAddr); tcpAddr.Port == 0 {
+		if tcpAddr.Port, err = boundPort(fd); err != nil {
+			return
+		}
+	}
 
 	// Set backlog size to the maximum.
 	err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
@@ -147,7 +147,13 @@ func udpSocket(proto, addr string, sockopts ...Option) (fd int, netAddr net.Addr
 		}
 	}
 
-	err = os.NewSyscallError("bind", unix.Bind(fd, sockaddr))
+	if err = os.NewSyscallError("bind", unix.Bind(fd, sockaddr)); err != nil {
+		return
+	}
+
+	if udpAddr := netAddr.(*net.UDPAddr); udpAddr.Port == 0 {
+		udpAddr.Port, err = boundPort(fd)
+	}
 
 	return
 }
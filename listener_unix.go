@@ -54,6 +54,13 @@ func (ln *listener) dup() (int, string, error) {
 	return netpoll.Dup(ln.fd)
 }
 
+func (ln *listener) acceptQueueLen() (int, error) {
+	if ln.network != "tcp" {
+		return 0, errors.ErrUnsupportedOp
+	}
+	return socket.GetAcceptQueue(ln.fd)
+}
+
 func (ln *listener) normalize() (err error) {
 	switch ln.network {
 	case "tcp", "tcp4", "tcp6":
@@ -68,6 +75,9 @@ func (ln *listener) normalize() (err error) {
 	default:
 		err = errors.ErrUnsupportedProtocol
 	}
+	if err == nil {
+		ln.addr = resolvePort(ln.addr, ln.lnaddr)
+	}
 	return
 }
 
@@ -101,7 +111,14 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockopt := socket.Option{SetSockopt: socket.SetSendBuffer, Opt: options.SocketSendBuffer}
 		sockopts = append(sockopts, sockopt)
 	}
-	l = &listener{network: network, addr: addr, sockopts: sockopts}
-	err = l.normalize()
+	if control := options.SocketControl; control != nil {
+		sockopt := socket.Option{SetSockopt: func(fd, _ int) error { return control(uintptr(fd)) }}
+		sockopts = append(sockopts, sockopt)
+	}
+	l = &listener{network: network, sockopts: sockopts}
+	err = bindInPortRange(addr, options.PortRangeMin, options.PortRangeMax, func(addr string) error {
+		l.addr = addr
+		return l.normalize()
+	})
 	return
 }
@@ -21,9 +21,11 @@
 package gnet
 
 import (
+	"context"
 	"net"
 	"os"
 	"sync"
+	"syscall"
 
 	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/netpoll"
@@ -36,30 +38,47 @@ type listener struct {
 	pconn         net.PacketConn
 	lnaddr        net.Addr
 	addr, network string
+	control       func(fd uintptr) error
 }
 
 func (ln *listener) dup() (int, string, error) {
 	return netpoll.Dup(0)
 }
 
+func (ln *listener) acceptQueueLen() (int, error) {
+	return 0, errors.ErrUnsupportedOp
+}
+
 func (ln *listener) normalize() (err error) {
+	var lc net.ListenConfig
+	if ln.control != nil {
+		lc.Control = func(_, _ string, c syscall.RawConn) (err error) {
+			if e := c.Control(func(fd uintptr) { err = ln.control(fd) }); e != nil {
+				err = e
+			}
+			return
+		}
+	}
 	switch ln.network {
 	case "unix":
 		logging.LogErr(os.RemoveAll(ln.addr))
 		fallthrough
 	case "tcp", "tcp4", "tcp6":
-		if ln.ln, err = net.Listen(ln.network, ln.addr); err != nil {
+		if ln.ln, err = lc.Listen(context.Background(), ln.network, ln.addr); err != nil {
 			return
 		}
 		ln.lnaddr = ln.ln.Addr()
 	case "udp", "udp4", "udp6":
-		if ln.pconn, err = net.ListenPacket(ln.network, ln.addr); err != nil {
+		if ln.pconn, err = lc.ListenPacket(context.Background(), ln.network, ln.addr); err != nil {
 			return
 		}
 		ln.lnaddr = ln.pconn.LocalAddr()
 	default:
 		err = errors.ErrUnsupportedProtocol
 	}
+	if err == nil {
+		ln.addr = resolvePort(ln.addr, ln.lnaddr)
+	}
 	return
 }
 
@@ -77,8 +96,11 @@ func (ln *listener) close() {
 	})
 }
 
-func initListener(network, addr string, _ *Options) (l *listener, err error) {
-	l = &listener{network: network, addr: addr}
-	err = l.normalize()
+func initListener(network, addr string, options *Options) (l *listener, err error) {
+	l = &listener{network: network, control: options.SocketControl}
+	err = bindInPortRange(addr, options.PortRangeMin, options.PortRangeMax, func(addr string) error {
+		l.addr = addr
+		return l.normalize()
+	})
 	return
 }
@@ -23,6 +23,8 @@ package gnet
 import (
 	"hash/crc32"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/panjf2000/gnet/internal"
 )
@@ -40,19 +42,39 @@ const (
 
 	// SourceAddrHash assignes the next accepted connection to the event-loop by hashing the remote address.
 	SourceAddrHash
+
+	// CustomLoadBalancing indicates that the connections are assigned by the LoadBalancer set up by
+	// Server.SetLoadBalancer, it can't be set up by WithLoadBalancing or Server.SetLoadBalancing.
+	CustomLoadBalancing
 )
 
+// LoadBalancer is a custom load-balancing algorithm, which is set up by Server.SetLoadBalancer.
+type LoadBalancer interface {
+	// Next picks the event-loop for the connection accepted from addr: loads holds the number of connections
+	// served by each of the event-loops eligible for new connections, and the index into it is returned, an index
+	// out of range falls back to the first one. It is called by the goroutine accepting the connections, one call
+	// at a time, and loads is only valid until it returns.
+	Next(addr net.Addr, loads []int) int
+}
+
 type (
 	// loadBalancer is a interface which manipulates the event-loop set.
+	//
+	// Only the first active() event-loops are eligible for new connections, the rest of them are parked by scale
+	// and keep serving their existing connections, next must be called from a single goroutine and it skips
+	// the event-loops being drained.
 	loadBalancer interface {
 		register(*eventloop)
 		next(net.Addr) *eventloop
 		iterate(func(int, *eventloop) bool)
 		len() int
+		active() int
+		scale(int)
 	}
 
 	// roundRobinLoadBalancer with Round-Robin algorithm.
 	roundRobinLoadBalancer struct {
+		mu            sync.RWMutex
 		nextLoopIndex int
 		eventLoops    []*eventloop
 		size          int
@@ -60,35 +82,64 @@ type (
 
 	// leastConnectionsLoadBalancer with Least-Connections algorithm.
 	leastConnectionsLoadBalancer struct {
+		mu         sync.RWMutex
 		eventLoops []*eventloop
 		size       int
 	}
 
 	// sourceAddrHashLoadBalancer with Hash algorithm.
 	sourceAddrHashLoadBalancer struct {
+		mu         sync.RWMutex
+		eventLoops []*eventloop
+		size       int
+	}
+
+	// customLoadBalancer with the LoadBalancer set up by Server.SetLoadBalancer.
+	customLoadBalancer struct {
+		mu         sync.RWMutex
+		picker     LoadBalancer
 		eventLoops []*eventloop
 		size       int
+		eligible   []*eventloop // reused by next
+		loads      []int        // reused by next
 	}
 )
 
+// isDraining reports whether the event-loop is taken out of service for new connections by DrainLoop.
+func (el *eventloop) isDraining() bool {
+	return atomic.LoadInt32(&el.draining) == 1
+}
+
 // ==================================== Implementation of Round-Robin load-balancer ====================================
 
 func (lb *roundRobinLoadBalancer) register(el *eventloop) {
+	lb.mu.Lock()
 	el.idx = lb.size
 	lb.eventLoops = append(lb.eventLoops, el)
 	lb.size++
+	lb.mu.Unlock()
 }
 
-// next returns the eligible event-loop based on Round-Robin algorithm.
+// next returns the eligible event-loop based on Round-Robin algorithm, it holds the write lock since it advances
+// nextLoopIndex.
 func (lb *roundRobinLoadBalancer) next(_ net.Addr) (el *eventloop) {
-	el = lb.eventLoops[lb.nextLoopIndex]
-	if lb.nextLoopIndex++; lb.nextLoopIndex >= lb.size {
-		lb.nextLoopIndex = 0
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	for i := 0; i < lb.size; i++ {
+		el = lb.eventLoops[lb.nextLoopIndex]
+		if lb.nextLoopIndex++; lb.nextLoopIndex >= lb.size {
+			lb.nextLoopIndex = 0
+		}
+		if !el.isDraining() {
+			break
+		}
 	}
 	return
 }
 
 func (lb *roundRobinLoadBalancer) iterate(f func(int, *eventloop) bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	for i, el := range lb.eventLoops {
 		if !f(i, el) {
 			break
@@ -97,16 +148,37 @@ func (lb *roundRobinLoadBalancer) iterate(f func(int, *eventloop) bool) {
 }
 
 func (lb *roundRobinLoadBalancer) len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.eventLoops)
+}
+
+func (lb *roundRobinLoadBalancer) active() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	return lb.size
 }
 
+// scale sets the number of event-loops eligible for new connections, it must not exceed len().
+func (lb *roundRobinLoadBalancer) scale(n int) {
+	lb.mu.Lock()
+	lb.size = n
+	if lb.nextLoopIndex >= n {
+		lb.nextLoopIndex = 0
+	}
+	lb.mu.Unlock()
+}
+
 // ================================= Implementation of Least-Connections load-balancer =================================
 
 func (lb *leastConnectionsLoadBalancer) min() (el *eventloop) {
 	el = lb.eventLoops[0]
-	minN := el.loadConn()
-	for _, v := range lb.eventLoops[1:] {
-		if n := v.loadConn(); n < minN {
+	minN := el.loadConn() + el.loadStaged()
+	for _, v := range lb.eventLoops[1:lb.size] {
+		if v.isDraining() {
+			continue
+		}
+		if n := v.loadConn() + v.loadStaged(); n < minN || el.isDraining() {
 			minN = n
 			el = v
 		}
@@ -115,17 +187,23 @@ func (lb *leastConnectionsLoadBalancer) min() (el *eventloop) {
 }
 
 func (lb *leastConnectionsLoadBalancer) register(el *eventloop) {
+	lb.mu.Lock()
 	el.idx = lb.size
 	lb.eventLoops = append(lb.eventLoops, el)
 	lb.size++
+	lb.mu.Unlock()
 }
 
 // next returns the eligible event-loop by taking the root node from minimum heap based on Least-Connections algorithm.
 func (lb *leastConnectionsLoadBalancer) next(_ net.Addr) (el *eventloop) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	return lb.min()
 }
 
 func (lb *leastConnectionsLoadBalancer) iterate(f func(int, *eventloop) bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	for i, el := range lb.eventLoops {
 		if !f(i, el) {
 			break
@@ -134,15 +212,32 @@ func (lb *leastConnectionsLoadBalancer) iterate(f func(int, *eventloop) bool) {
 }
 
 func (lb *leastConnectionsLoadBalancer) len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.eventLoops)
+}
+
+func (lb *leastConnectionsLoadBalancer) active() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	return lb.size
 }
 
+// scale sets the number of event-loops eligible for new connections, it must not exceed len().
+func (lb *leastConnectionsLoadBalancer) scale(n int) {
+	lb.mu.Lock()
+	lb.size = n
+	lb.mu.Unlock()
+}
+
 // ======================================= Implementation of Hash load-balancer ========================================
 
 func (lb *sourceAddrHashLoadBalancer) register(el *eventloop) {
+	lb.mu.Lock()
 	el.idx = lb.size
 	lb.eventLoops = append(lb.eventLoops, el)
 	lb.size++
+	lb.mu.Unlock()
 }
 
 // hash hashes a string to a unique hash code.
@@ -156,11 +251,21 @@ func (lb *sourceAddrHashLoadBalancer) hash(s string) int {
 
 // next returns the eligible event-loop by taking the remainder of a hash code as the index of event-loop list.
 func (lb *sourceAddrHashLoadBalancer) next(netAddr net.Addr) *eventloop {
-	hashCode := lb.hash(netAddr.String())
-	return lb.eventLoops[hashCode%lb.size]
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	idx := lb.hash(netAddr.String()) % lb.size
+	// Fall through to the following event-loop if the hashed one is being drained.
+	for i := 0; i < lb.size && lb.eventLoops[idx].isDraining(); i++ {
+		if idx++; idx >= lb.size {
+			idx = 0
+		}
+	}
+	return lb.eventLoops[idx]
 }
 
 func (lb *sourceAddrHashLoadBalancer) iterate(f func(int, *eventloop) bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	for i, el := range lb.eventLoops {
 		if !f(i, el) {
 			break
@@ -169,5 +274,184 @@ func (lb *sourceAddrHashLoadBalancer) iterate(f func(int, *eventloop) bool) {
 }
 
 func (lb *sourceAddrHashLoadBalancer) len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.eventLoops)
+}
+
+func (lb *sourceAddrHashLoadBalancer) active() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
 	return lb.size
 }
+
+// scale sets the number of event-loops eligible for new connections, it must not exceed len().
+func (lb *sourceAddrHashLoadBalancer) scale(n int) {
+	lb.mu.Lock()
+	lb.size = n
+	lb.mu.Unlock()
+}
+
+// ===================================== Implementation of custom load-balancer ======================================
+
+func (lb *customLoadBalancer) register(el *eventloop) {
+	lb.mu.Lock()
+	el.idx = lb.size
+	lb.eventLoops = append(lb.eventLoops, el)
+	lb.size++
+	lb.mu.Unlock()
+}
+
+// next returns the event-loop picked by the LoadBalancer among the eligible ones, it holds the write lock
+// for reusing the slices passed to the LoadBalancer.
+func (lb *customLoadBalancer) next(netAddr net.Addr) *eventloop {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.eligible, lb.loads = lb.eligible[:0], lb.loads[:0]
+	for _, el := range lb.eventLoops[:lb.size] {
+		if !el.isDraining() {
+			lb.eligible = append(lb.eligible, el)
+			lb.loads = append(lb.loads, int(el.loadConn()+el.loadStaged()))
+		}
+	}
+	if len(lb.eligible) == 0 {
+		return lb.eventLoops[0]
+	}
+	idx := lb.picker.Next(netAddr, lb.loads)
+	if idx < 0 || idx >= len(lb.eligible) {
+		idx = 0
+	}
+	return lb.eligible[idx]
+}
+
+func (lb *customLoadBalancer) iterate(f func(int, *eventloop) bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for i, el := range lb.eventLoops {
+		if !f(i, el) {
+			break
+		}
+	}
+}
+
+func (lb *customLoadBalancer) len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.eventLoops)
+}
+
+func (lb *customLoadBalancer) active() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.size
+}
+
+// scale sets the number of event-loops eligible for new connections, it must not exceed len().
+func (lb *customLoadBalancer) scale(n int) {
+	lb.mu.Lock()
+	lb.size = n
+	lb.mu.Unlock()
+}
+
+// ================================== Implementation of switchable load-balancer ==================================
+
+// switchableLoadBalancer delegates to the load-balancer of the algorithm in use, which can be switched at runtime
+// by Server.SetLoadBalancing, the event-loops are handed over to the new load-balancer as they are.
+type switchableLoadBalancer struct {
+	mu   sync.RWMutex
+	algo LoadBalancing
+	lb   loadBalancer
+}
+
+// newLoadBalancer returns the load-balancer of the given algorithm serving the given event-loops, of which
+// the first size ones are eligible for new connections, it returns nil if the algorithm is unknown.
+func newLoadBalancer(algo LoadBalancing, eventLoops []*eventloop, size int) loadBalancer {
+	switch algo {
+	case RoundRobin:
+		return &roundRobinLoadBalancer{eventLoops: eventLoops, size: size}
+	case LeastConnections:
+		return &leastConnectionsLoadBalancer{eventLoops: eventLoops, size: size}
+	case SourceAddrHash:
+		return &sourceAddrHashLoadBalancer{eventLoops: eventLoops, size: size}
+	}
+	return nil
+}
+
+func newSwitchableLoadBalancer(algo LoadBalancing) *switchableLoadBalancer {
+	return &switchableLoadBalancer{algo: algo, lb: newLoadBalancer(algo, nil, 0)}
+}
+
+func (lb *switchableLoadBalancer) current() loadBalancer {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.lb
+}
+
+func (lb *switchableLoadBalancer) algorithm() LoadBalancing {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.algo
+}
+
+// use switches to the load-balancer of the given algorithm, which only affects the assignments of the connections
+// accepted afterwards.
+func (lb *switchableLoadBalancer) use(algo LoadBalancing) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if algo == lb.algo && algo != CustomLoadBalancing {
+		return true
+	}
+	next := newLoadBalancer(algo, lb.eventLoops(), lb.lb.active())
+	if next == nil {
+		return false
+	}
+	lb.algo, lb.lb = algo, next
+	return true
+}
+
+// useCustom switches to the given LoadBalancer, which only affects the assignments of the connections
+// accepted afterwards.
+func (lb *switchableLoadBalancer) useCustom(picker LoadBalancer) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	next := &customLoadBalancer{picker: picker, eventLoops: lb.eventLoops(), size: lb.lb.active()}
+	lb.algo, lb.lb = CustomLoadBalancing, next
+}
+
+// eventLoops returns the event-loops of the load-balancer in use, lb.mu must be held.
+func (lb *switchableLoadBalancer) eventLoops() (eventLoops []*eventloop) {
+	lb.lb.iterate(func(_ int, el *eventloop) bool {
+		eventLoops = append(eventLoops, el)
+		return true
+	})
+	return
+}
+
+// register and scale hold the read lock so that the change is not lost in a concurrent switch.
+func (lb *switchableLoadBalancer) register(el *eventloop) {
+	lb.mu.RLock()
+	lb.lb.register(el)
+	lb.mu.RUnlock()
+}
+
+func (lb *switchableLoadBalancer) scale(n int) {
+	lb.mu.RLock()
+	lb.lb.scale(n)
+	lb.mu.RUnlock()
+}
+
+func (lb *switchableLoadBalancer) next(addr net.Addr) *eventloop {
+	return lb.current().next(addr)
+}
+
+func (lb *switchableLoadBalancer) iterate(f func(int, *eventloop) bool) {
+	lb.current().iterate(f)
+}
+
+func (lb *switchableLoadBalancer) len() int {
+	return lb.current().len()
+}
+
+func (lb *switchableLoadBalancer) active() int {
+	return lb.current().active()
+}
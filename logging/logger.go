@@ -54,8 +54,10 @@ package logging
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -178,6 +180,44 @@ func Fatalf(format string, args ...interface{}) {
 	defaultLogger.Errorf(format, args...)
 }
 
+// With returns a logger that tags every message with the given key-value pairs, the pairs are attached as
+// structured fields if the logger is powered by zap, otherwise they are prepended to the messages.
+func With(logger Logger, keysAndValues ...interface{}) Logger {
+	if sl, ok := logger.(*zap.SugaredLogger); ok {
+		return sl.With(keysAndValues...)
+	}
+	var sb strings.Builder
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		_, _ = fmt.Fprintf(&sb, "%v=%v ", keysAndValues[i], keysAndValues[i+1])
+	}
+	return &prefixedLogger{logger, strings.ReplaceAll(sb.String(), "%", "%%")}
+}
+
+type prefixedLogger struct {
+	Logger
+	prefix string
+}
+
+func (l *prefixedLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(l.prefix+format, args...)
+}
+
+func (l *prefixedLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Infof(l.prefix+format, args...)
+}
+
+func (l *prefixedLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warnf(l.prefix+format, args...)
+}
+
+func (l *prefixedLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, args...)
+}
+
+func (l *prefixedLogger) Fatalf(format string, args ...interface{}) {
+	l.Logger.Fatalf(l.prefix+format, args...)
+}
+
 // Logger is used for logging formatted messages.
 type Logger interface {
 	// Debugf logs messages at DEBUG level.
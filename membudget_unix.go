@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// bufferBudgetRetryInterval is the interval of checking whether the connections whose reads are paused due to
+// MaxTotalBufferMemory can resume.
+const bufferBudgetRetryInterval = 10 * time.Millisecond
+
+// overBufferBudget reports whether the data buffered by all connections exceeds MaxTotalBufferMemory.
+func (svr *server) overBufferBudget() bool {
+	max := svr.opts.MaxTotalBufferMemory
+	return max > 0 && atomic.LoadInt64(&svr.bufferMemory) > int64(max)
+}
+
+// accountBuffers updates the total of the data buffered by all connections with the buffers of the connection.
+func (c *conn) accountBuffers() {
+	if c.owner().svr.opts.MaxTotalBufferMemory <= 0 {
+		return
+	}
+	n := c.inboundBuffer.Length() + c.outboundBuffer.Length()
+	if delta := n - c.bufferCounted; delta != 0 {
+		c.bufferCounted = n
+		atomic.AddInt64(&c.owner().svr.bufferMemory, int64(delta))
+	}
+}
+
+// checkBufferBudget pauses reading the connections of the event-loop that are able to drain their buffered data
+// while the total exceeds MaxTotalBufferMemory, the reads are resumed once the total drops below the limit.
+//
+// The largest consumers are paused first until the excess is covered, the connections holding nothing but
+// an incomplete frame are left alone since pausing them would never release their buffers.
+func (el *eventloop) checkBufferBudget(c *conn) error {
+	if !c.opened {
+		return nil
+	}
+	if c.accountBuffers(); c.bufferCounted == 0 || !el.svr.overBufferBudget() {
+		return nil
+	}
+	excess := atomic.LoadInt64(&el.svr.bufferMemory) - int64(el.svr.opts.MaxTotalBufferMemory)
+	var candidates []*conn
+	for _, cc := range el.connections {
+		if cc.opened && !cc.readPaused && cc.bufferCounted > 0 && cc.canDrain() {
+			candidates = append(candidates, cc)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].bufferCounted > candidates[j].bufferCounted })
+	for _, cc := range candidates {
+		if excess <= 0 {
+			break
+		}
+		excess -= int64(cc.bufferCounted)
+		cc.readPaused = true
+		cc.budgetPaused = true
+		el.retryBufferBudget(cc)
+		if err := el.poller.PauseRead(cc.pollAttachment, !cc.outboundBuffer.IsEmpty()); err != nil && cc == c {
+			return err
+		}
+	}
+	return nil
+}
+
+// canDrain reports whether the buffered data of the connection is going to be released without reading more,
+// that is, there are data to be sent to the peer or frames waiting for the worker pool.
+func (c *conn) canDrain() bool {
+	return !c.outboundBuffer.IsEmpty() || (c.async != nil && c.async.pending())
+}
+
+func (el *eventloop) retryBufferBudget(c *conn) {
+	time.AfterFunc(bufferBudgetRetryInterval, func() {
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopResumeBudget(c) }, nil, false)
+	})
+}
+
+// loopResumeBudget resumes reading the connection paused by checkBufferBudget once the total drops below the limit.
+func (el *eventloop) loopResumeBudget(c *conn) error {
+	if !c.opened || !c.budgetPaused {
+		return nil
+	}
+	if c.accountBuffers(); el.svr.overBufferBudget() {
+		el.retryBufferBudget(c)
+		return nil
+	}
+	c.budgetPaused = false
+	if c.drainClose {
+		return nil
+	}
+	// Leave the connection to the worker pool if there are frames pending on it.
+	if c.async != nil && !c.async.flush() {
+		atomic.AddInt64(&el.svr.poolCounters.paused, 1)
+		el.retryAsync(c)
+		return nil
+	}
+	c.readPaused = false
+	return el.poller.ResumeRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+}
+
+// releaseBuffers removes the buffers of the connection from the total.
+func (c *conn) releaseBuffers() {
+	if c.bufferCounted != 0 {
+		atomic.AddInt64(&c.owner().svr.bufferMemory, -int64(c.bufferCounted))
+		c.bufferCounted = 0
+	}
+}
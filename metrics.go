@@ -0,0 +1,271 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// DefaultFirstByteBuckets are the default upper bounds of the buckets of the histogram of
+	// the latency from accepting connections to their first inbound bytes.
+	DefaultFirstByteBuckets = []time.Duration{
+		time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+		100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+	}
+
+	// DefaultConnAgeBuckets are the default upper bounds of the buckets of the histogram of how long connections live.
+	DefaultConnAgeBuckets = []time.Duration{
+		100 * time.Millisecond, time.Second, 10 * time.Second, time.Minute, 10 * time.Minute,
+		time.Hour, 6 * time.Hour, 24 * time.Hour,
+	}
+)
+
+// Stats is a snapshot of the traffic counters aggregated by gnet.
+type Stats struct {
+	// Connections is the number of active connections.
+	Connections int64
+
+	// BytesRead is the total number of bytes read from sockets.
+	BytesRead uint64
+
+	// BytesWritten is the total number of bytes written to sockets.
+	BytesWritten uint64
+
+	// FramesRead is the total number of frames decoded and handed to React.
+	FramesRead uint64
+
+	// FramesWritten is the total number of frames encoded and sent back to clients.
+	FramesWritten uint64
+}
+
+// counters holds the traffic counters which are updated atomically.
+type counters struct {
+	connections   int64
+	bytesRead     uint64
+	bytesWritten  uint64
+	framesRead    uint64
+	framesWritten uint64
+}
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Connections:   atomic.LoadInt64(&c.connections),
+		BytesRead:     atomic.LoadUint64(&c.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&c.bytesWritten),
+		FramesRead:    atomic.LoadUint64(&c.framesRead),
+		FramesWritten: atomic.LoadUint64(&c.framesWritten),
+	}
+}
+
+// Histogram is a snapshot of a histogram of durations.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets in ascending order.
+	Buckets []time.Duration
+
+	// Counts are the numbers of observations falling into each bucket, i.e. greater than the previous bound and
+	// less than or equal to the bound of the bucket, the extra last one counts the observations beyond all bounds.
+	Counts []uint64
+
+	// Count is the total number of observations.
+	Count uint64
+
+	// Sum is the sum of all observations.
+	Sum time.Duration
+}
+
+// histogram counts the observations of durations in buckets atomically.
+type histogram struct {
+	count  uint64 // count and sum are updated atomically, keep them first for the alignment on 32-bit platforms
+	sum    int64
+	bounds []time.Duration
+	counts []uint64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	if h == nil {
+		return Histogram{}
+	}
+	hs := Histogram{
+		Buckets: append([]time.Duration(nil), h.bounds...),
+		Counts:  make([]uint64, len(h.counts)),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		hs.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return hs
+}
+
+type labelPair struct {
+	key, value string
+}
+
+// metricsCollector aggregates the traffic of connections by their labels.
+type metricsCollector struct {
+	labels    sync.Map   // labelPair -> *counters
+	firstByte *histogram // latency from accepting connections to their first inbound bytes
+	connAge   *histogram // how long connections live
+}
+
+func (mc *metricsCollector) initHistograms(opts *Options) {
+	firstByte, connAge := opts.FirstByteBuckets, opts.ConnAgeBuckets
+	if len(firstByte) == 0 {
+		firstByte = DefaultFirstByteBuckets
+	}
+	if len(connAge) == 0 {
+		connAge = DefaultConnAgeBuckets
+	}
+	mc.firstByte, mc.connAge = newHistogram(firstByte), newHistogram(connAge)
+}
+
+// trackFirstByte records the latency from accepting the connection to its first inbound bytes,
+// it must be called right after the connection reads.
+func (mc *metricsCollector) trackFirstByte(cm *connMetrics) {
+	if !cm.firstRead {
+		cm.firstRead = true
+		mc.firstByte.observe(cm.lastActive.Sub(cm.acceptedAt))
+	}
+}
+
+// trackClose records the age of the connection which is being closed.
+func (mc *metricsCollector) trackClose(cm *connMetrics) {
+	mc.connAge.observe(time.Since(cm.acceptedAt))
+}
+
+func (mc *metricsCollector) countersOf(key, value string) *counters {
+	lp := labelPair{key, value}
+	if v, ok := mc.labels.Load(lp); ok {
+		return v.(*counters)
+	}
+	v, _ := mc.labels.LoadOrStore(lp, new(counters))
+	return v.(*counters)
+}
+
+// labelStats returns the aggregated stats of each value of the given label key.
+func (mc *metricsCollector) labelStats(key string) map[string]Stats {
+	stats := make(map[string]Stats)
+	mc.labels.Range(func(k, v interface{}) bool {
+		if lp := k.(labelPair); lp.key == key {
+			stats[lp.value] = v.(*counters).snapshot()
+		}
+		return true
+	})
+	return stats
+}
+
+// connMetrics is embedded in connections to attribute their traffic to the labels they carry.
+type connMetrics struct {
+	// stats is updated atomically, it must stay as the first field for the 64-bit alignment on 32-bit platforms,
+	// along with connMetrics embedded as the first field of the connection.
+	stats      counters          // traffic counters of the connection since the last reset
+	labels     map[string]string // user-defined labels
+	counters   []*counters       // counters of each label
+	lastActive time.Time         // time of the last read or write
+	acceptedAt time.Time         // time of accepting the connection
+	firstRead  bool              // whether the connection has read any bytes
+	received   uint64            // bytes read from the connection
+	written    uint64            // bytes written to the connection
+}
+
+func (cm *connMetrics) setLabels(mc *metricsCollector, labels map[string]string) {
+	cm.resetLabels()
+	if len(labels) == 0 {
+		return
+	}
+	cm.labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		cm.labels[k] = v
+		cc := mc.countersOf(k, v)
+		atomic.AddInt64(&cc.connections, 1)
+		cm.counters = append(cm.counters, cc)
+	}
+}
+
+func (cm *connMetrics) resetLabels() {
+	for _, cc := range cm.counters {
+		atomic.AddInt64(&cc.connections, -1)
+	}
+	cm.labels = nil
+	cm.counters = cm.counters[:0]
+}
+
+func (cm *connMetrics) addRead(n int, now time.Time) {
+	cm.lastActive = now
+	cm.received += uint64(n)
+	atomic.AddUint64(&cm.stats.bytesRead, uint64(n))
+	for _, cc := range cm.counters {
+		atomic.AddUint64(&cc.bytesRead, uint64(n))
+	}
+}
+
+func (cm *connMetrics) addWritten(n int, now time.Time) {
+	cm.lastActive = now
+	cm.written += uint64(n)
+	atomic.AddUint64(&cm.stats.bytesWritten, uint64(n))
+	for _, cc := range cm.counters {
+		atomic.AddUint64(&cc.bytesWritten, uint64(n))
+	}
+}
+
+func (cm *connMetrics) addFrameRead() {
+	atomic.AddUint64(&cm.stats.framesRead, 1)
+	for _, cc := range cm.counters {
+		atomic.AddUint64(&cc.framesRead, 1)
+	}
+}
+
+func (cm *connMetrics) addFrameWritten() {
+	atomic.AddUint64(&cm.stats.framesWritten, 1)
+	for _, cc := range cm.counters {
+		atomic.AddUint64(&cc.framesWritten, 1)
+	}
+}
+
+// resetStats zeroes the traffic counters of the connection and returns their values before the reset.
+func (cm *connMetrics) resetStats() Stats {
+	return Stats{
+		BytesRead:     atomic.SwapUint64(&cm.stats.bytesRead, 0),
+		BytesWritten:  atomic.SwapUint64(&cm.stats.bytesWritten, 0),
+		FramesRead:    atomic.SwapUint64(&cm.stats.framesRead, 0),
+		FramesWritten: atomic.SwapUint64(&cm.stats.framesWritten, 0),
+	}
+}
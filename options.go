@@ -25,7 +25,9 @@ import (
 
 	"go.uber.org/zap/zapcore"
 
+	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/logging"
+	"github.com/panjf2000/gnet/pool/goroutine"
 )
 
 // Option is a function that will set up option.
@@ -39,6 +41,26 @@ func loadOptions(options ...Option) *Options {
 	return opts
 }
 
+// ValidateOptions checks the combination of the options the same way as Serve does, without binding anything,
+// so that a configuration can be validated at startup or in tests ahead of serving, it returns the error that
+// Serve would return for the options.
+func ValidateOptions(options ...Option) error {
+	return loadOptions(options...).validate()
+}
+
+// validate checks the combination of the options, it is shared by Serve and ValidateOptions.
+func (opts *Options) validate() error {
+	// The maximum number of operating system threads that the Go program can use is initially set to 10000,
+	// which should also be the maximum amount of I/O event-loops locked to OS threads that users can start up.
+	if opts.LockOSThread && opts.NumEventLoop > 10000 {
+		return errors.ErrTooManyEventLoopThreads
+	}
+	if newLoadBalancer(opts.LB, nil, 0) == nil {
+		return errors.ErrInvalidLoadBalancing
+	}
+	return nil
+}
+
 // TCPSocketOpt is the type of TCP socket options.
 type TCPSocketOpt int
 
@@ -48,6 +70,18 @@ const (
 	TCPDelay
 )
 
+// OutboundOverflow is the way of handling the data that doesn't fit in MaxOutboundBuffer.
+type OutboundOverflow int
+
+// Available ways of handling the data that doesn't fit in MaxOutboundBuffer.
+const (
+	// RejectOverflow rejects the write with errors.ErrWriteBufferOverflow, no byte of it is sent.
+	RejectOverflow OutboundOverflow = iota
+	// StreamOverflow holds back the data that doesn't fit and moves it into the outbound buffer in chunks
+	// as the socket drains.
+	StreamOverflow
+)
+
 // Options are set when the client opens.
 type Options struct {
 	// Multicore indicates whether the server will be effectively created with multi-cores, if so,
@@ -69,7 +103,28 @@ type Options struct {
 	// or equal to its real amount.
 	ReadBufferCap int
 
-	// LB represents the load-balancing algorithm used when assigning new connections.
+	// MaxInboundBuffer is the maximum number of bytes a TCP connection is allowed to buffer without the codec producing
+	// frames out of them, the connection is closed with errors.ErrInboundBufferOverflow passed to OnClosed once it
+	// exceeds the limit, which prevents clients sending partial huge frames from pinning memory indefinitely.
+	// It is unlimited by default.
+	MaxInboundBuffer int
+
+	// BufferShrinkInterval is the interval of shrinking the inbound and outbound buffers of the connections which
+	// have been idle for at least the interval, the buffers grown by bursts of data are reallocated with the smallest
+	// capacity that holds the buffered data, which is no less than the initial size of the buffers, reducing the
+	// steady-state memory for bursty workloads. It is disabled by default.
+	BufferShrinkInterval time.Duration
+
+	// UDPMaxDatagramSize is the size of the buffer reused by each event-loop for reading UDP datagrams,
+	// datagrams larger than it are truncated. It defaults to ReadBufferCap, the default 64KB of which covers
+	// the largest datagrams over IPv4 and IPv6 (65507 and 65527 bytes of payload), it can be reduced to the path
+	// MTU minus the IP and UDP headers (e.g. 1472 bytes over Ethernet with IPv4) to save memory if the protocol
+	// never relies on IP fragmentation, or be enlarged only if IPv6 jumbograms are expected.
+	// Note that the datagram passed to React is only valid until React returns as the buffer is reused.
+	UDPMaxDatagramSize int
+
+	// LB represents the load-balancing algorithm used when assigning new connections, it can be switched
+	// by Server.SetLoadBalancing at runtime, or to a custom LoadBalancer by Server.SetLoadBalancer.
 	LB LoadBalancing
 
 	// NumEventLoop is set up to start the given number of event-loop goroutine.
@@ -101,6 +156,11 @@ type Options struct {
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
 
+	// LazyOnOpened defers OnOpened of TCP connections until the first inbound data arrives, which spares the
+	// resources for clients that connect and vanish without sending anything, OnClosed won't fire either for
+	// connections that are closed before OnOpened.
+	LazyOnOpened bool
+
 	// LogPath the local path where logs will be written, this is the easiest way to set up client logs,
 	// the client instantiates a default uber-go/zap logger with this given log path, you are also allowed to employ
 	// you own logger during the client lifetime by implementing the following log.Logger interface.
@@ -114,6 +174,176 @@ type Options struct {
 	// Logger is the customized logger for logging info, if it is not set,
 	// then gnet will use the default logger powered by go.uber.org/zap.
 	Logger logging.Logger
+
+	// ErrChan receives the errors occurred in background, which are otherwise only logged, including:
+	// failures of accepting connections, errors that make event-loops or the main reactor exit, and failures of
+	// flushing data written by AsyncWrite or returned by React. Errors are published without blocking, they are
+	// dropped if the channel is full and the number of dropped errors can be retrieved by Server.DroppedErrors.
+	ErrChan chan error
+
+	// FdLimitThreshold is the ratio of the open file descriptors of the process to the RLIMIT_NOFILE soft limit,
+	// e.g. 0.9, over which the server sheds load by pausing accepting new connections and fires OnNearFdLimit of
+	// FdLimitHandler, accepting is resumed once the usage drops below the threshold. The usage is checked when
+	// the server starts and on every accepted connection by its descriptor, which is the lowest one available,
+	// accepting is also paused when the limit is hit by accept(2), rather than failing the server. The usage is
+	// only sampled every second while accepting is paused.
+	// It is disabled by default and only supported by TCP and Unix servers on unix platforms.
+	FdLimitThreshold float64
+
+	// ResumeToken extracts the resume token from the first inbound data of a TCP connection, it is invoked once for
+	// every connection, with the data received by the first read. Connections carrying the same token are served by
+	// the same event-loop, a connection is migrated to that event-loop along with its buffered data if it was
+	// assigned to another one, the following events of the connection fire in the new event-loop. Since the
+	// migration takes place in the middle of the events, the Conn shouldn't be used outside its event-loop before
+	// the first inbound data is handled. It is only supported on unix platforms.
+	ResumeToken func(c Conn, firstBytes []byte) (token string, ok bool)
+
+	// WorkerPool turns on the async mode, in which React of TCP connections runs on the given worker pool rather
+	// than event-loops, the frames of a connection are still reacted to in order. The pool must be non-blocking like
+	// goroutine.Default(), when it is saturated, the reads of the affected connections are paused until their frames
+	// are handed over to the pool, instead of blocking the event-loop or dropping the frames, the saturation can be
+	// observed via Server.WorkerPoolStats. It is only supported on unix platforms.
+	WorkerPool *goroutine.Pool
+
+	// PortRangeMin and PortRangeMax make the server listening on an address with port 0 bind to the first free port
+	// within [PortRangeMin, PortRangeMax], starting from a random port in the range, instead of an ephemeral port
+	// picked by the kernel. The chosen port is available via Server.Addr. Note that a port occupied by other
+	// sockets with SO_REUSEPORT can't be told apart from a free one when the server binds with SO_REUSEPORT as well,
+	// which is always the case for UDP.
+	PortRangeMin, PortRangeMax int
+
+	// FirstByteBuckets are the upper bounds of the buckets of the histogram of the latency from accepting TCP
+	// connections to their first inbound bytes, DefaultFirstByteBuckets is used if it is empty.
+	FirstByteBuckets []time.Duration
+
+	// ConnAgeBuckets are the upper bounds of the buckets of the histogram of how long TCP connections live,
+	// DefaultConnAgeBuckets is used if it is empty.
+	ConnAgeBuckets []time.Duration
+
+	// MaxQueuedFrames is the maximum number of frames of a connection that are handed over to the worker pool but
+	// haven't been reacted to yet, the reads of the connection are paused once it is reached and resumed after the
+	// frames are drained below it, which keeps a fast sender from piling up frames for a slow handler. It is checked
+	// after every read, so the frames decoded from a single read can exceed it. It only works with WorkerPool and
+	// 0 means no limit.
+	MaxQueuedFrames int
+
+	// RandSeed seeds the pseudo-random number generators returned by Conn.Rand, the generator of every event-loop is
+	// seeded with the sum of RandSeed and the index of the event-loop, so that the sequences are reproducible across
+	// runs. The current time is used instead if it is 0.
+	RandSeed int64
+
+	// AcceptQueueThreshold is the length of the kernel accept queue of a listener, at which a warning is logged
+	// as the server can't keep up with the rate of new connections, the warning is logged again only after the
+	// queue drops below the threshold. The queue is inspected on every accept, see Server.AcceptQueueLen for
+	// the supported platforms. It is disabled by default.
+	AcceptQueueThreshold int
+
+	// ConnInitFunc is invoked once for every TCP connection right after it is accepted and before OnOpened, which
+	// is meant for setting up per-connection resources like encoders or scratch buffers by Conn.SetContext, so that
+	// the expensive setup is kept out of the event callbacks. It may run on the goroutine accepting connections
+	// rather than the event-loop of the connection, thus only SetContext and the getters of Conn should be called.
+	ConnInitFunc func(c Conn)
+
+	// RawMode turns off the codec and React for TCP connections, OnReadable fires for the inbound data instead,
+	// which gives full control over the inbound buffer to protocols that don't fit the frame model, and the data
+	// written to connections is sent as it is. OnOpened and OnHandshake still fire as usual. The event handler
+	// must implement RawReader, otherwise Serve returns errors.ErrRawModeUnhandled.
+	RawMode bool
+
+	// MaxTotalBufferMemory is the maximum number of bytes buffered in the inbound and outbound buffers across all
+	// TCP connections, which protects the process against many connections buffering up to their own limits at once.
+	// Once the total exceeds it, new connections are refused and the reads of the connections that are able to drain
+	// their buffered data, i.e. with data pending to be sent or frames pending on the worker pool, are paused from
+	// the largest one until the total drops below it, the current total is reported by Server.BufferMemory.
+	// It is unlimited by default and not supported on Windows.
+	MaxTotalBufferMemory int
+
+	// ConnLogSampling is the fraction in (0, 1] of the log lines of connections being opened and closed that are
+	// emitted at the debug level, which keeps the logs useful under high connection churn. The lines of connections
+	// closed due to errors are always emitted along with the errors. All the lines are emitted by default.
+	ConnLogSampling float64
+
+	// ProbeFrame is the frame written to the connection by Conn.Probe after the checks, for protocols whose peers
+	// respond to a ping or heartbeat frame at application level. It is written as it is without the codec.
+	ProbeFrame []byte
+
+	// SocketControl is invoked on the listening socket before it is bound, which allows setting socket options
+	// that are not exposed by gnet, the same as net.ListenConfig.Control, the server fails to start if it
+	// returns an error.
+	SocketControl func(fd uintptr) error
+
+	// AcceptedSocketControl is invoked on every accepted socket before the connection is opened,
+	// the connection is closed if it returns an error.
+	AcceptedSocketControl func(fd uintptr) error
+
+	// DedicatedAcceptLoop keeps the main reactor of TCP servers to nothing but accepting connections, which isolates
+	// the latency of establishing connections from the I/O load: the accepted sockets are set up by the event-loops
+	// they are assigned to rather than the main reactor, and Tick is driven by the first event-loop instead.
+	// It takes precedence over ReusePort, which would otherwise have every event-loop accept connections along with
+	// serving them. It is meant for busy servers facing high rates of new connections, where the tail latency of
+	// establishing connections would otherwise include the time spent on the I/O of existing connections. It only
+	// pays off with a CPU core to spare for the main reactor: on a single core saturated by the I/O, the p99 of
+	// BenchmarkDedicatedAcceptLoop stays around 10ms either way. It has no effect on UDP servers and on Windows,
+	// where connections are always accepted by a dedicated goroutine.
+	DedicatedAcceptLoop bool
+
+	// MaxConnAge is the maximum duration a TCP connection is allowed to live, the connections exceeding it are closed
+	// gracefully once their outbound data is flushed, which makes clients reconnect and lets load balancers
+	// redistribute the traffic, e.g. after scaling events. The ages are checked every tenth of MaxConnAge, up to
+	// every second, thus connections may live slightly longer. It is unlimited by default.
+	MaxConnAge time.Duration
+
+	// MaxConnAgeFrame is the frame written as it is to the connections right before they are closed due to
+	// MaxConnAge, e.g. a notice asking the client to reconnect.
+	MaxConnAgeFrame []byte
+
+	// NoInboundBuffer hands the data of every read over to React as a frame straight away, without running it
+	// through the Decode of codec or buffering it in the inbound ring buffer, which is meant for the request/response
+	// protocols where every read is a complete message. The frame refers to the read buffer of the event-loop,
+	// thus the handler must consume it entirely before React returns, nothing of it is kept for the next read.
+	// The codec still encodes the outbound data.
+	NoInboundBuffer bool
+
+	// ShutdownGrace is the period that every event-loop takes to flush its connections when the server shuts down,
+	// either by the Shutdown action or by Stop. The ordering is as follows: the event-loop stops reading its
+	// connections, then ShutdownFrame is written to every connection behind the data already written to it, e.g.
+	// the data returned by the React which returns Shutdown, then the event-loop waits up to the grace period for
+	// the outbound buffers of the connections to be flushed, and finally the connections are closed with OnClosed
+	// fired, the data still pending by then is handled as specified by Conn.SetCloseBehavior. The event-loops are
+	// drained in parallel, thus the shutdown takes up to ShutdownGrace longer. By default, the connections are
+	// closed right away with a best-effort attempt to send the pending data.
+	ShutdownGrace time.Duration
+
+	// ShutdownFrame is the frame written as it is to every connection when the server shuts down with ShutdownGrace,
+	// e.g. a notice asking the client to drain and reconnect elsewhere.
+	ShutdownFrame []byte
+
+	// MaxOutboundBuffer is the maximum number of bytes held in the outbound buffer of a TCP connection, a write
+	// whose data would take the outbound buffer over the limit, which is always the case for a write larger than
+	// the limit, is handled according to OutboundOverflow, the data is never truncated. It is unlimited by default
+	// and it is ignored on Windows where the writes are not buffered.
+	MaxOutboundBuffer int
+
+	// OutboundOverflow decides how a write that doesn't fit in MaxOutboundBuffer is handled, it is rejected by default.
+	OutboundOverflow OutboundOverflow
+
+	// AcceptBatchSize is the maximum number of accepted connections handed over to an event-loop at once, the main
+	// reactor stages the accepted sockets of each event-loop and the event-loop sets up a batch of them, i.e.
+	// allocating their buffers and firing OnOpened, in a single task once AcceptBatchSize sockets are staged or
+	// AcceptBatchDelay elapses since the first of them. This trades the latency of establishing each connection,
+	// which grows by up to AcceptBatchDelay, for fewer wake-ups of the event-loops and less interference with the
+	// I/O of existing connections under bursts of new connections. It is disabled unless it's greater than 1 and
+	// it only works for TCP servers with the main reactor accepting connections, i.e. without ReusePort or with
+	// DedicatedAcceptLoop, it's ignored on Windows.
+	AcceptBatchSize int
+
+	// AcceptBatchDelay is the maximum time for which an accepted socket is staged by AcceptBatchSize,
+	// it is 1ms by default.
+	AcceptBatchDelay time.Duration
+
+	// ConnRegistry keeps the TCP connections keyed by their identifiers for Server.WriteTo, which costs an update
+	// of a map shared by all event-loops for every connection opened and closed. It is disabled by default.
+	ConnRegistry bool
 }
 
 // WithOptions sets up all options.
@@ -144,6 +374,27 @@ func WithReadBufferCap(readBufferCap int) Option {
 	}
 }
 
+// WithMaxInboundBuffer sets up the maximum number of bytes buffered without being decoded into frames.
+func WithMaxInboundBuffer(size int) Option {
+	return func(opts *Options) {
+		opts.MaxInboundBuffer = size
+	}
+}
+
+// WithBufferShrinkInterval sets up the interval of shrinking the buffers of idle connections.
+func WithBufferShrinkInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.BufferShrinkInterval = interval
+	}
+}
+
+// WithUDPMaxDatagramSize sets up the maximum size of UDP datagrams.
+func WithUDPMaxDatagramSize(size int) Option {
+	return func(opts *Options) {
+		opts.UDPMaxDatagramSize = size
+	}
+}
+
 // WithLoadBalancing sets up the load-balancing algorithm in gnet server.
 func WithLoadBalancing(lb LoadBalancing) Option {
 	return func(opts *Options) {
@@ -207,6 +458,13 @@ func WithCodec(codec ICodec) Option {
 	}
 }
 
+// WithLazyOnOpened sets up the deferring of OnOpened until the first inbound data arrives.
+func WithLazyOnOpened(lazy bool) Option {
+	return func(opts *Options) {
+		opts.LazyOnOpened = lazy
+	}
+}
+
 // WithLogPath is an option to set up the local path of log file.
 func WithLogPath(fileName string) Option {
 	return func(opts *Options) {
@@ -227,3 +485,187 @@ func WithLogger(logger logging.Logger) Option {
 		opts.Logger = logger
 	}
 }
+
+// WithErrChan sets up a channel to receive errors occurred in background.
+func WithErrChan(errChan chan error) Option {
+	return func(opts *Options) {
+		opts.ErrChan = errChan
+	}
+}
+
+// WithFdLimitThreshold sets up the ratio of open file descriptors to RLIMIT_NOFILE for shedding load.
+func WithFdLimitThreshold(threshold float64) Option {
+	return func(opts *Options) {
+		opts.FdLimitThreshold = threshold
+	}
+}
+
+// WithResumeToken sets up the function extracting resume tokens for pinning connections to event-loops.
+func WithResumeToken(resumeToken func(c Conn, firstBytes []byte) (token string, ok bool)) Option {
+	return func(opts *Options) {
+		opts.ResumeToken = resumeToken
+	}
+}
+
+// WithWorkerPool sets up the worker pool for running React in async mode.
+func WithWorkerPool(pool *goroutine.Pool) Option {
+	return func(opts *Options) {
+		opts.WorkerPool = pool
+	}
+}
+
+// WithPortRange sets up the range of ports to pick from when listening on an address with port 0.
+func WithPortRange(min, max int) Option {
+	return func(opts *Options) {
+		opts.PortRangeMin, opts.PortRangeMax = min, max
+	}
+}
+
+// WithHistogramBuckets sets up the buckets of the histograms of the first-byte latency and the age of connections.
+func WithHistogramBuckets(firstByte, connAge []time.Duration) Option {
+	return func(opts *Options) {
+		opts.FirstByteBuckets, opts.ConnAgeBuckets = firstByte, connAge
+	}
+}
+
+// WithMaxQueuedFrames sets up the maximum number of outstanding frames of a connection in async mode.
+func WithMaxQueuedFrames(n int) Option {
+	return func(opts *Options) {
+		opts.MaxQueuedFrames = n
+	}
+}
+
+// WithRandSeed sets up the seed of the pseudo-random number generators of event-loops.
+func WithRandSeed(seed int64) Option {
+	return func(opts *Options) {
+		opts.RandSeed = seed
+	}
+}
+
+// WithAcceptQueueThreshold sets up the length of the accept queue at which a warning is logged.
+func WithAcceptQueueThreshold(threshold int) Option {
+	return func(opts *Options) {
+		opts.AcceptQueueThreshold = threshold
+	}
+}
+
+// WithConnInitFunc sets up the function that initializes every TCP connection before OnOpened.
+func WithConnInitFunc(init func(c Conn)) Option {
+	return func(opts *Options) {
+		opts.ConnInitFunc = init
+	}
+}
+
+// WithRawMode sets up the raw mode in which OnReadable fires instead of the codec and React.
+func WithRawMode(raw bool) Option {
+	return func(opts *Options) {
+		opts.RawMode = raw
+	}
+}
+
+// WithMaxTotalBufferMemory sets up the maximum number of bytes buffered across all connections.
+func WithMaxTotalBufferMemory(size int) Option {
+	return func(opts *Options) {
+		opts.MaxTotalBufferMemory = size
+	}
+}
+
+// WithConnLogSampling sets up the fraction of the connection lifecycle log lines to emit.
+func WithConnLogSampling(rate float64) Option {
+	return func(opts *Options) {
+		opts.ConnLogSampling = rate
+	}
+}
+
+// WithProbeFrame sets up the frame written to connections by Conn.Probe.
+func WithProbeFrame(frame []byte) Option {
+	return func(opts *Options) {
+		opts.ProbeFrame = frame
+	}
+}
+
+// WithSocketControl sets up the function invoked on the listening socket before it is bound.
+func WithSocketControl(control func(fd uintptr) error) Option {
+	return func(opts *Options) {
+		opts.SocketControl = control
+	}
+}
+
+// WithAcceptedSocketControl sets up the function invoked on every accepted socket.
+func WithAcceptedSocketControl(control func(fd uintptr) error) Option {
+	return func(opts *Options) {
+		opts.AcceptedSocketControl = control
+	}
+}
+
+// WithDedicatedAcceptLoop sets up the main reactor to do nothing but accept connections.
+func WithDedicatedAcceptLoop(dedicated bool) Option {
+	return func(opts *Options) {
+		opts.DedicatedAcceptLoop = dedicated
+	}
+}
+
+// WithMaxConnAge sets up the maximum duration connections are allowed to live.
+func WithMaxConnAge(maxAge time.Duration) Option {
+	return func(opts *Options) {
+		opts.MaxConnAge = maxAge
+	}
+}
+
+// WithMaxConnAgeFrame sets up the frame written to connections before they are closed due to MaxConnAge.
+func WithMaxConnAgeFrame(frame []byte) Option {
+	return func(opts *Options) {
+		opts.MaxConnAgeFrame = frame
+	}
+}
+
+// WithNoInboundBuffer sets up React to take the data of every read as a frame without buffering or decoding it.
+func WithNoInboundBuffer(noBuffer bool) Option {
+	return func(opts *Options) {
+		opts.NoInboundBuffer = noBuffer
+	}
+}
+
+// WithShutdownGrace sets up the period for flushing the connections when the server shuts down.
+func WithShutdownGrace(grace time.Duration) Option {
+	return func(opts *Options) {
+		opts.ShutdownGrace = grace
+	}
+}
+
+// WithShutdownFrame sets up the frame written to every connection when the server shuts down with ShutdownGrace.
+func WithShutdownFrame(frame []byte) Option {
+	return func(opts *Options) {
+		opts.ShutdownFrame = frame
+	}
+}
+
+// WithMaxOutboundBuffer sets up the maximum number of bytes held in the outbound buffer of a connection.
+func WithMaxOutboundBuffer(size int) Option {
+	return func(opts *Options) {
+		opts.MaxOutboundBuffer = size
+	}
+}
+
+// WithOutboundOverflow sets up the way of handling the writes that don't fit in MaxOutboundBuffer.
+func WithOutboundOverflow(overflow OutboundOverflow) Option {
+	return func(opts *Options) {
+		opts.OutboundOverflow = overflow
+	}
+}
+
+// WithAcceptBatching sets up handing the accepted connections over to the event-loops in batches of up to maxBatch
+// connections, each of them is staged for up to maxDelay.
+func WithAcceptBatching(maxBatch int, maxDelay time.Duration) Option {
+	return func(opts *Options) {
+		opts.AcceptBatchSize = maxBatch
+		opts.AcceptBatchDelay = maxDelay
+	}
+}
+
+// WithConnRegistry sets up keeping the connections keyed by their identifiers for Server.WriteTo.
+func WithConnRegistry(registry bool) Option {
+	return func(opts *Options) {
+		opts.ConnRegistry = registry
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+// rejectOverflow reports whether the frame is to be rejected for not fitting in MaxOutboundBuffer.
+func (c *conn) rejectOverflow(frame []byte) bool {
+	max := c.owner().svr.opts.MaxOutboundBuffer
+	return max > 0 && c.owner().svr.opts.OutboundOverflow == RejectOverflow && c.outboundLength()+len(frame) > max
+}
+
+// bufferOutbound appends the data to the outbound buffer, the part of it that doesn't fit in MaxOutboundBuffer
+// is held back and moved into the outbound buffer by refillOutbound as the socket drains.
+func (c *conn) bufferOutbound(data []byte) {
+	if max := c.owner().svr.opts.MaxOutboundBuffer; max > 0 {
+		// Anything held back goes first, so is the data behind it.
+		if len(c.overflow) > 0 {
+			c.overflow = append(c.overflow, data...)
+			return
+		}
+		if free := max - c.outboundBuffer.Length(); len(data) > free {
+			if free < 0 {
+				free = 0
+			}
+			c.overflow = append(c.overflow, data[free:]...)
+			data = data[:free]
+		}
+	}
+	_, _ = c.outboundBuffer.Write(data)
+	c.accountBuffers()
+}
+
+// refillOutbound moves the data held back into the free space of the outbound buffer.
+func (c *conn) refillOutbound() {
+	if len(c.overflow) == 0 {
+		return
+	}
+	n := c.owner().svr.opts.MaxOutboundBuffer - c.outboundBuffer.Length()
+	if n <= 0 {
+		return
+	}
+	if n >= len(c.overflow) {
+		_, _ = c.outboundBuffer.Write(c.overflow)
+		c.overflow = nil
+		return
+	}
+	_, _ = c.outboundBuffer.Write(c.overflow[:n])
+	c.overflow = c.overflow[n:]
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/socket"
+)
+
+func (c *conn) Probe() error {
+	if c.pollAttachment == nil {
+		return gerrors.ErrUnsupportedOp
+	}
+	if !c.opened {
+		return nil
+	}
+	err := c.probe()
+	if err != nil {
+		// OnPeerUnreachable fires after the current event callback returns.
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopPeerUnreachable(c, err) }, nil, false)
+	}
+	return err
+}
+
+// probe checks whether the peer of the connection is still reachable, it returns the error that reveals otherwise.
+func (c *conn) probe() error {
+	// A zero-byte write fails on the socket that has been reset by the peer without sending anything.
+	if _, err := unix.Write(c.fd, nil); err != nil && err != unix.EAGAIN {
+		return os.NewSyscallError("write", err)
+	}
+	if err := socket.GetSocketError(c.fd); err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	// TCP_INFO isn't available for all platforms and protocols, the peer is deemed reachable without it.
+	if reachable, err := socket.IsPeerReachable(c.fd); err == nil && !reachable {
+		return gerrors.ErrPeerUnreachable
+	}
+	if frame := c.owner().svr.opts.ProbeFrame; len(frame) > 0 {
+		return c.writeFrame(frame)
+	}
+	return nil
+}
+
+// loopPeerUnreachable fires OnPeerUnreachable for the connection which fails the probe.
+func (el *eventloop) loopPeerUnreachable(c *conn, err error) error {
+	if !c.opened {
+		return nil
+	}
+	switch onPeerUnreachable(el.eventHandler, c, err) {
+	case Close:
+		return el.loopCloseConn(c, err)
+	case Shutdown:
+		return gerrors.ErrServerShutdown
+	}
+	return nil
+}
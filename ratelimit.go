@@ -0,0 +1,77 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import "time"
+
+// inboundRateLimit throttles the frames decoded from a connection with a token bucket, see Conn.SetInboundRateLimit.
+type inboundRateLimit struct {
+	rate   float64 // tokens refilled per second
+	burst  float64 // capacity of the bucket
+	tokens float64
+	last   time.Time
+
+	timer  *time.Timer // pending resumption of the throttled connection
+	paused bool        // reads paused by the limit
+}
+
+func newInboundRateLimit(framesPerSecond, burst int) *inboundRateLimit {
+	if burst < 1 {
+		burst = 1
+	}
+	return &inboundRateLimit{
+		rate:   float64(framesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait refills the bucket and returns how long it takes for a token to be available, 0 if there is one already.
+func (rl *inboundRateLimit) wait() time.Duration {
+	now := time.Now()
+	if rl.tokens += now.Sub(rl.last).Seconds() * rl.rate; rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+	if rl.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
+
+// take takes a token for a decoded frame.
+func (rl *inboundRateLimit) take() {
+	rl.tokens--
+}
+
+// throttled reports whether the connection is waiting for a token.
+func (rl *inboundRateLimit) throttled() bool {
+	return rl != nil && rl.timer != nil
+}
+
+// onRateLimited fires OnRateLimited if the event handler implements RateLimitHandler.
+func onRateLimited(eh EventHandler, c Conn) Action {
+	if h, ok := eh.(RateLimitHandler); ok {
+		return h.OnRateLimited(c)
+	}
+	return None
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"time"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+)
+
+func (c *conn) SetInboundRateLimit(framesPerSecond, burst int) {
+	old := c.rateLimit
+	c.rateLimit = nil
+	if framesPerSecond > 0 {
+		c.rateLimit = newInboundRateLimit(framesPerSecond, burst)
+	}
+	if old.throttled() {
+		old.timer.Stop()
+		c.owner().resumeRate(c, old)
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopRedecode(c) }, nil, false)
+	}
+}
+
+// loopThrottle pauses reading the connection that runs out of tokens until the next token is available.
+func (el *eventloop) loopThrottle(c *conn, wait time.Duration) error {
+	switch onRateLimited(el.eventHandler, c) {
+	case Close:
+		return el.loopCloseConn(c, gerrors.ErrInboundRateLimited)
+	case Shutdown:
+		return gerrors.ErrServerShutdown
+	}
+	rl := c.rateLimit
+	if !c.readPaused {
+		c.readPaused, rl.paused = true, true
+		_ = el.poller.PauseRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+	}
+	rl.timer = time.AfterFunc(wait, func() {
+		_ = c.trigger(func(_ interface{}) error { return c.owner().loopResumeRate(c, rl) }, nil, false)
+	})
+	return nil
+}
+
+// loopResumeRate resumes reading the connection throttled by loopThrottle and decodes the data buffered in it.
+func (el *eventloop) loopResumeRate(c *conn, rl *inboundRateLimit) error {
+	if !c.opened || rl.timer == nil {
+		return nil // stale resumption
+	}
+	el.resumeRate(c, rl)
+	return el.loopRedecode(c)
+}
+
+func (el *eventloop) resumeRate(c *conn, rl *inboundRateLimit) {
+	rl.timer = nil
+	if !rl.paused || c.drainClose {
+		return
+	}
+	rl.paused = false
+	c.readPaused = false
+	_ = el.poller.ResumeRead(c.pollAttachment, !c.outboundBuffer.IsEmpty())
+}
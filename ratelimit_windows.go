@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build windows
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+func (c *stdConn) SetInboundRateLimit(framesPerSecond, burst int) {
+	old := c.rateLimit
+	c.rateLimit = nil
+	if framesPerSecond > 0 {
+		c.rateLimit = newInboundRateLimit(framesPerSecond, burst)
+	}
+	if old.throttled() {
+		old.timer.Stop()
+		old.timer = nil
+		// It runs on the event-loop goroutine, which would block itself by sending a task to its full channel.
+		_ = c.loop.loopRedecode(c)
+	}
+}
+
+// loopThrottle holds the data buffered in the connection that runs out of tokens until the next token is available.
+func (el *eventloop) loopThrottle(c *stdConn, wait time.Duration) error {
+	switch onRateLimited(el.eventHandler, c) {
+	case Close:
+		return el.loopError(c, errors.ErrInboundRateLimited)
+	case Shutdown:
+		return errors.ErrServerShutdown
+	}
+	rl := c.rateLimit
+	rl.timer = time.AfterFunc(wait, func() {
+		task := signalTaskPool.Get().(*signalTask)
+		task.run = func(c *stdConn) error { return c.loop.loopResumeRate(c, rl) }
+		task.c = c
+		if !el.trigger(task) {
+			signalTaskPool.Put(task)
+		}
+	})
+	return nil
+}
+
+// loopResumeRate decodes the data buffered in the connection throttled by loopThrottle.
+func (el *eventloop) loopResumeRate(c *stdConn, rl *inboundRateLimit) error {
+	if _, ok := el.connections[c]; !ok || rl.timer == nil {
+		return nil // stale resumption
+	}
+	rl.timer = nil
+	return el.loopRedecode(c)
+}
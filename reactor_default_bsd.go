@@ -43,6 +43,7 @@ func (el *eventloop) activateMainReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("main reactor is exiting in terms of the demand from user, %v", err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("main reactor is exiting due to error: %v", err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -59,11 +60,16 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 
 	err := el.poller.Polling(func(fd int, filter int16) (err error) {
 		if c, ack := el.connections[fd]; ack {
+			if proceed, err := el.loopEvent(c, eventFlags(filter)); !proceed {
+				return err
+			}
+
 			switch filter {
 			case netpoll.EVFilterSock:
+				c.closeCause = ClosePeerFIN
 				err = el.loopCloseConn(c, nil)
 			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
+				if c.writePending() {
 					err = el.loopWrite(c)
 				}
 			case netpoll.EVFilterRead:
@@ -76,6 +82,7 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("event-loop(%d) is exiting in terms of the demand from user, %v", el.idx, err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("event-loop(%d) is exiting normally on the signal error: %v", el.idx, err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -93,11 +100,16 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 
 	err := el.poller.Polling(func(fd int, filter int16) (err error) {
 		if c, ack := el.connections[fd]; ack {
+			if proceed, err := el.loopEvent(c, eventFlags(filter)); !proceed {
+				return err
+			}
+
 			switch filter {
 			case netpoll.EVFilterSock:
+				c.closeCause = ClosePeerFIN
 				err = el.loopCloseConn(c, nil)
 			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
+				if c.writePending() {
 					err = el.loopWrite(c)
 				}
 			case netpoll.EVFilterRead:
@@ -108,4 +120,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 		return el.loopAccept(filter)
 	})
 	el.getLogger().Debugf("event-loop(%d) is exiting due to error: %v", el.idx, err)
+	if err != errors.ErrServerShutdown {
+		el.svr.reportErr(err)
+	}
 }
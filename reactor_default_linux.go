@@ -42,6 +42,7 @@ func (el *eventloop) activateMainReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("main reactor is exiting in terms of the demand from user, %v", err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("main reactor is exiting due to error: %v", err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -58,6 +59,10 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 
 	err := el.poller.Polling(func(fd int, ev uint32) error {
 		if c, ack := el.connections[fd]; ack {
+			if proceed, err := el.loopEvent(c, eventFlags(ev)); !proceed {
+				return err
+			}
+
 			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 			// sure what you're doing!
 			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
@@ -69,7 +74,7 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 			// In either case loopWrite() should take care of it properly:
 			// 1) writing data back,
 			// 2) closing the connection.
-			if ev&netpoll.OutEvents != 0 && !c.outboundBuffer.IsEmpty() {
+			if ev&netpoll.OutEvents != 0 && c.writePending() {
 				if err := el.loopWrite(c); err != nil {
 					return err
 				}
@@ -91,6 +96,7 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("event-loop(%d) is exiting in terms of the demand from user, %v", el.idx, err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("event-loop(%d) is exiting normally on the signal error: %v", el.idx, err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -108,6 +114,10 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 
 	err := el.poller.Polling(func(fd int, ev uint32) (err error) {
 		if c, ok := el.connections[fd]; ok {
+			if proceed, err := el.loopEvent(c, eventFlags(ev)); !proceed {
+				return err
+			}
+
 			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 			// sure what you're doing!
 			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
@@ -119,7 +129,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 			// In either case loopWrite() should take care of it properly:
 			// 1) writing data back,
 			// 2) closing the connection.
-			if ev&netpoll.OutEvents != 0 && !c.outboundBuffer.IsEmpty() {
+			if ev&netpoll.OutEvents != 0 && c.writePending() {
 				if err := el.loopWrite(c); err != nil {
 					return err
 				}
@@ -139,4 +149,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 		return el.loopAccept(ev)
 	})
 	el.getLogger().Debugf("event-loop(%d) is exiting due to error: %v", el.idx, err)
+	if err != errors.ErrServerShutdown {
+		el.svr.reportErr(err)
+	}
 }
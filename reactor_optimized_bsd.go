@@ -42,6 +42,7 @@ func (el *eventloop) activateMainReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("main reactor is exiting in terms of the demand from user, %v", err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("main reactor is exiting due to error: %v", err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -61,6 +62,7 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 		el.svr.opts.Logger.Debugf("event-loop(%d) is exiting in terms of the demand from user, %v", el.idx, err)
 	} else if err != nil {
 		el.svr.opts.Logger.Errorf("event-loop(%d) is exiting normally on the signal error: %v", el.idx, err)
+		el.svr.reportErr(err)
 	}
 }
 
@@ -78,4 +80,7 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 
 	err := el.poller.Polling()
 	el.getLogger().Debugf("event-loop(%d) is exiting due to error: %v", el.idx, err)
+	if err != errors.ErrServerShutdown {
+		el.svr.reportErr(err)
+	}
 }
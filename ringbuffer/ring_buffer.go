@@ -375,6 +375,28 @@ func (r *RingBuffer) Reset() {
 	r.r, r.w = 0, 0
 }
 
+// Shrink reallocates the buffer with the smallest capacity of power of two that holds the unread data, which is no
+// less than the default size, it releases the excess capacity grown by bursts and returns the number of bytes released.
+func (r *RingBuffer) Shrink() int {
+	oldLen := r.Length()
+	newCap := defaultBufferSize
+	if oldLen > newCap {
+		newCap = internal.CeilToPowerOfTwo(oldLen)
+	}
+	if r.size <= newCap {
+		return 0
+	}
+	released := r.size - newCap
+	newBuf := make([]byte, newCap)
+	_, _ = r.Read(newBuf)
+	r.buf = newBuf
+	r.r = 0
+	r.w = oldLen % newCap // wrap around when the new buffer is full
+	r.size = newCap
+	r.isEmpty = oldLen == 0
+	return released
+}
+
 func (r *RingBuffer) grow(newCap int) {
 	if n := r.size; n == 0 {
 		if newCap <= defaultBufferSize {
@@ -194,6 +194,36 @@ func TestRingBufferGrow(t *testing.T) {
 	assert.EqualValues(t, append(data, newData...), rb.ByteBuffer().Bytes())
 }
 
+func TestRingBufferShrink(t *testing.T) {
+	rb := New(0)
+	assert.EqualValues(t, 0, rb.Shrink())
+
+	data := make([]byte, 8*defaultBufferSize)
+	_, err := rand.Read(data)
+	assert.NoError(t, err, "failed to generate random data")
+	_, _ = rb.Write(data)
+	assert.EqualValues(t, 8*defaultBufferSize, rb.Cap())
+	assert.EqualValues(t, 0, rb.Shrink())
+
+	rb.Discard(6 * defaultBufferSize)
+	assert.EqualValues(t, 6*defaultBufferSize, rb.Shrink())
+	assert.EqualValues(t, 2*defaultBufferSize, rb.Cap())
+	assert.EqualValues(t, 2*defaultBufferSize, rb.Length())
+	assert.True(t, rb.IsFull())
+	assert.EqualValues(t, data[6*defaultBufferSize:], rb.ByteBuffer().Bytes())
+
+	rb.Discard(2*defaultBufferSize - 10)
+	assert.EqualValues(t, defaultBufferSize, rb.Shrink())
+	assert.EqualValues(t, defaultBufferSize, rb.Cap())
+	assert.EqualValues(t, data[len(data)-10:], rb.ByteBuffer().Bytes())
+	_, _ = rb.Write(data[:defaultBufferSize-10])
+	assert.True(t, rb.IsFull())
+
+	rb.Reset()
+	assert.EqualValues(t, 0, rb.Shrink())
+	assert.True(t, rb.IsEmpty())
+}
+
 func TestRingBuffer_Read(t *testing.T) {
 	rb := New(64)
 
@@ -25,15 +25,23 @@ package gnet
 
 import (
 	"context"
+	"hash/crc32"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/internal/netpoll"
 )
 
 type server struct {
+	// the 64-bit fields accessed atomically must stay at the front for the alignment on 32-bit platforms.
+	poolCounters poolCounters       // backpressure applied due to the saturated worker pool
+	errDropped   uint64             // number of errors dropped due to the full ErrChan
+	connLogSeq   uint64             // number of connection lifecycle log lines sampled
+	bufferMemory int64              // number of bytes buffered by all connections
 	ln           *listener          // the listener for accepting new connections
 	lb           loadBalancer       // event-loops for handling events
 	wg           sync.WaitGroup     // event-loop close WaitGroup
@@ -41,8 +49,18 @@ type server struct {
 	once         sync.Once          // make sure only signalShutdown once
 	cond         *sync.Cond         // shutdown signaler
 	codec        ICodec             // codec for TCP stream
+	metrics      metricsCollector   // traffic aggregated by connection labels
+	sessions     sessionRegistry    // connections keyed by the identities of clients
+	conns        sync.Map           // active TCP connections keyed by their identifiers with ConnRegistry
+	connTotal    int32              // number of connections counted for IdleHandler
+	acceptBatch  acceptBatch        // accepted sockets staged for their event-loops by AcceptBatchSize
 	mainLoop     *eventloop         // main event-loop for accepting connections
+	scaleLock    sync.Mutex         // serializes the scaling of event-loops with the shutdown
 	inShutdown   int32              // whether the server is in shutdown
+	serving      int32              // whether the server is serving, it is cleared once the server starts draining
+	acceptPaused int32              // whether accepting new connections is paused due to the file descriptor limit
+	fdLimit      int                // RLIMIT_NOFILE soft limit sampled when the server starts
+	acceptQueued int32              // whether the accept queue has reached the threshold
 	tickerCtx    context.Context    // context for ticker
 	cancelTicker context.CancelFunc // function to stop the ticker
 	eventHandler EventHandler       // user eventHandler
@@ -52,6 +70,22 @@ func (svr *server) isInShutdown() bool {
 	return atomic.LoadInt32(&svr.inShutdown) == 1
 }
 
+func (svr *server) isServing() bool {
+	return atomic.LoadInt32(&svr.serving) == 1
+}
+
+// reportErr publishes the error to ErrChan without blocking, the error is dropped if the channel is full.
+func (svr *server) reportErr(err error) {
+	if err == nil || svr.opts.ErrChan == nil {
+		return
+	}
+	select {
+	case svr.opts.ErrChan <- err:
+	default:
+		atomic.AddUint64(&svr.errDropped, 1)
+	}
+}
+
 // waitForShutdown waits for a signal to shutdown.
 func (svr *server) waitForShutdown() {
 	svr.cond.L.Lock()
@@ -88,13 +122,152 @@ func (svr *server) closeEventLoops() {
 
 func (svr *server) startSubReactors() {
 	svr.lb.iterate(func(i int, el *eventloop) bool {
-		svr.wg.Add(1)
-		go func() {
-			el.activateSubReactor(svr.opts.LockOSThread)
-			svr.wg.Done()
-		}()
+		svr.startSubReactor(el)
+		return true
+	})
+}
+
+func (svr *server) startSubReactor(el *eventloop) {
+	svr.wg.Add(1)
+	go func() {
+		el.activateSubReactor(svr.opts.LockOSThread)
+		svr.wg.Done()
+	}()
+}
+
+func (svr *server) openSubReactor() (*eventloop, error) {
+	p, err := netpoll.OpenPoller()
+	if err != nil {
+		return nil, err
+	}
+	el := new(eventloop)
+	el.ln = svr.ln
+	el.svr = svr
+	el.poller = p
+	p.OnWake(el.tickClock)
+	el.buffer = make([]byte, svr.opts.ReadBufferCap)
+	el.connections = make(map[int]*conn)
+	el.eventHandler = svr.eventHandler
+	return el, nil
+}
+
+// scaleEventLoops sets the number of sub-reactors eligible for new connections, the connections of the parked
+// sub-reactors are migrated to the remaining ones, the parked sub-reactors are put back into service first and
+// new ones are only spun up after that.
+func (svr *server) scaleEventLoops(n int) error {
+	if n < 1 {
+		return errors.ErrInvalidNumEventLoop
+	}
+	if svr.mainLoop == nil {
+		return errors.ErrUnsupportedOp
+	}
+	if svr.opts.LockOSThread && n > 10000 {
+		return errors.ErrTooManyEventLoopThreads
+	}
+
+	svr.scaleLock.Lock()
+	defer svr.scaleLock.Unlock()
+	if !svr.isServing() {
+		return errors.ErrServerInShutdown
+	}
+
+	total := svr.lb.len()
+	if n <= total {
+		svr.lb.scale(n)
+		// Hand the connections of the parked event-loops over to the ones remaining in service.
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			if i >= n {
+				_ = el.poller.Trigger(el.loopDrain, nil)
+			}
+			return true
+		})
+		return nil
+	}
+	svr.lb.scale(total)
+	for i := total; i < n; i++ {
+		el, err := svr.openSubReactor()
+		if err != nil {
+			return err
+		}
+		svr.lb.register(el)
+		svr.startSubReactor(el)
+	}
+	return nil
+}
+
+// drainLoop takes the event-loop of the given index out of service for new connections and migrates its
+// connections to the event-loops eligible for new connections until none is left, the connections still
+// left when ctx is done are closed.
+func (svr *server) drainLoop(ctx context.Context, index int) error {
+	if svr.mainLoop == nil {
+		return errors.ErrUnsupportedOp
+	}
+
+	svr.scaleLock.Lock()
+	if !svr.isServing() {
+		svr.scaleLock.Unlock()
+		return errors.ErrServerInShutdown
+	}
+	var (
+		drained  *eventloop
+		eligible int
+		active   = svr.lb.active()
+	)
+	svr.lb.iterate(func(i int, el *eventloop) bool {
+		if i == index {
+			drained = el
+		} else if i < active && !el.isDraining() {
+			eligible++
+		}
 		return true
 	})
+	if drained == nil {
+		svr.scaleLock.Unlock()
+		return errors.ErrInvalidLoopIndex
+	}
+	if eligible == 0 {
+		svr.scaleLock.Unlock()
+		return errors.ErrLastEventLoop
+	}
+	atomic.StoreInt32(&drained.draining, 1)
+	svr.scaleLock.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	// The connections handed over to the event-loop before it was taken out of service are migrated
+	// in the following rounds.
+	for drained.loadConn() > 0 {
+		if err := drained.poller.Trigger(drained.loopDrain, nil); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			_ = drained.poller.Trigger(func(_ interface{}) error {
+				for _, c := range drained.connections {
+					if err := drained.loopCloseConn(c, nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, nil)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// loopOfToken maps the resume token to one of the event-loops eligible for new connections.
+func (svr *server) loopOfToken(token string) (target *eventloop) {
+	idx := int(crc32.ChecksumIEEE(internal.StringToBytes(token)) % uint32(svr.lb.active()))
+	svr.lb.iterate(func(i int, el *eventloop) bool {
+		if i == idx {
+			target = el
+			return false
+		}
+		return true
+	})
+	return
 }
 
 func (svr *server) activateEventLoops(numEventLoop int) (err error) {
@@ -114,7 +287,12 @@ func (svr *server) activateEventLoops(numEventLoop int) (err error) {
 			el.ln = ln
 			el.svr = svr
 			el.poller = p
-			el.buffer = make([]byte, svr.opts.ReadBufferCap)
+			p.OnWake(el.tickClock)
+			if ln.network == "udp" {
+				el.buffer = make([]byte, svr.opts.UDPMaxDatagramSize)
+			} else {
+				el.buffer = make([]byte, svr.opts.ReadBufferCap)
+			}
 			el.connections = make(map[int]*conn)
 			el.eventHandler = svr.eventHandler
 			_ = el.poller.AddRead(el.ln.packPollAttachment(el.loopAccept))
@@ -139,18 +317,11 @@ func (svr *server) activateEventLoops(numEventLoop int) (err error) {
 
 func (svr *server) activateReactors(numEventLoop int) error {
 	for i := 0; i < numEventLoop; i++ {
-		if p, err := netpoll.OpenPoller(); err == nil {
-			el := new(eventloop)
-			el.ln = svr.ln
-			el.svr = svr
-			el.poller = p
-			el.buffer = make([]byte, svr.opts.ReadBufferCap)
-			el.connections = make(map[int]*conn)
-			el.eventHandler = svr.eventHandler
-			svr.lb.register(el)
-		} else {
+		el, err := svr.openSubReactor()
+		if err != nil {
 			return err
 		}
+		svr.lb.register(el)
 	}
 
 	// Start sub reactors in background.
@@ -162,6 +333,7 @@ func (svr *server) activateReactors(numEventLoop int) error {
 		el.idx = -1
 		el.svr = svr
 		el.poller = p
+		p.OnWake(el.tickClock)
 		el.eventHandler = svr.eventHandler
 		_ = el.poller.AddRead(svr.ln.packPollAttachment(svr.acceptNewConnection))
 		svr.mainLoop = el
@@ -176,16 +348,29 @@ func (svr *server) activateReactors(numEventLoop int) error {
 		return err
 	}
 
-	// Start the ticker.
+	// Start the ticker, which is moved off the main reactor if it is dedicated to accepting.
 	if svr.opts.Ticker {
-		go svr.mainLoop.loopTicker(svr.tickerCtx)
+		striker := svr.mainLoop
+		if svr.opts.DedicatedAcceptLoop {
+			svr.lb.iterate(func(i int, el *eventloop) bool {
+				striker = el
+				return false
+			})
+		}
+		go striker.loopTicker(svr.tickerCtx)
 	}
 
 	return nil
 }
 
+// reusePort reports whether every event-loop accepts connections on a listener of its own with SO_REUSEPORT rather
+// than having the main reactor accept them, which is always the case for UDP servers.
+func (svr *server) reusePort() bool {
+	return (svr.opts.ReusePort && !svr.opts.DedicatedAcceptLoop) || svr.ln.network == "udp"
+}
+
 func (svr *server) start(numEventLoop int) error {
-	if svr.opts.ReusePort || svr.ln.network == "udp" {
+	if svr.reusePort() {
 		return svr.activateEventLoops(numEventLoop)
 	}
 
@@ -195,9 +380,15 @@ func (svr *server) start(numEventLoop int) error {
 func (svr *server) stop(s Server) {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
+	svr.scaleLock.Lock()
+	atomic.StoreInt32(&svr.serving, 0)
+	svr.scaleLock.Unlock()
 
 	svr.eventHandler.OnShutdown(s)
 
+	// The sockets staged by AcceptBatchSize are not going to be served.
+	svr.acceptBatch.close()
+
 	// Notify all loops to close by closing all listeners
 	svr.lb.iterate(func(i int, el *eventloop) bool {
 		err := el.poller.UrgentTrigger(func(_ interface{}) error { return errors.ErrServerShutdown }, nil)
@@ -235,7 +426,7 @@ func (svr *server) stop(s Server) {
 	atomic.StoreInt32(&svr.inShutdown, 1)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string) error {
+func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string, started func()) error {
 	// Figure out the proper number of event-loops/goroutines to run.
 	numEventLoop := 1
 	if options.Multicore {
@@ -250,21 +441,15 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	svr.eventHandler = eventHandler
 	svr.ln = listener
 
-	switch options.LB {
-	case RoundRobin:
-		svr.lb = new(roundRobinLoadBalancer)
-	case LeastConnections:
-		svr.lb = new(leastConnectionsLoadBalancer)
-	case SourceAddrHash:
-		svr.lb = new(sourceAddrHashLoadBalancer)
-	}
+	svr.lb = newSwitchableLoadBalancer(options.LB)
 
+	svr.metrics.initHistograms(options)
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	if svr.opts.Ticker {
 		svr.tickerCtx, svr.cancelTicker = context.WithCancel(context.Background())
 	}
 	svr.codec = func() ICodec {
-		if options.Codec == nil {
+		if options.Codec == nil || options.RawMode {
 			return new(BuiltInFrameCodec)
 		}
 		return options.Codec
@@ -284,14 +469,33 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 		return nil
 	}
 
+	fdLimited := options.FdLimitThreshold > 0 && listener.network != "udp"
+	if fdLimited {
+		svr.initFdLimit()
+	}
 	if err := svr.start(numEventLoop); err != nil {
 		svr.closeEventLoops()
 		svr.opts.Logger.Errorf("gnet server is stopping with error: %v", err)
 		return err
 	}
+	atomic.StoreInt32(&svr.serving, 1)
 	defer svr.stop(server)
 
+	interval := svr.housekeepingInterval()
+	if fdLimited {
+		svr.sampleFdUsage()
+		if interval == 0 || interval > fdMonitorInterval {
+			interval = fdMonitorInterval
+		}
+	}
+	if interval > 0 && listener.network != "udp" {
+		go svr.housekeep(interval)
+	}
+
 	allServers.Store(protoAddr, svr)
+	if started != nil {
+		started()
+	}
 
 	return nil
 }
@@ -36,6 +36,11 @@ var errCloseAllConns = errors.New("close all connections in event-loop")
 const TaskBufferCap = 256
 
 type server struct {
+	// the 64-bit fields accessed atomically must stay at the front for the alignment on 32-bit platforms.
+	poolCounters poolCounters       // backpressure applied due to the saturated worker pool
+	errDropped   uint64             // number of errors dropped due to the full ErrChan
+	connLogSeq   uint64             // number of connection lifecycle log lines sampled
+	bufferMemory int64              // number of bytes buffered by all connections, always 0 on Windows
 	ln           *listener          // the listeners for accepting new connections
 	lb           loadBalancer       // event-loops for handling events
 	cond         *sync.Cond         // shutdown signaler
@@ -43,9 +48,14 @@ type server struct {
 	serr         error              // signal error
 	once         sync.Once          // make sure only signalShutdown once
 	codec        ICodec             // codec for TCP stream
+	metrics      metricsCollector   // traffic aggregated by connection labels
+	sessions     sessionRegistry    // connections keyed by the identities of clients
+	conns        sync.Map           // active TCP connections keyed by their identifiers with ConnRegistry
+	connTotal    int32              // number of connections counted for IdleHandler
 	loopWG       sync.WaitGroup     // loop close WaitGroup
 	listenerWG   sync.WaitGroup     // listener close WaitGroup
 	inShutdown   int32              // whether the server is in shutdown
+	serving      int32              // whether the server is serving, it is cleared once the server starts draining
 	tickerCtx    context.Context    // context for ticker
 	cancelTicker context.CancelFunc // function to stop the ticker
 	eventHandler EventHandler       // user eventHandler
@@ -55,6 +65,22 @@ func (svr *server) isInShutdown() bool {
 	return atomic.LoadInt32(&svr.inShutdown) == 1
 }
 
+func (svr *server) isServing() bool {
+	return atomic.LoadInt32(&svr.serving) == 1
+}
+
+// reportErr publishes the error to ErrChan without blocking, the error is dropped if the channel is full.
+func (svr *server) reportErr(err error) {
+	if err == nil || svr.opts.ErrChan == nil {
+		return
+	}
+	select {
+	case svr.opts.ErrChan <- err:
+	default:
+		atomic.AddUint64(&svr.errDropped, 1)
+	}
+}
+
 // waitForShutdown waits for a signal to shutdown.
 func (svr *server) waitForShutdown() error {
 	svr.cond.L.Lock()
@@ -92,6 +118,7 @@ func (svr *server) startEventLoops(numEventLoop int) {
 	for i := 0; i < numEventLoop; i++ {
 		el := new(eventloop)
 		el.ch = make(chan interface{}, channelBuffer(TaskBufferCap))
+		el.done = make(chan struct{})
 		el.svr = svr
 		el.connections = make(map[*stdConn]struct{})
 		el.eventHandler = svr.eventHandler
@@ -111,9 +138,20 @@ func (svr *server) startEventLoops(numEventLoop int) {
 	go striker.loopTicker(svr.tickerCtx)
 }
 
+// scaleEventLoops is not supported on Windows yet.
+func (svr *server) scaleEventLoops(_ int) error {
+	return gerrors.ErrUnsupportedOp
+}
+
+// drainLoop is not supported on Windows yet.
+func (svr *server) drainLoop(_ context.Context, _ int) error {
+	return gerrors.ErrUnsupportedOp
+}
+
 func (svr *server) stop(s Server) {
 	// Wait on a signal for shutdown.
 	svr.opts.Logger.Infof("Server is being shutdown on the signal error: %v", svr.waitForShutdown())
+	atomic.StoreInt32(&svr.serving, 0)
 
 	svr.eventHandler.OnShutdown(s)
 
@@ -146,7 +184,8 @@ func (svr *server) stop(s Server) {
 	atomic.StoreInt32(&svr.inShutdown, 1)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string) (err error) {
+func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string,
+	started func()) (err error) {
 	// Figure out the correct number of loops/goroutines to use.
 	numEventLoop := 1
 	if options.Multicore {
@@ -161,21 +200,15 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	svr.eventHandler = eventHandler
 	svr.ln = listener
 
-	switch options.LB {
-	case RoundRobin:
-		svr.lb = new(roundRobinLoadBalancer)
-	case LeastConnections:
-		svr.lb = new(leastConnectionsLoadBalancer)
-	case SourceAddrHash:
-		svr.lb = new(sourceAddrHashLoadBalancer)
-	}
+	svr.lb = newSwitchableLoadBalancer(options.LB)
 
 	if svr.opts.Ticker {
 		svr.tickerCtx, svr.cancelTicker = context.WithCancel(context.Background())
 	}
+	svr.metrics.initHistograms(options)
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.codec = func() ICodec {
-		if options.Codec == nil {
+		if options.Codec == nil || options.RawMode {
 			return new(BuiltInFrameCodec)
 		}
 		return options.Codec
@@ -186,7 +219,7 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 		Multicore:    options.Multicore,
 		Addr:         listener.lnaddr,
 		NumEventLoop: numEventLoop,
-		ReusePort:    options.ReusePort,
+		ReusePort:    false, // SO_REUSEPORT isn't supported on Windows
 		TCPKeepAlive: options.TCPKeepAlive,
 	}
 	switch svr.eventHandler.OnInitComplete(server) {
@@ -200,10 +233,18 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 
 	// Start listener in background.
 	svr.startListener()
+	atomic.StoreInt32(&svr.serving, 1)
 
 	defer svr.stop(server)
 
+	if interval := svr.housekeepingInterval(); interval > 0 && listener.pconn == nil {
+		go svr.housekeep(interval)
+	}
+
 	allServers.Store(protoAddr, svr)
+	if started != nil {
+		started()
+	}
 
 	return
 }
@@ -224,3 +265,11 @@ func channelBuffer(n int) int {
 	// which makes it the best choice as the channel capacity,
 	return n
 }
+
+// relieveFdLimit does nothing as the option FdLimitThreshold is not supported on Windows.
+func (svr *server) relieveFdLimit() {}
+
+// reusePort always reports false as SO_REUSEPORT isn't supported on Windows.
+func (svr *server) reusePort() bool {
+	return false
+}
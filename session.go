@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// sessionRegistry maps the identities of clients to their only active connections.
+type sessionRegistry struct {
+	size       int32
+	mu         sync.Mutex
+	conns      map[string]Conn
+	identities map[Conn]string
+}
+
+// releasable is implemented by the connections which can tell whether they have been closed.
+type releasable interface {
+	isReleased() bool
+}
+
+// replace registers the connection under the identity and closes the connection previously registered under it.
+func (sr *sessionRegistry) replace(identity string, c Conn) (old Conn, existed bool) {
+	sr.mu.Lock()
+	if sr.conns == nil {
+		sr.conns = make(map[string]Conn)
+		sr.identities = make(map[Conn]string)
+	}
+	if prev, ok := sr.identities[c]; ok && prev != identity {
+		delete(sr.conns, prev)
+	}
+	old, existed = sr.conns[identity]
+	if existed {
+		delete(sr.identities, old)
+	}
+	sr.conns[identity] = c
+	sr.identities[c] = identity
+	if r, ok := c.(releasable); ok && r.isReleased() {
+		// The connection has been closed and unregistered before being registered here, thus no one else
+		// removes it, it still takes the place of the previous one but mustn't be left behind.
+		delete(sr.conns, identity)
+		delete(sr.identities, c)
+	}
+	atomic.StoreInt32(&sr.size, int32(len(sr.conns)))
+	sr.mu.Unlock()
+
+	if existed && old != c {
+		_ = old.Close()
+	}
+	return
+}
+
+// remove unregisters the connection which is being closed.
+func (sr *sessionRegistry) remove(c Conn) {
+	if atomic.LoadInt32(&sr.size) == 0 {
+		return
+	}
+	sr.mu.Lock()
+	if identity, ok := sr.identities[c]; ok {
+		delete(sr.identities, c)
+		delete(sr.conns, identity)
+		atomic.StoreInt32(&sr.size, int32(len(sr.conns)))
+	}
+	sr.mu.Unlock()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// drainConns writes ShutdownFrame to the connections of the event-loop that is shutting down, and then waits up to
+// the grace period for their outbound data to be flushed, without reading them any more.
+func (el *eventloop) drainConns(grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	if frame := el.svr.opts.ShutdownFrame; frame != nil {
+		for _, c := range el.connections {
+			_ = c.writeFrame(frame)
+		}
+	}
+
+	var (
+		fds   []unix.PollFd
+		conns []*conn
+	)
+	for {
+		fds, conns = fds[:0], conns[:0]
+		for _, c := range el.connections {
+			if !c.outboundBuffer.IsEmpty() {
+				fds = append(fds, unix.PollFd{Fd: int32(c.fd), Events: unix.POLLOUT})
+				conns = append(conns, c)
+			}
+		}
+		timeout := time.Until(deadline)
+		if len(fds) == 0 || timeout <= 0 {
+			return
+		}
+		n, err := unix.Poll(fds, int(timeout/time.Millisecond)+1)
+		if err != nil && err != unix.EINTR {
+			return
+		}
+		for i := 0; n > 0 && i < len(fds); i++ {
+			if fds[i].Revents != 0 {
+				_ = el.loopWrite(conns[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"encoding"
+	"encoding/binary"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// connStateVersion is the version of the format of the state exported by Conn.ExportState.
+const connStateVersion = 1
+
+// encodeConnState serializes the buffered data of a connection along with the state of the application:
+// a version byte followed by the inbound data, the outbound data and the application state,
+// each of which is prefixed by its length as an uvarint.
+func encodeConnState(inbound [][]byte, outbound [][]byte, ctx interface{}) ([]byte, error) {
+	var app []byte
+	if m, ok := ctx.(encoding.BinaryMarshaler); ok {
+		var err error
+		if app, err = m.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	state := []byte{connStateVersion}
+	var lb [binary.MaxVarintLen64]byte
+	for _, chunks := range [][][]byte{inbound, outbound, {app}} {
+		var size int
+		for _, chunk := range chunks {
+			size += len(chunk)
+		}
+		state = append(state, lb[:binary.PutUvarint(lb[:], uint64(size))]...)
+		for _, chunk := range chunks {
+			state = append(state, chunk...)
+		}
+	}
+	return state, nil
+}
+
+// decodeConnState parses the state serialized by encodeConnState.
+func decodeConnState(state []byte) (inbound, outbound, app []byte, err error) {
+	if len(state) == 0 || state[0] != connStateVersion {
+		return nil, nil, nil, errors.ErrInvalidConnState
+	}
+	state = state[1:]
+	var fields [3][]byte
+	for i := range fields {
+		size, n := binary.Uvarint(state)
+		if n <= 0 || size > uint64(len(state)-n) {
+			return nil, nil, nil, errors.ErrInvalidConnState
+		}
+		fields[i] = state[n : n+int(size)]
+		state = state[n+int(size):]
+	}
+	return fields[0], fields[1], fields[2], nil
+}
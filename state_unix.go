@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"github.com/panjf2000/gnet/internal/socket"
+)
+
+func (c *conn) ExportState() ([]byte, error) {
+	if c.pollAttachment == nil {
+		return nil, gerrors.ErrUnsupportedOp
+	}
+	head, tail := c.inboundBuffer.PeekAll()
+	outHead, outTail := c.outboundBuffer.PeekAll()
+	return encodeConnState([][]byte{head, tail, c.buffer}, [][]byte{outHead, outTail}, c.ctx)
+}
+
+func (c *conn) DupFd() (int, error) {
+	if c.pollAttachment == nil {
+		return -1, gerrors.ErrUnsupportedOp
+	}
+	fd, _, err := netpoll.Dup(c.fd)
+	return fd, err
+}
+
+// importConn takes over the connection of fd and restores the state exported by Conn.ExportState.
+func (svr *server) importConn(fd int, state []byte, restore func(c Conn, appState []byte)) error {
+	inbound, outbound, app, err := decodeConnState(state)
+	if err != nil {
+		return err
+	}
+	sa, err := unix.Getpeername(fd)
+	if err != nil {
+		return os.NewSyscallError("getpeername", err)
+	}
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(fd, true)); err != nil {
+		return err
+	}
+
+	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
+	el := svr.lb.next(netAddr)
+	c := newTCPConn(fd, el, sa, netAddr)
+	_, _ = c.inboundBuffer.Write(inbound)
+	_, _ = c.outboundBuffer.Write(outbound)
+	if err = el.poller.Trigger(func(_ interface{}) error {
+		return el.loopImport(c, app, restore)
+	}, nil); err != nil {
+		c.releaseTCP()
+	}
+	return err
+}
+
+// loopImport registers the imported connection, the inbound data restored from the exported state is decoded
+// right after OnOpened.
+func (el *eventloop) loopImport(c *conn, app []byte, restore func(c Conn, appState []byte)) error {
+	var err error
+	if c.outboundBuffer.IsEmpty() {
+		err = el.poller.AddRead(c.pollAttachment)
+	} else {
+		err = el.poller.AddReadWrite(c.pollAttachment)
+	}
+	if err != nil {
+		_ = unix.Close(c.fd)
+		c.releaseTCP()
+		return nil
+	}
+	el.connections[c.fd] = c
+	if restore != nil {
+		restore(c, app)
+	}
+	if err = el.loopOpen(c); err != nil || !c.opened || c.inboundBuffer.IsEmpty() {
+		return err
+	}
+	c.buffer = el.buffer[:0]
+	return el.loopReact(c)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/errors"
+)
+
+// maxSendfileChunk is the maximum number of bytes sent by a single sendfile(2), which keeps a transfer to a fast
+// peer from monopolizing the event-loop.
+const maxSendfileChunk = 4 << 20
+
+// fileTransfer is a file being sent to a connection by Conn.ServeFile.
+type fileTransfer struct {
+	file      *os.File
+	offset    int64
+	remaining int64
+	done      func(c Conn, err error)
+	tail      []byte // data written to the connection during the transfer, sent after the file
+}
+
+func (c *conn) ServeFile(path string, offset, length int64, done func(c Conn, err error)) error {
+	if c.pollAttachment == nil {
+		return gerrors.ErrUnsupportedOp
+	}
+	if c.transfer != nil {
+		return gerrors.ErrFileTransferInProgress
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		if length = fi.Size() - offset; length < 0 {
+			length = 0
+		}
+	}
+	c.transfer = &fileTransfer{file: f, offset: offset, remaining: length, done: done}
+
+	// The file is sent after the data pending in the outbound buffer.
+	if !c.outboundBuffer.IsEmpty() {
+		return nil
+	}
+	return c.owner().loopTransfer(c)
+}
+
+// loopTransfer sends the file to the connection until the socket send buffer is full, the rest of the file is sent
+// on the subsequent writable events.
+func (el *eventloop) loopTransfer(c *conn) error {
+	t := c.transfer
+	fd := int(t.file.Fd())
+	for t.remaining > 0 {
+		chunk := t.remaining
+		if chunk > maxSendfileChunk {
+			chunk = maxSendfileChunk
+		}
+		el.eventHandler.PreWrite()
+		// Only some of the platforms advance the offset, thus it is maintained here.
+		offset := t.offset
+		n, err := unix.Sendfile(c.fd, fd, &offset, int(chunk))
+		if n > 0 {
+			t.offset += int64(n)
+			t.remaining -= int64(n)
+			c.addWritten(n, el.clock)
+		}
+		switch err {
+		case nil:
+			// The file is shorter than expected.
+			if n == 0 {
+				return el.finishTransfer(c, io.ErrUnexpectedEOF)
+			}
+		case unix.EINTR:
+		case unix.EAGAIN:
+			return c.watchWrite()
+		default:
+			err = os.NewSyscallError("sendfile", err)
+			_ = el.finishTransfer(c, err)
+			return el.loopCloseConn(c, err)
+		}
+	}
+	return el.finishTransfer(c, nil)
+}
+
+// finishTransfer releases the file and fires the completion callback, the data written during the transfer is
+// sent afterwards, even if the transfer fails, as long as the connection is kept open, e.g. when the file turns
+// out to be shorter than expected.
+func (el *eventloop) finishTransfer(c *conn, err error) error {
+	t := c.transfer
+	c.transfer = nil
+	_ = t.file.Close()
+	keepOpen := err == nil || err == io.ErrUnexpectedEOF
+	if keepOpen && len(t.tail) > 0 {
+		c.bufferOutbound(t.tail)
+	}
+	if t.done != nil {
+		t.done(c, err)
+	}
+	if !c.opened || !keepOpen {
+		return nil
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		return c.watchWrite()
+	}
+	return el.loopWritten(c)
+}
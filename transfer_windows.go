@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// fileTransfer is a file being sent to a connection by Conn.ServeFile.
+type fileTransfer struct {
+	done func(c Conn, err error)
+	tail []byte // data written to the connection during the transfer, sent after the file
+}
+
+// ServeFile copies the file to the connection on a separate goroutine on Windows, where the data is written to
+// the socket synchronously, thus the event-loop isn't blocked by the transfer, TCP connections send it by
+// TransmitFile.
+func (c *stdConn) ServeFile(path string, offset, length int64, done func(c Conn, err error)) error {
+	if c.conn == nil {
+		return errors.ErrUnsupportedOp
+	}
+	if c.transfer != nil {
+		return errors.ErrFileTransferInProgress
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		if length = fi.Size() - offset; length < 0 {
+			length = 0
+		}
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	t := &fileTransfer{done: done}
+	c.transfer = t
+	el := c.loop
+	el.eventHandler.PreWrite()
+	go func(conn net.Conn) {
+		n, err := io.Copy(conn, io.LimitReader(f, length))
+		_ = f.Close()
+		if err == nil && n < length {
+			err = io.ErrUnexpectedEOF
+		}
+		task := signalTaskPool.Get().(*signalTask)
+		task.run = func(c *stdConn) error { return el.finishTransfer(c, t, int(n), err) }
+		task.c = c
+		if !el.trigger(task) {
+			signalTaskPool.Put(task)
+		}
+	}(c.conn)
+	return nil
+}
+
+// finishTransfer fires the completion callback of the transfer and sends the data written during the transfer
+// afterwards, even if the transfer fails, unless the connection is being closed. It ignores the stale transfer
+// which has been aborted as the connection is closed.
+func (el *eventloop) finishTransfer(c *stdConn, t *fileTransfer, n int, err error) error {
+	if c.transfer != t {
+		return nil // stale transfer
+	}
+	c.transfer = nil
+	c.addWritten(n, time.Now())
+	if t.done != nil {
+		t.done(c, err)
+	}
+	if len(t.tail) > 0 && !c.closing && err != errors.ErrFileTransferAborted {
+		if _, err = c.write(t.tail); err != nil {
+			return el.loopError(c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/pool/goroutine"
+)
+
+// asyncRetryInterval is how long a connection stays paused before handing its frames over to the worker pool again.
+const asyncRetryInterval = 10 * time.Millisecond
+
+// WorkerPoolStats is a snapshot of the worker pool running React in async mode.
+type WorkerPoolStats struct {
+	// Running is the number of the running workers.
+	Running int
+
+	// Free is the number of the available workers, the pool is saturated when it drops to zero.
+	Free int
+
+	// Rejected is the total number of times that the pool was too busy to take the frames of a connection.
+	Rejected uint64
+
+	// PausedConnections is the number of connections whose reads are paused until the pool catches up.
+	PausedConnections int64
+}
+
+// poolCounters counts the backpressure applied to connections due to the saturated worker pool.
+type poolCounters struct {
+	rejected uint64
+	paused   int64
+}
+
+func (pc *poolCounters) snapshot(pool *goroutine.Pool) WorkerPoolStats {
+	stats := WorkerPoolStats{
+		Rejected:          atomic.LoadUint64(&pc.rejected),
+		PausedConnections: atomic.LoadInt64(&pc.paused),
+	}
+	if pool != nil {
+		stats.Running, stats.Free = pool.Running(), pool.Free()
+	}
+	return stats
+}
+
+// asyncReactor runs React for the frames of a connection on the worker pool, the frames are handed over by
+// the event-loop in order and reacted to in the same order by at most one worker at a time.
+type asyncReactor struct {
+	c            Conn
+	eventHandler EventHandler
+	pool         *goroutine.Pool
+	counters     *poolCounters
+	shutdown     func()
+	maxQueued    int
+
+	mu          sync.Mutex
+	frames      [][]byte
+	outstanding int
+	running     bool
+	stopped     bool
+}
+
+func newAsyncReactor(c Conn, eventHandler EventHandler, pool *goroutine.Pool, counters *poolCounters,
+	maxQueued int, shutdown func()) *asyncReactor {
+	return &asyncReactor{
+		c:            c,
+		eventHandler: eventHandler,
+		pool:         pool,
+		counters:     counters,
+		maxQueued:    maxQueued,
+		shutdown:     shutdown,
+	}
+}
+
+// push queues a copy of the frame and hands the queue over to the worker pool, it never blocks the event-loop and
+// reports false if the pool is saturated, in which case the frame stays in the queue until the next push or flush,
+// or if there are too many frames outstanding.
+func (ar *asyncReactor) push(frame []byte) bool {
+	frame = append([]byte(nil), frame...)
+	ar.mu.Lock()
+	ar.frames = append(ar.frames, frame)
+	ar.outstanding++
+	ar.mu.Unlock()
+	return ar.flush()
+}
+
+// flush hands the queued frames over to the worker pool, it reports false if the pool is saturated or
+// the outstanding frames haven't dropped below the limit.
+func (ar *asyncReactor) flush() bool {
+	ar.mu.Lock()
+	if ar.running || ar.stopped || len(ar.frames) == 0 {
+		ar.mu.Unlock()
+		return !ar.full()
+	}
+	ar.running = true
+	ar.mu.Unlock()
+
+	if err := ar.pool.Submit(ar.run); err != nil {
+		atomic.AddUint64(&ar.counters.rejected, 1)
+		ar.mu.Lock()
+		ar.running = false
+		ar.mu.Unlock()
+		return false
+	}
+	return !ar.full()
+}
+
+// pending reports whether there are frames that haven't been reacted to.
+func (ar *asyncReactor) pending() bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return !ar.stopped && ar.outstanding > 0
+}
+
+// full reports whether the outstanding frames reach the limit.
+func (ar *asyncReactor) full() bool {
+	if ar.maxQueued <= 0 {
+		return false
+	}
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return !ar.stopped && ar.outstanding >= ar.maxQueued
+}
+
+// stop discards the frames that haven't been reacted to.
+func (ar *asyncReactor) stop() {
+	ar.mu.Lock()
+	ar.stopped = true
+	ar.frames = nil
+	ar.mu.Unlock()
+}
+
+func (ar *asyncReactor) run() {
+	for {
+		ar.mu.Lock()
+		if ar.stopped || len(ar.frames) == 0 {
+			ar.running = false
+			ar.mu.Unlock()
+			return
+		}
+		frame := ar.frames[0]
+		ar.frames[0] = nil
+		ar.frames = ar.frames[1:]
+		ar.mu.Unlock()
+
+		ok := reactAsync(ar.eventHandler, ar.c, frame, ar.shutdown)
+		ar.mu.Lock()
+		ar.outstanding--
+		ar.mu.Unlock()
+		if !ok {
+			ar.stop()
+		}
+	}
+}